@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yaxhveer/peernet/pkg"
+)
+
+// checkPollInterval is how often -check looks at the discovery results while waiting.
+const checkPollInterval = time.Second
+
+// runCheck starts peer discovery on a host that has already bootstrapped, waits up
+// to timeout for it to find other PeerNet peers, then prints a connectivity summary
+// and returns the process exit code. Poor connectivity, meaning no connected peers or
+// a DHT too small for discovery, exits non-zero; finding no PeerNet peers or no public
+// address only warns, as both depend on other nodes.
+func runCheck(ctx context.Context, p2pHost *pkg.PeerNetwork, discoveryMethod string, providerLimit int, timeout time.Duration) int {
+	logrus.Infof("Checking connectivity... This takes up to %s.", timeout)
+
+	discoveryErr := connectToPeers(p2pHost, discoveryMethod, providerLimit)
+	if discoveryErr == nil {
+		deadline := time.NewTimer(timeout)
+		defer deadline.Stop()
+		ticker := time.NewTicker(checkPollInterval)
+		defer ticker.Stop()
+
+	wait:
+		for p2pHost.DiscoveredPeers() == 0 {
+			select {
+			case <-ctx.Done():
+				return 1
+			case <-deadline.C:
+				break wait
+			case <-ticker.C:
+			}
+		}
+	}
+
+	status := p2pHost.Status()
+	failed := false
+	report := func(passed, critical bool, name, detail, hint string) {
+		switch {
+		case passed:
+			fmt.Printf("[PASS] %s: %s\n", name, detail)
+		case critical:
+			failed = true
+			fmt.Printf("[FAIL] %s: %s\n       hint: %s\n", name, detail, hint)
+		default:
+			fmt.Printf("[WARN] %s: %s\n       hint: %s\n", name, detail, hint)
+		}
+	}
+
+	report(status.Peers > 0, true, "connections", fmt.Sprintf("%d peers connected", status.Peers),
+		"check your network, firewall and the -bootstrap peers")
+	report(status.DHTReady, true, "DHT", fmt.Sprintf("%d peers in the routing table", p2pHost.SessionStats().RoutingTableSize),
+		"outbound connections may be blocked, or -ready-peers is too high")
+	if discoveryErr != nil {
+		report(false, true, "discovery", discoveryErr.Error(), "try another -discover method, or 'mdns' on a LAN")
+	} else {
+		report(p2pHost.DiscoveredPeers() > 0, false, "discovery", fmt.Sprintf("found %d PeerNet peers", p2pHost.DiscoveredPeers()),
+			"no other node may be online in this -namespace yet")
+	}
+	report(status.Reachable, false, "reachability", fmt.Sprintf("public address observed: %s", yesNo(status.Reachable)),
+		"peers behind NATs may not reach this node; try -relay or forward the -listen port")
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// yesNo formats a boolean as "yes" or "no".
+func yesNo(ok bool) string {
+	if ok {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+)
+
+// loadConfig applies the settings of a TOML config file to the flags of a flag set
+// that were not given on the command line, so command-line flags override the file.
+//
+// Each top-level key is a flag name. Values are strings, booleans, numbers or, for
+// repeatable flags such as 'listen', arrays of strings. Durations are strings like
+// "30s". Syntax errors are reported with their line number, and unknown keys, tables
+// and invalid values with the setting they belong to.
+func loadConfig(fs *flag.FlagSet, path string) error {
+	var settings map[string]any
+	md, err := toml.DecodeFile(path, &settings)
+	if err != nil {
+		return err
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	// Apply the settings in file order, so the first bad one is reported
+	for _, k := range md.Keys() {
+		if len(k) != 1 {
+			continue
+		}
+		key := k[0]
+
+		f := fs.Lookup(key)
+		if f == nil || key == "config" {
+			return fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+		values, err := configValues(settings[key])
+		if err != nil {
+			return fmt.Errorf("%s: invalid value for %q: %w", path, key, err)
+		}
+		if _, repeatable := f.Value.(*stringList); !repeatable && len(values) != 1 {
+			return fmt.Errorf("%s: setting %q takes a single value", path, key)
+		}
+		if explicit[key] {
+			continue
+		}
+		for _, v := range values {
+			if err := fs.Set(key, v); err != nil {
+				return fmt.Errorf("%s: invalid value for %q: %w", path, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configValues converts a decoded config file value into the flag values it stands
+// for: a single value, or one value per element of an array.
+func configValues(value any) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case int64:
+		return []string{strconv.FormatInt(v, 10)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(v, 'g', -1, 64)}, nil
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, element := range v {
+			s, ok := element.(string)
+			if !ok {
+				return nil, errors.New("array elements must be strings")
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("%T is not a string, boolean, number or array of strings", value)
+	}
+}
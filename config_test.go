@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestFlags returns a flag set with one flag of each kind loadConfig handles.
+func newTestFlags() (*flag.FlagSet, *string, *int, *bool, *time.Duration, *stringList) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	var listen stringList
+	user := fs.String("user", "", "")
+	connHigh := fs.Int("conn-high", 0, "")
+	debug := fs.Bool("debug", false, "")
+	backoff := fs.Duration("dial-backoff", 0, "")
+	fs.Var(&listen, "listen", "")
+	fs.String("config", "", "")
+	return fs, user, connHigh, debug, backoff, &listen
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "peernet.toml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	fs, user, connHigh, debug, backoff, listen := newTestFlags()
+	path := writeConfig(t, `# peernet.toml
+user = "alice" # inline comment
+conn-high = 200
+debug = true
+dial-backoff = "2s"
+listen = [
+  "/ip4/127.0.0.1/tcp/4001",
+  "/ip6/::1/tcp/4001",
+]
+`)
+	if err := loadConfig(fs, path); err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if *user != "alice" || *connHigh != 200 || !*debug || *backoff != 2*time.Second {
+		t.Errorf("got user=%q conn-high=%d debug=%t dial-backoff=%s", *user, *connHigh, *debug, *backoff)
+	}
+	want := stringList{"/ip4/127.0.0.1/tcp/4001", "/ip6/::1/tcp/4001"}
+	if !reflect.DeepEqual(*listen, want) {
+		t.Errorf("listen = %v, want %v", *listen, want)
+	}
+}
+
+func TestLoadConfigCommandLineWins(t *testing.T) {
+	fs, user, _, _, _, _ := newTestFlags()
+	if err := fs.Parse([]string{"-user", "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(fs, writeConfig(t, `user = "alice"`)); err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if *user != "bob" {
+		t.Errorf("user = %q, want the command-line value", *user)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown setting":  `nickname = "alice"`,
+		"config setting":   `config = "other.toml"`,
+		"set twice":        "user = \"alice\"\nuser = \"bob\"",
+		"syntax error":     `user = alice`,
+		"array for scalar": `user = ["alice", "bob"]`,
+		"non-string array": `listen = [1, 2]`,
+		"table":            "[user]\nname = \"alice\"",
+		"invalid value":    `conn-high = "many"`,
+	} {
+		fs, _, _, _, _, _ := newTestFlags()
+		if err := loadConfig(fs, writeConfig(t, content)); err == nil {
+			t.Errorf("%s: loadConfig succeeded, want an error", name)
+		}
+	}
+}
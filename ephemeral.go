@@ -0,0 +1,23 @@
+package main
+
+import "flag"
+
+// persistentFlags are the flags that make PeerNet write to disk, cleared in ephemeral mode.
+var persistentFlags = []string{"identity", "history-dir", "peerstore", "outbox", "log-file"}
+
+// disablePersistence clears every flag that makes PeerNet write to disk, whether it was
+// set on the command line or in the config file, and returns the names of the flags
+// that were set.
+func disablePersistence(fs *flag.FlagSet) ([]string, error) {
+	var cleared []string
+	for _, name := range persistentFlags {
+		if fs.Lookup(name).Value.String() == "" {
+			continue
+		}
+		if err := fs.Set(name, ""); err != nil {
+			return nil, err
+		}
+		cleared = append(cleared, "-"+name)
+	}
+	return cleared, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yaxhveer/peernet/pkg"
+)
+
+// pipePeerTimeout bounds how long piped input waits for a peer to join the room, since
+// messages published to a room without peers are lost.
+const pipePeerTimeout = 10 * time.Second
+
+// runHeadless keeps the room running without the chat UI. Room events are written to
+// the log and lines read from stdin are sent to the room. When stdin is a pipe or a
+// file, it returns once all of it has been sent, unless keepAlive is set; otherwise it
+// runs until the context is cancelled.
+func runHeadless(ctx context.Context, chatRoom *pkg.ChatRoom, keepAlive bool) {
+	piped := stdinIsPiped()
+	if piped {
+		waitForPeers(ctx, chatRoom, pipePeerTimeout)
+	}
+
+	sent := make(chan struct{})
+	go func() {
+		sendLines(chatRoom)
+		close(sent)
+	}()
+
+	if !piped || keepAlive {
+		<-ctx.Done()
+		return
+	}
+	select {
+	case <-sent:
+		logrus.Debugln("Reached the end of stdin")
+	case <-ctx.Done():
+	}
+}
+
+// sendLines sends every non-empty line read from stdin to the room.
+func sendLines(chatRoom *pkg.ChatRoom) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := chatRoom.SendMessage(line); err != nil {
+			logrus.Errorf("Failed to send message: %v", err)
+			continue
+		}
+		logrus.WithFields(logrus.Fields{"room": chatRoom.RoomName, "from": chatRoom.UserName()}).Info(line)
+	}
+	if err := scanner.Err(); err != nil {
+		logrus.Errorf("Failed to read stdin: %v", err)
+	}
+}
+
+// stdinIsPiped reports whether stdin is a pipe or a regular file, whose end means
+// there is nothing more to send. Terminals and character devices such as /dev/null,
+// as used by service managers, do not count.
+func stdinIsPiped() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	mode := info.Mode()
+	return mode&os.ModeNamedPipe != 0 || mode.IsRegular()
+}
+
+// waitForPeers waits until the room has a peer, giving up after the timeout.
+func waitForPeers(ctx context.Context, chatRoom *pkg.ChatRoom, timeout time.Duration) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for len(chatRoom.PeerList()) == 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline.C:
+			logrus.Warnf("No peers joined room '%s' within %s, messages may not be delivered", chatRoom.RoomName, timeout)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// logHostEvents writes the direct messages, received files and direct message receipts
+// of the host to the log until the context is cancelled. Without the chat UI nothing
+// else reads them, and the stream handlers delivering them would block.
+func logHostEvents(ctx context.Context, p2pHost *pkg.PeerNetwork) {
+	for {
+		select {
+		case msg := <-p2pHost.DirectInbound:
+			logrus.WithFields(logrus.Fields{"direct": true, "from": msg.SenderName, "peer": msg.SenderID, "id": msg.ID}).Info(msg.Message)
+		case file := <-p2pHost.FileInbound:
+			logrus.WithFields(logrus.Fields{"peer": file.From.Pretty(), "size": file.Size}).Infof("Received %s, saved to %s", file.Name, file.Path)
+		case receipt := <-p2pHost.DirectReceipts:
+			entry := logrus.WithFields(logrus.Fields{"peer": receipt.To.Pretty(), "id": receipt.ID})
+			if receipt.Delivered {
+				entry.Info("Queued direct message delivered")
+			} else {
+				entry.Warn("Queued direct message expired undelivered")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
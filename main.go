@@ -5,6 +5,9 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -13,58 +16,410 @@ import (
 
 func main() {
 	// Command-line flags
+	configPath := flag.String("config", "", "Config file setting any of these flags, which override it on the command line.")
 	userName := flag.String("user", "user", "Specify username.")
+	userColor := flag.String("color", "", "Color peers render your name in, as a color name or #rrggbb (left to them when empty).")
 	roomName := flag.String("room", "lobby", "Specify the room to join.")
-	discoveryMethod := flag.String("discover", "", "Set peer discovery method ('announce' or 'advertise').")
+	roomKey := flag.String("room-key", "", "Passphrase encrypting messages in the initial room end-to-end.")
+	discoveryMethod := flag.String("discover", "", "Set peer discovery method ('announce', 'advertise' or 'mdns').")
 	enableDebug := flag.Bool("debug", false, "Enable debug logs.")
+	metricsAddr := flag.String("metrics-addr", "", "Address serving Prometheus metrics at /metrics, e.g. 127.0.0.1:9090 (disabled when empty).")
+	apiAddr := flag.String("api-addr", "", "Loopback address serving the local HTTP API, e.g. 127.0.0.1:8080 (disabled when empty).")
+	apiToken := flag.String("api-token", "", "Bearer token the local HTTP API requires on every request (none when empty).")
+	headless := flag.Bool("headless", false, "Run without the chat UI, logging room messages and sending lines read from stdin.")
+	keepAlive := flag.Bool("keep-alive", false, "Keep running in headless mode after piped stdin ends.")
+	macrosFile := flag.String("macros", "", "File of text macros, one 'name = expansion' per line.")
+	themeName := flag.String("theme", pkg.DefaultTheme, "Color theme of the chat UI ('dark', 'light' or 'mono').")
+	idleThreshold := flag.Duration("idle-after", pkg.DefaultIdleThreshold, "Silence after which a peer is shown as idle in the peer list.")
+	noConfirmExit := flag.Bool("no-confirm-exit", false, "Exit on /exit without asking for confirmation.")
+	noNotify := flag.Bool("no-notify", false, "Disable desktop notifications for mentions.")
+	showVersion := flag.Bool("version", false, "Print version information and exit.")
+	logFormat := flag.String("log-format", "text", "Log output format ('text' or 'json').")
+	logFile := flag.String("log-file", "", "Write logs to this file instead of stdout.")
+	providerLimit := flag.Int("providers", pkg.DefaultProviderLimit, "Maximum number of providers to query when using 'announce' discovery (0 for unlimited).")
+	identityPath := flag.String("identity", "", "Path to a private key file used as a persistent identity (created if missing).")
+	keyType := flag.String("key-type", pkg.KeyTypeRSA, "Key type for newly generated identities ('rsa' or 'ed25519').")
+	var listenAddrs stringList
+	flag.Var(&listenAddrs, "listen", "Multiaddr to listen on; may be repeated (default "+pkg.DefaultListenAddr+" and "+pkg.DefaultListenAddr6+").")
+	ip6 := flag.Bool("ip6", true, "Listen on IPv6 as well as IPv4 when -listen is not given.")
+	var bootstrapPeers stringList
+	flag.Var(&bootstrapPeers, "bootstrap", "Bootstrap peer multiaddr replacing the public IPFS defaults; may be repeated.")
+	var blockedPeers, allowedPeers stringList
+	flag.Var(&blockedPeers, "block", "Peer ID whose connections are refused; may be repeated.")
+	flag.Var(&allowedPeers, "allow", "Peer ID to accept connections from, refusing all others; may be repeated.")
+	minBootstrap := flag.Int("min-bootstrap", pkg.DefaultMinBootstrapPeers, "Minimum number of bootstrap peers that must be reached at startup.")
+	namespace := flag.String("namespace", "", "Network namespace isolating discovery and rooms from other PeerNet nodes.")
+	peerstorePath := flag.String("peerstore", "", "File in which to remember peers chatted with, to reconnect to them at startup.")
+	outboxPath := flag.String("outbox", "", "File in which to keep direct messages queued for offline peers across restarts.")
+	ephemeral := flag.Bool("ephemeral", false, "Write nothing to disk: use a fresh identity and disable history, the peerstore, the outbox, log files and receiving files.")
+	localOnly := flag.Bool("local-only", false, "Listen on loopback only and skip the public bootstrap peers and NAT traversal, for running several instances on one machine.")
+	pskFile := flag.String("psk-file", "", "Path to a 32-byte pre-shared key enabling private network mode.")
+	security := flag.String("security", pkg.SecurityTLS, "Security transports to enable ('tls', 'noise' or 'both').")
+	muxers := flag.String("muxers", pkg.MuxerYamux, "Comma-separated stream muxers in order of preference ('yamux', 'mplex').")
+	selfTest := flag.Bool("self-test", false, "Run connectivity diagnostics and exit.")
+	check := flag.Bool("check", false, "Start the host with the given settings, report how well it connects and discovers peers, then exit.")
+	checkTimeout := flag.Duration("check-timeout", 30*time.Second, "Time -check waits for discovery to find PeerNet peers.")
+	historyDir := flag.String("history-dir", "", "Directory in which to persist room chat history (disabled when empty).")
+	historyLines := flag.Int("history-lines", pkg.DefaultHistoryLines, "Number of history lines to replay when joining a room.")
+	maxMessageSize := flag.Int("max-message-size", pkg.DefaultMaxMessageSize, "Maximum size of an outbound message in bytes (0 for unlimited).")
+	rateLimit := flag.Float64("rate-limit", pkg.DefaultRateLimit, "Maximum sustained messages per second accepted from each peer (0 for unlimited).")
+	rateBurst := flag.Int("rate-burst", pkg.DefaultRateBurst, "Number of messages a peer may send in a burst before being rate limited.")
+	dedupSize := flag.Int("dedup-size", pkg.DefaultDedupSize, "Number of recently received messages remembered to drop duplicates (0 disables).")
+	dedupExpiry := flag.Duration("dedup-expiry", pkg.DefaultDedupExpiry, "Time after which a received message is no longer treated as a duplicate.")
+	isolationTimeout := flag.Duration("isolation-timeout", pkg.DefaultIsolationTimeout, "Time a room may have no peers before it is reported as isolated (0 disables).")
+	noRediscover := flag.Bool("no-rediscover", false, "Do not rerun peer discovery while a room is isolated.")
+	queueSize := flag.Int("queue-size", pkg.DefaultQueueSize, "Number of messages and events buffered for each room before the -queue-policy applies.")
+	impersonation := flag.String("impersonation", pkg.ImpersonationWarnName, "What to do with messages sent under a name another peer already uses ('warn', 'drop' or 'off').")
+	queuePolicy := flag.String("queue-policy", pkg.OverflowBlockName, "What to do when a room's buffers are full ('block', 'drop-oldest' or 'error').")
+	batchWindow := flag.Duration("batch", 0, "Coalesce outbound messages over this window, e.g. 50ms (0 disables batching).")
+	sanitize := flag.Bool("sanitize", false, "Strip control characters and surrounding whitespace from messages, dropping ones left empty.")
+	dialAttempts := flag.Int("dial-attempts", pkg.DefaultDialAttempts, "Number of times to dial a discovered peer before giving up.")
+	dialBackoff := flag.Duration("dial-backoff", pkg.DefaultDialBackoff, "Delay before redialing a discovered peer, doubled after every failure.")
+	laxSignatures := flag.Bool("lax-signatures", false, "Accept unsigned PubSub messages instead of dropping them.")
+	contentIDs := flag.Bool("content-ids", false, "Identify PubSub messages by a hash of their content, so identical frames are dropped network-wide.")
+	peerScoring := flag.Bool("peer-scoring", false, "Score PubSub peers and stop routing messages for misbehaving ones.")
+	var staticRelays stringList
+	flag.Var(&staticRelays, "relay", "Relay multiaddr used when behind a NAT instead of relays discovered through the DHT; may be repeated.")
+	relayServer := flag.Bool("relay-server", false, "Act as a circuit relay for peers behind NATs.")
+	relayMaxCircuits := flag.Int("relay-max-circuits", 0, "Maximum number of circuits relayed at the same time (0 keeps the libp2p default).")
+	relayConnectTimeout := flag.Duration("relay-connect-timeout", 0, "Time allowed to reach the destination of a relayed circuit (0 keeps the libp2p default).")
+	connLow := flag.Int("conn-low", pkg.DefaultConnLow, "Number of connections the connection manager trims down to.")
+	connHigh := flag.Int("conn-high", pkg.DefaultConnHigh, "Number of connections above which the connection manager starts trimming.")
+	connGrace := flag.Duration("conn-grace", pkg.DefaultConnGrace, "Period during which new connections are never trimmed.")
+	downloadDir := flag.String("download-dir", pkg.DefaultDownloadDir(), "Directory in which to save files received from peers.")
+	readyPeers := flag.Int("ready-peers", pkg.DefaultReadyPeers, "Minimum peers in the DHT routing table before 'announce' and 'advertise' discovery start.")
+	readyTimeout := flag.Duration("ready-timeout", pkg.DefaultReadyTimeout, "Time allowed for the DHT routing table to reach -ready-peers.")
+	rebootstrapInterval := flag.Duration("rebootstrap-interval", pkg.DefaultRebootstrapInterval, "Interval between DHT routing table health checks (0 disables re-bootstrapping).")
+	rebootstrapPeers := flag.Int("rebootstrap-peers", pkg.DefaultRebootstrapPeers, "DHT routing table size below which the bootstrap peers are dialed again and the DHT is re-bootstrapped.")
+	advertiseTTL := flag.Duration("advertise-ttl", pkg.DefaultAdvertiseTTL, "TTL of 'advertise' discovery advertisements, renewed before they expire (at most 3h).")
+	minAdvertiseInterval := flag.Duration("min-advertise-interval", pkg.DefaultMinAdvertiseInterval, "Minimum time between two 'advertise' discovery advertisements, however short the TTL.")
+	discoveryInterval := flag.Duration("discovery-interval", pkg.DefaultDiscoveryInterval, "Interval between peer discovery rounds for 'announce' and 'advertise'.")
 
 	// Parse command-line flags
 	flag.Parse()
 
+	// Fill in the flags not given on the command line from the config file
+	if *configPath != "" {
+		if err := loadConfig(flag.CommandLine, *configPath); err != nil {
+			logrus.Fatalf("Failed to load config: %v", err)
+		}
+	}
+
+	// Ephemeral mode overrides every setting that writes to disk, including config file ones
+	var clearedFlags []string
+	if *ephemeral {
+		var err error
+		if clearedFlags, err = disablePersistence(flag.CommandLine); err != nil {
+			logrus.Fatalf("Failed to enable ephemeral mode: %v", err)
+		}
+	}
+
+	// Print build information without touching the network
+	if *showVersion {
+		printVersion()
+		return
+	}
+
 	// Setup logging
-	setupLogging(*enableDebug)
+	if err := setupLogging(*enableDebug, *logFormat, *logFile); err != nil {
+		logrus.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	if *ephemeral {
+		logrus.Warn("Ephemeral mode: nothing is written to disk. This session uses a fresh identity, keeps no history, peers or queued messages, and refuses files.")
+		if len(clearedFlags) > 0 {
+			logrus.Warnf("Ephemeral mode ignores %s", strings.Join(clearedFlags, ", "))
+		}
+	}
+
+	// Local instances find each other on the loopback interface through mDNS
+	if *localOnly && *discoveryMethod == "" {
+		*discoveryMethod = "mdns"
+	}
+
+	// mDNS finds peers without the DHT, so unreachable bootstrap peers only matter
+	// when a minimum was asked for explicitly
+	bootstrapOptional := *discoveryMethod == "mdns"
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "min-bootstrap" {
+			bootstrapOptional = false
+		}
+	})
+
+	// Cancel everything started from here on SIGINT/SIGTERM so every exit route
+	// converges on a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Only count activity when metrics are served
+	var metrics *pkg.Metrics
+	if *metricsAddr != "" {
+		metrics = pkg.NewMetrics()
+	}
+
+	// Validate the merged settings before touching the network
+	hostCfg := pkg.HostConfig{
+		IdentityPath:         *identityPath,
+		KeyType:              *keyType,
+		ListenAddrs:          listenAddrs,
+		DisableIPv6:          !*ip6,
+		LocalOnly:            *localOnly,
+		Ephemeral:            *ephemeral,
+		Security:             *security,
+		Muxers:               splitList(*muxers),
+		BootstrapPeers:       bootstrapPeers,
+		MinBootstrapPeers:    *minBootstrap,
+		BootstrapOptional:    bootstrapOptional,
+		Namespace:            *namespace,
+		PeerstorePath:        *peerstorePath,
+		OutboxPath:           *outboxPath,
+		PSKFile:              *pskFile,
+		BlockedPeers:         blockedPeers,
+		AllowedPeers:         allowedPeers,
+		DialAttempts:         *dialAttempts,
+		DialBackoff:          *dialBackoff,
+		DiscoveryInterval:    *discoveryInterval,
+		AdvertiseTTL:         *advertiseTTL,
+		MinAdvertiseInterval: *minAdvertiseInterval,
+		ReadyPeers:           *readyPeers,
+		ReadyTimeout:         *readyTimeout,
+		RebootstrapInterval:  *rebootstrapInterval,
+		RebootstrapPeers:     *rebootstrapPeers,
+		DownloadDir:          *downloadDir,
+		ConnLow:              *connLow,
+		ConnHigh:             *connHigh,
+		ConnGrace:            *connGrace,
+		LaxSignatures:        *laxSignatures,
+		PeerScoring:          *peerScoring,
+		StaticRelays:         staticRelays,
+		RelayServer:          *relayServer,
+		RelayMaxCircuits:     *relayMaxCircuits,
+		RelayConnectTimeout:  *relayConnectTimeout,
+		Metrics:              metrics,
+	}
+	if *contentIDs {
+		hostCfg.MessageIDFunc = pkg.ContentMessageID
+	}
+	if err := hostCfg.Validate(); err != nil {
+		logrus.Fatalf("Invalid configuration: %v", err)
+	}
+
+	// Run diagnostics with the validated settings instead of the chat session
+	if *selfTest {
+		os.Exit(runSelfTest(ctx, hostCfg))
+	}
+	theme, err := pkg.LookupTheme(*themeName)
+	if err != nil {
+		logrus.Fatalf("Invalid configuration: %v", err)
+	}
+	nameColor, err := pkg.ParseUserColor(*userColor)
+	if err != nil {
+		logrus.Fatalf("Invalid configuration: %v", err)
+	}
+	overflow, err := pkg.ParseOverflowPolicy(*queuePolicy)
+	if err != nil {
+		logrus.Fatalf("Invalid configuration: %v", err)
+	}
+	impersonationPolicy, err := pkg.ParseImpersonationPolicy(*impersonation)
+	if err != nil {
+		logrus.Fatalf("Invalid configuration: %v", err)
+	}
 
 	logrus.Info("Starting PeerNet... Please wait for up to 30 seconds.")
 
 	// Initialize P2P Host
-	p2pHost, err := initPeerNetworkHost()
+	p2pHost, err := initPeerNetworkHost(ctx, hostCfg)
 	if err != nil {
 		logrus.Fatalf("Failed to initialize P2P host: %v", err)
 	}
 	logrus.Info("P2P network setup complete.")
+	for _, addr := range p2pHost.Addrs() {
+		logrus.Infof("Listening on %s", addr)
+	}
+
+	// The chat UI shows direct messages and files, log them when it does not run
+	if *check || *headless {
+		go logHostEvents(ctx, p2pHost)
+	}
+
+	// Report connectivity instead of starting the chat session
+	if *check {
+		code := runCheck(ctx, p2pHost, *discoveryMethod, *providerLimit, *checkTimeout)
+		p2pHost.Close()
+		os.Exit(code)
+	}
+	if metrics != nil {
+		if err := p2pHost.ServeMetrics(*metricsAddr); err != nil {
+			logrus.Fatalf("Failed to serve metrics: %v", err)
+		}
+		logrus.Infof("Serving metrics on http://%s/metrics", *metricsAddr)
+	}
 
 	// Establish peer discovery and connection
-	err = connectToPeers(p2pHost, *discoveryMethod)
+	err = connectToPeers(p2pHost, *discoveryMethod, *providerLimit)
 	if err != nil {
 		logrus.Fatalf("Failed to connect to peers: %v", err)
 	}
 	logrus.Info("Successfully connected to peers.")
 
-	// Join the room
-	chatRoom, err := pkg.JoinChatRoom(p2pHost, *userName, *roomName)
+	// Join the room, logging its events when there is no UI to show them
+	roomOpts := []pkg.RoomOption{
+		pkg.WithBatchWindow(*batchWindow),
+		pkg.WithHistory(*historyDir, *historyLines),
+		pkg.WithMaxMessageSize(*maxMessageSize),
+		pkg.WithRoomKey(*roomKey),
+		pkg.WithRateLimit(*rateLimit, *rateBurst),
+		pkg.WithDedup(*dedupSize, *dedupExpiry),
+		pkg.WithIsolationWatchdog(*isolationTimeout, !*noRediscover),
+		pkg.WithUserColor(nameColor),
+		pkg.WithQueue(*queueSize, overflow),
+		pkg.WithImpersonationPolicy(impersonationPolicy),
+	}
+	if *sanitize {
+		roomOpts = append(roomOpts, pkg.WithMiddleware(pkg.SanitizeMiddleware{}))
+	}
+	if *headless {
+		roomOpts = append(roomOpts, pkg.WithHandler(pkg.LogHandler{Room: *roomName}))
+	}
+
+	// Listen for the local API, streaming the messages of every room joined
+	var api *pkg.APIServer
+	if *apiAddr != "" {
+		if api, err = pkg.NewAPIServer(*apiAddr); err != nil {
+			logrus.Fatalf("Failed to start API server: %v", err)
+		}
+		api.SetToken(*apiToken)
+		roomOpts = append(roomOpts, pkg.WithMiddleware(api.Middleware()))
+		logrus.Infof("Serving the API on http://%s", api.Addr())
+	}
+	chatRoom, err := pkg.JoinChatRoom(p2pHost, *userName, *roomName, roomOpts...)
 	if err != nil {
 		logrus.Fatalf("Failed to join chatroom: %v", err)
 	}
-	logrus.Infof("Joined chatroom '%s' as user '%s'", chatRoom.RoomName, chatRoom.UserName)
+	logrus.Infof("Joined chatroom '%s' as user '%s'", chatRoom.RoomName, chatRoom.UserName())
 
 	// Allow time for network setup
 	time.Sleep(2 * time.Second)
 
+	// Run without the UI until interrupted
+	if *headless {
+		if api != nil {
+			api.Serve(func() *pkg.ChatRoom { return chatRoom })
+		}
+		runHeadless(ctx, chatRoom, *keepAlive)
+		shutdown([]*pkg.ChatRoom{chatRoom}, p2pHost, api)
+		return
+	}
+
 	// Start UI
 	ui := pkg.NewUI(chatRoom)
-	if err := ui.Run(); err != nil {
-		logrus.Fatalf("Error running chat UI: %v", err)
+	if !*noNotify {
+		ui.SetNotifier(pkg.NewDesktopNotifier())
+	}
+	ui.SetConfirmExit(!*noConfirmExit)
+	ui.SetIdleThreshold(*idleThreshold)
+	ui.SetTheme(theme)
+	if *macrosFile != "" {
+		macros, err := pkg.LoadMacros(*macrosFile)
+		if err != nil {
+			logrus.Fatalf("Failed to load macros: %v", err)
+		}
+		ui.SetMacros(macros)
+	}
+	if api != nil {
+		api.Serve(ui.CurrentRoom)
+	}
+
+	// Stop the UI on SIGINT/SIGTERM so every exit route converges on the shutdown below
+	go func() {
+		<-ctx.Done()
+		ui.Close()
+	}()
+
+	// Show logs in the message box while the UI owns the terminal, unless they go to a file
+	restoreLogs := func() {}
+	if *logFile == "" {
+		restoreLogs = ui.CaptureLogs()
+	}
+
+	// Leave the rooms even when the UI fails, so no subscriptions are left behind
+	err = ui.Run()
+	restoreLogs()
+	if err != nil {
+		logrus.Errorf("Error running chat UI: %v", err)
 	}
+
+	// Leave the room and shut down the network
+	shutdown(ui.Rooms(), p2pHost, api)
 }
 
-// setupLogging configures the logging level and format.
-func setupLogging(enableDebug bool) {
-	logrus.SetFormatter(&logrus.TextFormatter{
-		ForceColors:     true,
-		FullTimestamp:   true,
-		TimestampFormat: time.RFC822,
-	})
-	logrus.SetOutput(os.Stdout)
+// shutdown stops the API server, if any, flushes and leaves the chat rooms, then
+// closes the PeerNetwork host.
+func shutdown(chatRooms []*pkg.ChatRoom, p2pHost *pkg.PeerNetwork, api *pkg.APIServer) {
+	logrus.Info("Shutting down PeerNet...")
+	if api != nil {
+		if err := api.Close(); err != nil {
+			logrus.Errorf("Error closing API server: %v", err)
+		}
+	}
+	for _, chatRoom := range chatRooms {
+		chatRoom.Exit()
+	}
+	if err := p2pHost.Close(); err != nil {
+		logrus.Errorf("Error closing P2P host: %v", err)
+	}
+}
+
+// stringList is a flag.Value that collects every occurrence of a repeatable flag.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping surrounding whitespace and
+// empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// setupLogging configures the logging level, format and destination.
+func setupLogging(enableDebug bool, format, logFile string) error {
+	switch format {
+	case "", "text":
+		logrus.SetFormatter(&logrus.TextFormatter{
+			ForceColors:     logFile == "",
+			DisableColors:   logFile != "",
+			FullTimestamp:   true,
+			TimestampFormat: time.RFC822,
+		})
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339})
+	default:
+		return fmt.Errorf("unsupported log format %q (expected 'text' or 'json')", format)
+	}
+
+	// Write to a file when requested so logs stay out of the terminal UI
+	if logFile != "" {
+		file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("error opening log file: %w", err)
+		}
+		logrus.SetOutput(file)
+	} else {
+		logrus.SetOutput(os.Stdout)
+	}
 
 	if enableDebug {
 		logrus.SetLevel(logrus.DebugLevel)
@@ -72,11 +427,12 @@ func setupLogging(enableDebug bool) {
 	} else {
 		logrus.SetLevel(logrus.InfoLevel)
 	}
+	return nil
 }
 
-// initP2PHost initializes the P2P network host.
-func initPeerNetworkHost() (*pkg.PeerNetwork, error) {
-	p2pHost, err := pkg.NewP2P(context.Background())
+// initPeerNetworkHost initializes the P2P network host, which is torn down when the context is cancelled.
+func initPeerNetworkHost(ctx context.Context, cfg pkg.HostConfig) (*pkg.PeerNetwork, error) {
+	p2pHost, err := pkg.NewP2P(ctx, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("error initializing PeerNetwork host: %w", err)
 	}
@@ -84,17 +440,40 @@ func initPeerNetworkHost() (*pkg.PeerNetwork, error) {
 }
 
 // connectToPeers handles peer discovery based on the specified method.
-func connectToPeers(p2pHost *pkg.PeerNetwork, discoveryMethod string) error {
+func connectToPeers(p2pHost *pkg.PeerNetwork, discoveryMethod string, providerLimit int) error {
 	switch discoveryMethod {
 	case "announce":
 		logrus.Debug("Using 'announce' for peer discovery.")
-		p2pHost.AnnounceConnect()
+		return p2pHost.AnnounceConnect(providerLimit)
+	case "mdns":
+		logrus.Debug("Using 'mdns' for peer discovery.")
+		return p2pHost.MdnsConnect()
 	case "advertise":
 		logrus.Debug("Using 'advertise' for peer discovery.")
-		p2pHost.AdvertiseConnect()
+		return p2pHost.AdvertiseConnect()
 	default:
 		logrus.Debug("No discovery method specified, defaulting to 'advertise'.")
-		p2pHost.AdvertiseConnect()
+		return p2pHost.AdvertiseConnect()
 	}
-	return nil
+}
+
+// runSelfTest runs the connectivity diagnostics, prints a line per check and
+// returns the process exit code.
+func runSelfTest(ctx context.Context, cfg pkg.HostConfig) int {
+	logrus.Info("Running PeerNet self-test... This may take a minute.")
+
+	passed := pkg.SelfTest(ctx, cfg, func(result pkg.CheckResult) {
+		switch {
+		case result.Passed():
+			fmt.Printf("[PASS] %s\n", result.Name)
+		case result.Critical:
+			fmt.Printf("[FAIL] %s: %v\n       hint: %s\n", result.Name, result.Err, result.Hint)
+		default:
+			fmt.Printf("[WARN] %s: %v\n       hint: %s\n", result.Name, result.Err, result.Hint)
+		}
+	})
+	if !passed {
+		return 1
+	}
+	return 0
 }
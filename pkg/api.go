@@ -0,0 +1,267 @@
+package pkg
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxAPIRequestSize bounds the size of an API request body.
+const maxAPIRequestSize = 64 << 10
+
+// apiStreamBuffer is the number of messages buffered for each event stream. Messages
+// are skipped for streams that fall further behind.
+const apiStreamBuffer = 64
+
+// APIServer serves a local HTTP API for sending messages to the current room, listing
+// its peers and streaming received messages as server-sent events.
+type APIServer struct {
+	listener net.Listener
+	server   *http.Server
+	room     func() *ChatRoom // Returns the room messages are sent to
+	token    string           // Bearer token required on every request, empty when none is
+
+	mu      sync.Mutex
+	streams map[chan ChatMessage]struct{} // Open event streams
+}
+
+// apiSendRequest is the body of a POST /messages request.
+type apiSendRequest struct {
+	Message string `json:"message"`
+}
+
+// apiPeer is an entry of the GET /peers response.
+type apiPeer struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// NewAPIServer listens on a loopback address for the API. Serve must be called to
+// start handling requests.
+func NewAPIServer(addr string) (*APIServer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API address %q: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("API address %q must be a loopback address", addr)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &APIServer{listener: listener, streams: make(map[chan ChatMessage]struct{})}, nil
+}
+
+// Addr returns the address the API is listening on.
+func (s *APIServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// SetToken requires every request to carry the token in an "Authorization: Bearer"
+// header. It must be called before Serve.
+func (s *APIServer) SetToken(token string) {
+	s.token = token
+}
+
+// Middleware returns the message middleware feeding received messages to the event
+// streams. It must be registered with WithMiddleware on the rooms to stream.
+func (s *APIServer) Middleware() MessageMiddleware {
+	return apiTap{s}
+}
+
+// Serve starts handling API requests in the background, sending messages to the room
+// returned by room.
+func (s *APIServer) Serve(room func() *ChatRoom) {
+	s.room = room
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/messages", s.handleMessages)
+	mux.HandleFunc("/peers", s.handlePeers)
+	mux.HandleFunc("/events", s.handleEvents)
+	s.server = &http.Server{Handler: s.authorize(mux), ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := s.server.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.Errorf("API server stopped: %v", err)
+		}
+	}()
+}
+
+// Close stops the API server, ending any open event streams.
+func (s *APIServer) Close() error {
+	if s.server == nil {
+		return s.listener.Close()
+	}
+	return s.server.Close()
+}
+
+// authorize rejects requests that were not made to a loopback host name, so web pages
+// cannot reach the API by rebinding their own domain to a loopback address, and requests
+// without the token when one is set.
+func (s *APIServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if ip := net.ParseIP(strings.Trim(host, "[]")); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			http.Error(w, "host must be a loopback address", http.StatusForbidden)
+			return
+		}
+		if s.token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "missing or invalid API token", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleMessages sends the message in a POST request body to the current room and
+// responds with the sent message.
+func (s *APIServer) handleMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Browsers send cross-origin form posts without a preflight, but never JSON ones
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		http.Error(w, "content type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var req apiSendRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIRequestSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Message) == "" {
+		http.Error(w, "message must not be empty", http.StatusBadRequest)
+		return
+	}
+
+	chatMsg, err := s.room().Post(req.Message)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, http.StatusCreated, chatMsg)
+}
+
+// handlePeers responds with the peers in the current room.
+func (s *APIServer) handlePeers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cr := s.room()
+	peers := []apiPeer{}
+	for _, id := range cr.PeerList() {
+		name, _ := cr.PeerName(id)
+		peers = append(peers, apiPeer{ID: id.Pretty(), Name: name})
+	}
+	writeJSON(w, http.StatusOK, peers)
+}
+
+// handleEvents streams received messages as server-sent events until the client
+// disconnects. Each event carries a message as JSON.
+func (s *APIServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	stream := make(chan ChatMessage, apiStreamBuffer)
+	s.mu.Lock()
+	s.streams[stream] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.streams, stream)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case msg := <-stream:
+			data, err := json.Marshal(msg)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: message\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// broadcast hands a received message to every open event stream, skipping streams
+// whose buffer is full so a slow client never holds up the room.
+func (s *APIServer) broadcast(msg ChatMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for stream := range s.streams {
+		select {
+		case stream <- msg:
+		default:
+		}
+	}
+}
+
+// writeJSON writes a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Debugf("Failed to write API response: %v", err)
+	}
+}
+
+// apiTap is the MessageMiddleware copying received messages to the API event streams.
+type apiTap struct {
+	s *APIServer
+}
+
+// ProcessInbound streams a received message and passes it on unchanged.
+func (t apiTap) ProcessInbound(msg ChatMessage) (ChatMessage, bool) {
+	t.s.broadcast(msg)
+	return msg, true
+}
+
+// ProcessOutbound passes a sent message on unchanged.
+func (t apiTap) ProcessOutbound(msg ChatMessage) (ChatMessage, bool) {
+	return msg, true
+}
@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// newTestAPIServer serves the API on a loopback port, without a room to send to.
+func newTestAPIServer(t *testing.T, token string) string {
+	t.Helper()
+	s, err := NewAPIServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewAPIServer: %v", err)
+	}
+	s.SetToken(token)
+	s.Serve(func() *ChatRoom { return nil })
+	t.Cleanup(func() { s.Close() })
+	return "http://" + s.Addr().String()
+}
+
+// apiStatus makes a request to the API and returns the response status code.
+func apiStatus(t *testing.T, method, url, host, contentType, body string, header http.Header) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "" {
+		req.Host = host
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAPIRejectsCrossOriginRequests(t *testing.T) {
+	base := newTestAPIServer(t, "")
+
+	if got := apiStatus(t, http.MethodPost, base+"/messages", "", "text/plain", `{"message":"hi"}`, nil); got != http.StatusUnsupportedMediaType {
+		t.Errorf("text/plain message = %d, want %d", got, http.StatusUnsupportedMediaType)
+	}
+	if got := apiStatus(t, http.MethodPost, base+"/messages", "", "application/json", `{"message":""}`, nil); got != http.StatusBadRequest {
+		t.Errorf("JSON message = %d, want it to reach the handler and fail with %d", got, http.StatusBadRequest)
+	}
+	for _, path := range []string{"/messages", "/peers", "/events"} {
+		if got := apiStatus(t, http.MethodGet, base+path, "attacker.example:8080", "", "", nil); got != http.StatusForbidden {
+			t.Errorf("GET %s for a rebound host = %d, want %d", path, got, http.StatusForbidden)
+		}
+	}
+}
+
+func TestAPIToken(t *testing.T) {
+	base := newTestAPIServer(t, "secret")
+
+	for _, auth := range []string{"", "Bearer wrong", "secret"} {
+		header := http.Header{"Authorization": {auth}}
+		if got := apiStatus(t, http.MethodPost, base+"/messages", "", "application/json", `{"message":""}`, header); got != http.StatusUnauthorized {
+			t.Errorf("Authorization %q = %d, want %d", auth, got, http.StatusUnauthorized)
+		}
+	}
+	header := http.Header{"Authorization": {"Bearer secret"}}
+	if got := apiStatus(t, http.MethodPost, base+"/messages", "localhost", "application/json", `{"message":""}`, header); got != http.StatusBadRequest {
+		t.Errorf("valid token = %d, want it to reach the handler and fail with %d", got, http.StatusBadRequest)
+	}
+}
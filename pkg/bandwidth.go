@@ -0,0 +1,54 @@
+package pkg
+
+import (
+	"fmt"
+	"time"
+
+	coremetrics "github.com/libp2p/go-libp2p-core/metrics"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// bandwidthLogInterval is how often the current transfer rates are logged in debug mode.
+const bandwidthLogInterval = time.Minute
+
+// BandwidthStats reports the traffic of the host since it started, in total and for
+// each protocol. Rates are in bytes per second, averaged over the last few seconds.
+type BandwidthStats struct {
+	Total      coremetrics.Stats
+	ByProtocol map[protocol.ID]coremetrics.Stats
+}
+
+// Bandwidth returns the traffic of the host since it started.
+func (p *PeerNetwork) Bandwidth() BandwidthStats {
+	return BandwidthStats{
+		Total:      p.bandwidth.GetBandwidthTotals(),
+		ByProtocol: p.bandwidth.GetBandwidthByProtocol(),
+	}
+}
+
+// logBandwidth logs the current transfer rates while debug logging is enabled, until
+// the PeerNetwork context is cancelled.
+func (p *PeerNetwork) logBandwidth() {
+	ticker := time.NewTicker(bandwidthLogInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.Ctx.Done():
+			return
+		case <-ticker.C:
+			if !logrus.IsLevelEnabled(logrus.DebugLevel) {
+				continue
+			}
+			total := p.bandwidth.GetBandwidthTotals()
+			logrus.Debugf("Bandwidth: %s in, %s out (%s in, %s out in total)",
+				formatRate(total.RateIn), formatRate(total.RateOut), formatBytes(total.TotalIn), formatBytes(total.TotalOut))
+		}
+	}
+}
+
+// formatRate formats a transfer rate in bytes per second, e.g. "1.5 KiB/s".
+func formatRate(bytesPerSecond float64) string {
+	return fmt.Sprintf("%s/s", formatBytes(int64(bytesPerSecond)))
+}
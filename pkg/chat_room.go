@@ -1,54 +1,181 @@
 package pkg
 
 import (
+	"bytes"
 	"context"
+	"crypto/cipher"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/peer"
-	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/sirupsen/logrus"
 )
 
-// ChatRoom represents a PubSub-based chat room.
+// ChatRoom represents a chat room, carried over PubSub unless another RoomTransport is given.
 type ChatRoom struct {
 	Host     *PeerNetwork     // PeerNetwork host instance
-	Inbound  chan chatMessage // Incoming messages channel
+	Inbound  chan ChatMessage // Incoming messages channel, closed once the room stops receiving
 	Outbound chan string      // Outgoing messages channel
-	Logs     chan chatLog     // Chat log messages channel
+	Logs     chan ChatLog     // Chat log messages channel
 
-	RoomName string  // Name of the chat room
-	UserName string  // Name of the user in the chat room
-	selfID   peer.ID // Host ID of the peer
+	RoomName  string         // Name of the chat room
+	UserColor string         // Color peers render the user's name in; empty leaves it to them
+	selfID    peer.ID        // Host ID of the peer
+	selfKey   crypto.PrivKey // Host private key used to sign messages
 
-	psCtx    context.Context      // PubSub context for managing lifecycle
-	psCancel context.CancelFunc   // PubSub cancellation function
-	psTopic  *pubsub.Topic        // PubSub topic for the chat room
-	psSub    *pubsub.Subscription // PubSub subscription for the topic
+	userName string         // Name of the user in the chat room, see UserName
+	mention  *regexp.Regexp // Matches mentions of userName, nil when it is empty
+	userMu   sync.RWMutex   // Guards userName, mention and UserColor, which UpdateProfile changes while the room runs
+
+	psCtx         context.Context    // Context for managing the room lifecycle
+	psCancel      context.CancelFunc // Cancels psCtx
+	openTransport TransportFunc      // Opens the room transport, see WithTransport
+	transport     RoomTransport      // Transport carrying the room, a PubSub topic by default
+	sub           RoomSubscription   // Subscription to the transport, replaced after a subscription error
+	subMu         sync.Mutex         // Guards sub and leaving
+	leaving       bool               // Set by Exit before the subscription is cancelled
+
+	queue       chan ChatMessage // Prepared outbound messages waiting for publishLoop
+	queueSize   int              // Buffer size of queue and the Inbound, Outbound and Logs channels
+	overflow    OverflowPolicy   // What happens to queued messages and events when a buffer is full
+	stopPublish chan struct{}    // Closed to ask publishLoop to flush and stop
+	publishDone chan struct{}    // Closed once publishLoop has returned
+	exitOnce    sync.Once        // Guards leaving the room
+	inboundOnce sync.Once        // Guards closing Inbound
+
+	peerNames  map[peer.ID]string              // Most recent username seen for each peer
+	lastActive map[peer.ID]time.Time           // Time each peer last sent a message or reaction
+	peerCaps   map[peer.ID]map[string]struct{} // Capabilities each peer announced
+	typing     map[peer.ID]time.Time           // Time each peer last sent a typing notification
+	namesMu    sync.RWMutex                    // Guards peerNames, lastActive, peerCaps and typing
+
+	typingSent time.Time  // Time the last typing notification was sent
+	typingMu   sync.Mutex // Guards typingSent
+
+	historyDir   string        // Directory holding room history files, empty when disabled
+	historyLimit int           // Maximum number of history lines replayed on join
+	history      *chatHistory  // Room history log, nil when disabled
+	backlog      []ChatMessage // Messages replayed from history on join
+
+	batchWindow      time.Duration       // Window over which outbound messages are coalesced
+	maxMessageSize   int                 // Maximum outbound message size in bytes, zero for unlimited
+	middleware       []MessageMiddleware // Ordered inbound/outbound message middleware
+	handler          Handler             // Receives room events, delivering to the channels by default
+	roomPassphrase   string              // Passphrase the room key is derived from, empty when unencrypted
+	roomKey          []byte              // Room key, given or derived from roomPassphrase; nil when unencrypted
+	roomCipher       cipher.AEAD         // Room key cipher, nil when the room is unencrypted
+	rateLimit        *rateLimiter        // Per-peer inbound rate limiter, nil when unlimited
+	dedup            *dedupCache         // Recently received messages, nil when duplicates are kept
+	names            *nameOwners         // Peer each name in the room belongs to, see checkImpersonation
+	impersonation    ImpersonationPolicy // What happens to messages sent under another peer's name
+	isolationTimeout time.Duration       // Time without peers before the room is reported isolated, zero disables
+	rediscover       bool                // Whether discovery is rerun while the room is isolated
+	opts             []RoomOption        // Options the room was joined with
 }
 
-// chatMessage represents a single chat message.
-type chatMessage struct {
-	Message    string `json:"message"`
-	SenderID   string `json:"senderid"`
-	SenderName string `json:"sendername"`
+// DefaultMaxMessageSize is the default maximum size of an outbound message in bytes.
+const DefaultMaxMessageSize = 8 << 10
+
+// maxInboundFrameSize is the hard ceiling on the size of a received PubSub frame.
+const maxInboundFrameSize = 256 << 10
+
+// Delays before resubscribing to a room topic after a subscription error. The delay
+// doubles after every failed attempt, up to maxResubscribeBackoff.
+const (
+	resubscribeBackoff    = time.Second
+	maxResubscribeBackoff = 30 * time.Second
+)
+
+// exitFlushTimeout bounds how long Exit waits for queued messages to be published.
+const exitFlushTimeout = 2 * time.Second
+
+// MaxRoomNameLength is the maximum length of a room name in characters.
+const MaxRoomNameLength = 64
+
+// ErrMessageDropped is returned by SendMessage when middleware drops the message.
+var ErrMessageDropped = errors.New("message dropped by middleware")
+
+// RoomOption configures optional ChatRoom behaviour.
+type RoomOption func(*ChatRoom)
+
+// WithMaxMessageSize limits outbound messages to the given number of bytes.
+// A zero size removes the limit.
+func WithMaxMessageSize(size int) RoomOption {
+	return func(cr *ChatRoom) {
+		cr.maxMessageSize = size
+	}
+}
+
+// WithBatchWindow coalesces outbound messages published within the given window
+// into a single PubSub frame. A zero window disables batching.
+func WithBatchWindow(window time.Duration) RoomOption {
+	return func(cr *ChatRoom) {
+		cr.batchWindow = window
+	}
+}
+
+// ChatMessage represents a single chat message.
+type ChatMessage struct {
+	Message    string    `json:"message"`
+	SenderID   string    `json:"senderid"`
+	SenderName string    `json:"sendername"`
+	Timestamp  time.Time `json:"timestamp"`
+	Type       string    `json:"type,omitempty"`
+	ID         string    `json:"id,omitempty"`     // Unique message ID assigned by the sender
+	Target     string    `json:"target,omitempty"` // ID of the message a reaction refers to
+	Version    int       `json:"v,omitempty"`      // Message format version, see MessageVersion
+	Caps       []string  `json:"caps,omitempty"`   // Capabilities announced in a presence message
+	Color      string    `json:"color,omitempty"`  // Color the sender's name is rendered in, see ParseUserColor
+
+	Impersonates peer.ID `json:"-"` // Peer whose name a received message was sent under, see WithImpersonationPolicy
 }
 
-// chatLog represents a log message for the chat room.
-type chatLog struct {
+// Message types carried in ChatMessage.Type.
+const (
+	MessageTypeChat     = ""
+	MessageTypePresence = "presence"
+	MessageTypeReaction = "reaction"
+	MessageTypeAction   = "action" // A /me action, shown by older clients as a plain message
+	MessageTypeTyping   = "typing" // An ephemeral notification that the sender is composing
+)
+
+// ChatLog represents a log message for the chat room.
+type ChatLog struct {
 	Prefix string
 	Msg    string
 }
 
-// JoinChatRoom creates and returns a new ChatRoom instance.
-func JoinChatRoom(p2pHost *PeerNetwork, username, roomName string) (*ChatRoom, error) {
-	// Join the PubSub topic for the room
-	topic, err := p2pHost.PubSub.Join(fmt.Sprintf("room-peerchat-%s", roomName))
-	if err != nil {
-		return nil, err
+// NormalizeRoomName trims surrounding whitespace from a room name and checks that the
+// result is a valid room name: non-empty, at most MaxRoomNameLength characters long and
+// made of letters, digits, hyphens, underscores and dots.
+func NormalizeRoomName(roomName string) (string, error) {
+	roomName = strings.TrimSpace(roomName)
+	switch {
+	case roomName == "":
+		return "", errors.New("room name must not be empty")
+	case utf8.RuneCountInString(roomName) > MaxRoomNameLength:
+		return "", fmt.Errorf("room name must be at most %d characters long", MaxRoomNameLength)
+	}
+	for _, r := range roomName {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_.", r) {
+			return "", fmt.Errorf("room name %q contains %q, use letters, digits, '-', '_' and '.'", roomName, r)
+		}
 	}
+	return roomName, nil
+}
 
-	// Subscribe to the PubSub topic
-	sub, err := topic.Subscribe()
+// JoinChatRoom creates and returns a new ChatRoom instance. The room name is
+// normalized with NormalizeRoomName, returning an error if it is invalid.
+func JoinChatRoom(p2pHost *PeerNetwork, username, roomName string, opts ...RoomOption) (*ChatRoom, error) {
+	roomName, err := NormalizeRoomName(roomName)
 	if err != nil {
 		return nil, err
 	}
@@ -59,67 +186,292 @@ func JoinChatRoom(p2pHost *PeerNetwork, username, roomName string) (*ChatRoom, e
 	// Initialize a ChatRoom instance
 	chatRoom := &ChatRoom{
 		Host:     p2pHost,
-		Inbound:  make(chan chatMessage, 1),
-		Outbound: make(chan string, 1),
-		Logs:     make(chan chatLog, 1),
 		RoomName: roomName,
-		UserName: username,
+		userName: username,
+		mention:  mentionPattern(username),
 		selfID:   p2pHost.Host.ID(),
+		selfKey:  p2pHost.Host.Peerstore().PrivKey(p2pHost.Host.ID()),
 		psCtx:    psCtx,
 		psCancel: cancel,
-		psTopic:  topic,
-		psSub:    sub,
+		opts:     opts,
+
+		maxMessageSize:   DefaultMaxMessageSize,
+		rateLimit:        newRateLimiter(DefaultRateLimit, DefaultRateBurst),
+		dedup:            newDedupCache(DefaultDedupSize, DefaultDedupExpiry),
+		isolationTimeout: DefaultIsolationTimeout,
+		rediscover:       true,
+		queueSize:        DefaultQueueSize,
+		stopPublish:      make(chan struct{}),
+		publishDone:      make(chan struct{}),
+		peerNames:        make(map[peer.ID]string),
+		names:            newNameOwners(),
+		lastActive:       make(map[peer.ID]time.Time),
+		peerCaps:         make(map[peer.ID]map[string]struct{}),
+		typing:           make(map[peer.ID]time.Time),
+		openTransport:    p2pHost.pubsubTransport,
+	}
+	chatRoom.handler = channelHandler{chatRoom}
+	for _, opt := range opts {
+		opt(chatRoom)
+	}
+
+	// Open the room transport and subscribe to it
+	if chatRoom.transport, err = chatRoom.openTransport(roomName); err != nil {
+		cancel()
+		return nil, err
+	}
+	if chatRoom.sub, err = chatRoom.transport.Subscribe(); err != nil {
+		cancel()
+		chatRoom.transport.Close()
+		return nil, err
+	}
+	chatRoom.Inbound = make(chan ChatMessage, chatRoom.queueSize)
+	chatRoom.Outbound = make(chan string, chatRoom.queueSize)
+	chatRoom.Logs = make(chan ChatLog, chatRoom.queueSize)
+	chatRoom.queue = make(chan ChatMessage, chatRoom.queueSize)
+
+	// Derive the room key, if the room is encrypted
+	if chatRoom.roomPassphrase != "" {
+		chatRoom.roomKey = deriveRoomKey(chatRoom.roomPassphrase, roomName)
+	}
+	if chatRoom.roomKey != nil {
+		if chatRoom.roomCipher, err = newRoomCipher(chatRoom.roomKey); err != nil {
+			cancel()
+			chatRoom.sub.Cancel()
+			chatRoom.transport.Close()
+			return nil, fmt.Errorf("error deriving room key: %w", err)
+		}
+	}
+
+	// Load and open the room history, if enabled
+	if chatRoom.historyDir != "" {
+		path := historyPath(chatRoom.historyDir, roomName)
+		if chatRoom.backlog, err = loadHistory(path, chatRoom.historyLimit); err == nil {
+			chatRoom.history, err = openHistory(path)
+		}
+		if err != nil {
+			cancel()
+			chatRoom.sub.Cancel()
+			chatRoom.transport.Close()
+			return nil, fmt.Errorf("error opening chat history: %w", err)
+		}
 	}
 
 	// Start loops for subscription and publishing
+	p2pHost.addRoom(chatRoom)
 	go chatRoom.subscribeLoop()
 	go chatRoom.publishLoop()
+	go chatRoom.presenceLoop()
+	go chatRoom.peerWatchLoop()
+	go chatRoom.isolationLoop()
 
 	return chatRoom, nil
 }
 
-// publishLoop handles publishing outbound chat messages to the PubSub topic.
+// publishLoop handles publishing outbound chat messages to the room transport.
+// When batching is enabled, messages are held until the batch window elapses
+// and then published together in their original order.
 func (cr *ChatRoom) publishLoop() {
+	var pending []ChatMessage
+	var flush <-chan time.Time
+
+	defer close(cr.publishDone)
+
 	for {
 		select {
 		case <-cr.psCtx.Done():
 			return
+		case <-cr.stopPublish:
+			cr.drainOutbound(pending)
+			return
 		case message := <-cr.Outbound:
-			// Create a chatMessage instance
-			chatMsg := chatMessage{
-				Message:    message,
-				SenderID:   cr.selfID.Pretty(),
-				SenderName: cr.UserName,
-			}
-
-			// Serialize the message to JSON
-			msgBytes, err := json.Marshal(chatMsg)
+			// Create a ChatMessage instance and run the outbound checks
+			chatMsg, err := cr.prepareOutbound(message)
 			if err != nil {
-				cr.Logs <- chatLog{Prefix: "puberr", Msg: "failed to marshal JSON"}
+				if err != ErrMessageDropped {
+					cr.handler.OnLog(ChatLog{Prefix: "puberr", Msg: err.Error()})
+				}
 				continue
 			}
+			pending, flush = cr.schedule(chatMsg, pending, flush)
+		case chatMsg := <-cr.queue:
+			pending, flush = cr.schedule(chatMsg, pending, flush)
+		case <-flush:
+			cr.publish(pending...)
+			pending, flush = nil, nil
+		}
+	}
+}
 
-			// Publish the message to the PubSub topic
-			if err := cr.psTopic.Publish(cr.psCtx, msgBytes); err != nil {
-				cr.Logs <- chatLog{Prefix: "puberr", Msg: "failed to publish message"}
+// schedule publishes a prepared message immediately, or adds it to the pending batch
+// when batching is enabled, starting the batch window on the first pending message.
+func (cr *ChatRoom) schedule(chatMsg ChatMessage, pending []ChatMessage, flush <-chan time.Time) ([]ChatMessage, <-chan time.Time) {
+	if cr.batchWindow <= 0 {
+		cr.publish(chatMsg)
+		return pending, flush
+	}
+
+	pending = append(pending, chatMsg)
+	if flush == nil {
+		flush = time.After(cr.batchWindow)
+	}
+	return pending, flush
+}
+
+// drainOutbound publishes the pending batch along with any messages still queued on Outbound.
+func (cr *ChatRoom) drainOutbound(pending []ChatMessage) {
+	for {
+		select {
+		case message := <-cr.Outbound:
+			if chatMsg, err := cr.prepareOutbound(message); err == nil {
+				pending = append(pending, chatMsg)
+			}
+		case chatMsg := <-cr.queue:
+			pending = append(pending, chatMsg)
+		default:
+			if len(pending) == 0 {
+				return
 			}
+			if err := cr.publishFrame(pending...); err != nil {
+				logrus.Debugf("Failed to flush outbound messages: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// publish publishes the given messages as a single frame, reporting failures to the room handler.
+func (cr *ChatRoom) publish(msgs ...ChatMessage) {
+	if err := cr.publishFrame(msgs...); err != nil {
+		cr.handler.OnLog(ChatLog{Prefix: "puberr", Msg: err.Error()})
+	}
+}
+
+// publishFrame serializes the given messages into a single frame and publishes it to the room transport.
+func (cr *ChatRoom) publishFrame(msgs ...ChatMessage) error {
+	// Serialize the messages to JSON
+	frameBytes, err := encodeFrame(msgs)
+	if err != nil {
+		return errors.New("failed to marshal JSON")
+	}
+
+	// Encrypt the frame with the room key so only room members can read it
+	if cr.roomCipher != nil {
+		if frameBytes, err = encryptFrame(cr.roomCipher, frameBytes); err != nil {
+			return fmt.Errorf("failed to encrypt message: %w", err)
 		}
 	}
+
+	// Sign the frame with the host identity
+	msgBytes, err := signFrame(cr.selfKey, frameBytes)
+	if err != nil {
+		return fmt.Errorf("failed to sign message: %w", err)
+	}
+
+	// Publish the frame to the room transport
+	if err := cr.transport.Publish(cr.psCtx, msgBytes); err != nil {
+		cr.Host.metrics.publishFailed(cr.RoomName)
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+	cr.Host.metrics.messagesPublished(cr.RoomName, msgs)
+	cr.Host.messagesSent.Add(chatMessageCount(msgs))
+
+	cr.recordHistory(msgs...)
+	return nil
+}
+
+// SendMessage synchronously publishes a message to the room and returns any delivery error.
+// Unlike sending on Outbound, it does not require the UI to be running and bypasses batching.
+func (cr *ChatRoom) SendMessage(message string) error {
+	chatMsg, err := cr.prepareOutbound(message)
+	if err != nil {
+		return err
+	}
+	return cr.publishFrame(chatMsg)
+}
+
+// Post queues a message for publishing like sending on Outbound, but runs the outbound
+// checks immediately and returns the prepared message, including its ID, for local echo.
+func (cr *ChatRoom) Post(message string) (ChatMessage, error) {
+	chatMsg, err := cr.prepareOutbound(message)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	return chatMsg, cr.enqueue(chatMsg)
+}
+
+// PostAction queues a /me action, e.g. "waves", and returns it for local echo.
+func (cr *ChatRoom) PostAction(action string) (ChatMessage, error) {
+	chatMsg, err := cr.prepareOutbound(action)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	chatMsg.Type = MessageTypeAction
+	return chatMsg, cr.enqueue(chatMsg)
+}
+
+// enqueue hands a prepared message to publishLoop, applying the overflow policy when
+// the queue is full.
+func (cr *ChatRoom) enqueue(chatMsg ChatMessage) error {
+	dropped, err := offer(cr.queue, chatMsg, cr.overflow, cr.psCtx.Done())
+	if dropped {
+		cr.handler.OnLog(ChatLog{Prefix: "puberr", Msg: "outbound queue is full, dropped the oldest unsent message"})
+	}
+	return err
+}
+
+// prepareOutbound enforces the maximum message size, creates a ChatMessage and
+// runs it through the outbound middleware chain.
+func (cr *ChatRoom) prepareOutbound(message string) (ChatMessage, error) {
+	if cr.maxMessageSize > 0 && len(message) > cr.maxMessageSize {
+		return ChatMessage{}, fmt.Errorf("message of %d bytes exceeds the %d byte limit", len(message), cr.maxMessageSize)
+	}
+
+	chatMsg, ok := cr.processOutbound(cr.newChatMessage(message))
+	if !ok {
+		return ChatMessage{}, ErrMessageDropped
+	}
+	return chatMsg, nil
+}
+
+// newChatMessage creates a ChatMessage sent by the local user.
+func (cr *ChatRoom) newChatMessage(message string) ChatMessage {
+	cr.userMu.RLock()
+	defer cr.userMu.RUnlock()
+	return ChatMessage{
+		Message:    message,
+		SenderID:   cr.selfID.Pretty(),
+		SenderName: cr.userName,
+		Color:      cr.UserColor,
+		Timestamp:  time.Now(),
+		ID:         newMessageID(),
+		Version:    MessageVersion,
+	}
 }
 
-// subscribeLoop handles reading inbound messages from the PubSub subscription.
+// subscribeLoop handles reading inbound messages from the room subscription.
 func (cr *ChatRoom) subscribeLoop() {
 	for {
 		select {
 		case <-cr.psCtx.Done():
-			close(cr.Inbound)
+			cr.closeInbound()
 			return
 		default:
-			// Read the next message from the PubSub subscription
-			msg, err := cr.psSub.Next(cr.psCtx)
+			// Read the next frame from the room subscription
+			cr.subMu.Lock()
+			sub := cr.sub
+			cr.subMu.Unlock()
+			msg, err := sub.Next(cr.psCtx)
 			if err != nil {
-				cr.Logs <- chatLog{Prefix: "suberr", Msg: "subscription closed"}
-				close(cr.Inbound)
+				// Errors other than leaving the room are transient, subscribe again
+				if cr.psCtx.Err() == nil && !cr.isLeaving() {
+					cr.handler.OnLog(ChatLog{Prefix: "suberr", Msg: fmt.Sprintf("subscription failed: %s, resubscribing", err)})
+					if cr.resubscribe() {
+						cr.handler.OnLog(ChatLog{Prefix: "info", Msg: "resubscribed to the room"})
+						continue
+					}
+				}
+				cr.closeInbound()
 				return
 			}
 
@@ -128,32 +480,245 @@ func (cr *ChatRoom) subscribeLoop() {
 				continue
 			}
 
-			// Deserialize the message data into chatMessage
-			var chatMsg chatMessage
-			if err := json.Unmarshal(msg.Data, &chatMsg); err != nil {
-				cr.Logs <- chatLog{Prefix: "suberr", Msg: "failed to unmarshal JSON"}
+			// Drop oversized frames before doing any work on them
+			if len(msg.Data) > maxInboundFrameSize {
+				cr.handler.OnLog(ChatLog{Prefix: "suberr", Msg: fmt.Sprintf("dropped oversized message of %d bytes", len(msg.Data))})
 				continue
 			}
 
-			// Send the message to the inbound channel
-			cr.Inbound <- chatMsg
+			author := msg.Author
+			if err := author.Validate(); err != nil {
+				cr.handler.OnLog(ChatLog{Prefix: "suberr", Msg: "message has no valid author"})
+				continue
+			}
+
+			// Drop frames from peers exceeding their rate limit before verifying them, so
+			// a flood costs no signature checks, reporting each flood once
+			if allowed, first := cr.rateLimit.Allow(author); !allowed {
+				if first {
+					cr.handler.OnLog(ChatLog{Prefix: "suberr", Msg: fmt.Sprintf("dropping messages from %s, rate limit exceeded", shortID(author))})
+				}
+				continue
+			}
+
+			// Verify the frame was signed by its author
+			frameBytes, err := verifyFrame(msg.Data, author)
+			if err != nil {
+				cr.handler.OnLog(ChatLog{Prefix: "suberr", Msg: fmt.Sprintf("dropped message from %s: %s", shortID(author), err)})
+				continue
+			}
+
+			// Decrypt the frame with the room key, refusing plaintext in encrypted rooms
+			if cr.roomCipher != nil {
+				if frameBytes, err = decryptFrame(cr.roomCipher, frameBytes); err != nil {
+					cr.handler.OnLog(ChatLog{Prefix: "suberr", Msg: fmt.Sprintf("dropped message from %s: %s", shortID(author), err)})
+					continue
+				}
+			} else if isEncryptedFrame(frameBytes) {
+				cr.handler.OnLog(ChatLog{Prefix: "suberr", Msg: fmt.Sprintf("dropped encrypted message from %s, join with the room key to read it", shortID(author))})
+				continue
+			}
+
+			// Deserialize the frame into one or more ChatMessages
+			chatMsgs, err := decodeFrame(frameBytes)
+			if err != nil {
+				cr.handler.OnLog(ChatLog{Prefix: "suberr", Msg: "failed to unmarshal JSON"})
+				continue
+			}
+
+			// Send the messages to the inbound channel in order
+			for _, chatMsg := range chatMsgs {
+				// Bind the message to its verified author and remember their name, checking
+				// first whether another peer already uses it
+				chatMsg.SenderID = author.Pretty()
+				owner, impersonating := cr.checkImpersonation(author, chatMsg.SenderName)
+				cr.setPeerName(author, chatMsg.SenderName)
+
+				// Skip messages in formats this client cannot handle
+				if err := checkMessageFormat(chatMsg); err != nil {
+					logrus.Debugf("Ignored message from %s: %v", shortID(author), err)
+					continue
+				}
+				if chatMsg.Type == MessageTypePresence {
+					cr.setPeerCapabilities(author, chatMsg.Caps)
+					continue
+				}
+				if chatMsg.Type == MessageTypeTyping {
+					cr.setTyping(author)
+					continue
+				}
+				cr.setActive(author)
+				cr.Host.knownPeers.Seen(author)
+
+				// Drop copies of a message that arrived over more than one path
+				if cr.dedup.Seen(author, chatMsg) {
+					continue
+				}
+				cr.clearTyping(author)
+
+				if impersonating {
+					if cr.impersonation == ImpersonationDrop {
+						cr.handler.OnLog(ChatLog{Prefix: "warn", Msg: fmt.Sprintf("dropped message from %s using the name '%s' of %s", shortID(author), chatMsg.SenderName, shortID(owner))})
+						continue
+					}
+					chatMsg.Impersonates = owner
+				}
+
+				// Legacy clients do not send a timestamp, fall back to the receive time
+				if chatMsg.Timestamp.IsZero() {
+					chatMsg.Timestamp = time.Now()
+				}
+				if chatMsg, ok := cr.processInbound(chatMsg); ok {
+					cr.recordHistory(chatMsg)
+					cr.Host.metrics.messageReceived(cr.RoomName)
+					cr.Host.messagesReceived.Add(1)
+					cr.handler.OnMessage(chatMsg)
+				}
+			}
+		}
+	}
+}
+
+// closeInbound closes the Inbound channel. Only subscribeLoop sends to Inbound, so it
+// calls this once it has delivered its last message.
+func (cr *ChatRoom) closeInbound() {
+	cr.inboundOnce.Do(func() { close(cr.Inbound) })
+}
+
+// isLeaving reports whether Exit has started leaving the room.
+func (cr *ChatRoom) isLeaving() bool {
+	cr.subMu.Lock()
+	defer cr.subMu.Unlock()
+	return cr.leaving
+}
+
+// resubscribe replaces a failed subscription to the room topic, retrying with
+// exponential backoff. It gives up, returning false, once the room is left or the
+// host shuts down.
+func (cr *ChatRoom) resubscribe() bool {
+	delay := resubscribeBackoff
+	for {
+		select {
+		case <-time.After(delay):
+		case <-cr.psCtx.Done():
+			return false
+		case <-cr.Host.Ctx.Done():
+			return false
+		}
+
+		cr.subMu.Lock()
+		if cr.leaving {
+			cr.subMu.Unlock()
+			return false
+		}
+		cr.sub.Cancel()
+		sub, err := cr.transport.Subscribe()
+		if err == nil {
+			cr.sub = sub
+		}
+		cr.subMu.Unlock()
+		if err == nil {
+			return true
+		}
+
+		logrus.Debugf("Failed to resubscribe to room '%s': %v", cr.RoomName, err)
+		if delay *= 2; delay > maxResubscribeBackoff {
+			delay = maxResubscribeBackoff
 		}
 	}
 }
 
-// PeerList returns a list of peer IDs connected to the PubSub topic.
+// encodeFrame serializes messages into a PubSub frame. A single message is encoded
+// as a plain JSON object, while a batch is encoded as a JSON array.
+func encodeFrame(msgs []ChatMessage) ([]byte, error) {
+	if len(msgs) == 1 {
+		return json.Marshal(msgs[0])
+	}
+	return json.Marshal(msgs)
+}
+
+// decodeFrame deserializes a PubSub frame produced by encodeFrame.
+func decodeFrame(data []byte) ([]ChatMessage, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var msgs []ChatMessage
+		if err := json.Unmarshal(trimmed, &msgs); err != nil {
+			return nil, err
+		}
+		return msgs, nil
+	}
+
+	var msg ChatMessage
+	if err := json.Unmarshal(trimmed, &msg); err != nil {
+		return nil, err
+	}
+	return []ChatMessage{msg}, nil
+}
+
+// PeerList returns a list of peer IDs connected to the room transport.
 func (cr *ChatRoom) PeerList() []peer.ID {
-	return cr.psTopic.ListPeers()
+	return cr.transport.Peers()
 }
 
-// Exit gracefully leaves the chat room by canceling the subscription and closing the topic.
+// Exit gracefully leaves the chat room by canceling the subscription and closing the transport.
+// Messages still waiting to be published are flushed first, waiting at most exitFlushTimeout.
+// Exit is safe to call more than once and from several goroutines; only the first call
+// has an effect, and the others wait for it to finish.
 func (cr *ChatRoom) Exit() {
-	defer cr.psCancel()
-	cr.psSub.Cancel()
-	cr.psTopic.Close()
+	cr.exitOnce.Do(cr.leave)
+}
+
+// leave implements Exit.
+func (cr *ChatRoom) leave() {
+	// Ask publishLoop to flush and wait for it to finish
+	close(cr.stopPublish)
+	select {
+	case <-cr.publishDone:
+	case <-time.After(exitFlushTimeout):
+		logrus.Debugf("Timed out flushing outbound messages for room '%s'", cr.RoomName)
+	}
+
+	// Stop the room loops first, so subscribeLoop returns from Next without relying on
+	// the PubSub event loop, which may already be shutting down
+	cr.psCancel()
+	cr.Host.removeRoom(cr)
+
+	cr.subMu.Lock()
+	cr.leaving = true
+	cr.sub.Cancel()
+	cr.subMu.Unlock()
+	if err := cr.transport.Close(); err != nil {
+		logrus.Debugf("Failed to close the transport of room '%s': %v", cr.RoomName, err)
+	}
+	if cr.history != nil {
+		cr.history.Close()
+	}
+}
+
+// UserName returns the name of the user in the chat room.
+func (cr *ChatRoom) UserName() string {
+	cr.userMu.RLock()
+	defer cr.userMu.RUnlock()
+	return cr.userName
 }
 
 // UpdateUser updates the username for the chat room user.
+// The new name is announced to the room immediately, with a warning logged
+// if another peer in the room already uses it.
 func (cr *ChatRoom) UpdateUser(newUsername string) {
-	cr.UserName = newUsername
+	mention := mentionPattern(newUsername)
+	cr.userMu.Lock()
+	previous := cr.userName
+	cr.userName = newUsername
+	cr.mention = mention
+	cr.userMu.Unlock()
+
+	go func() {
+		if !strings.EqualFold(newUsername, previous) {
+			if owner, taken := cr.nameOwner(newUsername); taken {
+				cr.handler.OnLog(cr.nameCollisionLog(newUsername, owner))
+			}
+		}
+		cr.announcePresence()
+	}()
 }
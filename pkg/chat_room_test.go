@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	for _, msgs := range [][]ChatMessage{
+		{{Message: "single", SenderID: "a"}},
+		{{Message: "first", SenderID: "a"}, {Message: "second", SenderID: "a"}, {Message: "third", SenderID: "b"}},
+	} {
+		data, err := encodeFrame(msgs)
+		if err != nil {
+			t.Fatalf("encodeFrame: %v", err)
+		}
+		if batch := data[0] == '['; batch != (len(msgs) > 1) {
+			t.Errorf("frame of %d messages encoded as %s", len(msgs), data)
+		}
+		got, err := decodeFrame(data)
+		if err != nil {
+			t.Fatalf("decodeFrame: %v", err)
+		}
+		if !reflect.DeepEqual(got, msgs) {
+			t.Errorf("decodeFrame = %+v, want %+v", got, msgs)
+		}
+	}
+}
+
+func TestBatchWindowCoalescesMessages(t *testing.T) {
+	const window = 200 * time.Millisecond
+	cr, transport := joinFakeRoom(t, WithBatchWindow(window))
+
+	start := time.Now()
+	for _, text := range []string{"one", "two", "three"} {
+		cr.Outbound <- text
+	}
+	msgs := nextPublished(t, cr, transport)
+	if elapsed := time.Since(start); elapsed < window {
+		t.Errorf("batch was published after %s, before the %s window elapsed", elapsed, window)
+	}
+	var texts []string
+	for _, msg := range msgs {
+		texts = append(texts, msg.Message)
+	}
+	if want := []string{"one", "two", "three"}; !reflect.DeepEqual(texts, want) {
+		t.Errorf("batch carries %q, want %q", texts, want)
+	}
+}
+
+func TestNoBatchingPublishesEachMessage(t *testing.T) {
+	cr, transport := joinFakeRoom(t)
+	for _, text := range []string{"one", "two"} {
+		cr.Outbound <- text
+		if msgs := nextPublished(t, cr, transport); len(msgs) != 1 || msgs[0].Message != text {
+			t.Errorf("published %+v, want only %q", msgs, text)
+		}
+	}
+}
+
+func TestSubscriptionErrorResubscribes(t *testing.T) {
+	cr, transport := joinFakeRoom(t)
+	author := newTestAuthor(t)
+
+	// Fail the subscription as a reset stream would, and wait for a new one
+	transport.failures <- errors.New("stream reset")
+	timeout := time.After(testTimeout)
+	for resubscribed := false; !resubscribed; {
+		select {
+		case log := <-cr.Logs:
+			resubscribed = log.Msg == "resubscribed to the room"
+		case <-timeout:
+			t.Fatal("the failed subscription was not replaced")
+		}
+	}
+
+	transport.frames <- author.frame(t, author.message("after the error"))
+	if msg := receive(t, cr); msg.Message != "after the error" {
+		t.Errorf("received %q, want the message sent after the error", msg.Message)
+	}
+	if n := transport.subscriptions(); n != 2 {
+		t.Errorf("subscribed %d times, want 2", n)
+	}
+}
+
+func TestExitClosesInbound(t *testing.T) {
+	cr, _ := joinFakeRoom(t)
+	cr.Exit()
+	select {
+	case _, ok := <-cr.Inbound:
+		if ok {
+			t.Error("Inbound delivered a message after Exit")
+		}
+	case <-time.After(testTimeout):
+		t.Error("Inbound was not closed after Exit")
+	}
+}
+
+func TestExitTwiceAndConcurrently(t *testing.T) {
+	cr, err := JoinChatRoom(newTestPeerNetwork(t), "me", "exit")
+	if err != nil {
+		t.Fatalf("JoinChatRoom: %v", err)
+	}
+
+	// subscribeLoop is blocked in Next while the room is left
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				cr.Exit()
+			}()
+		}
+		wg.Wait()
+		cr.Exit()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("Exit did not return")
+	}
+}
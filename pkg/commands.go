@@ -0,0 +1,365 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rivo/tview"
+)
+
+// uiCommandSpec describes a user command, its argument syntax and how it is handled.
+type uiCommandSpec struct {
+	Name        string            // Command name including the leading slash
+	Args        string            // Argument syntax, empty when the command takes none
+	Description string            // One-line description shown by /help
+	Usage       string            // Short description shown in the usage box; empty hides the command there
+	Secret      bool              // Whether the arguments after the first hold a secret, kept out of the input history
+	Handler     func(*UI, string) // Executes the command with its raw argument
+}
+
+// uiCommands is the registry of user commands, in the order they are listed by /help.
+// It is populated in init because the /help handler refers back to it.
+var uiCommands []uiCommandSpec
+
+func init() {
+	uiCommands = []uiCommandSpec{
+		{Name: "/help", Description: "list the available commands", Usage: "help", Handler: (*UI).showHelp},
+		{Name: "/exit", Description: "leave every room and exit PeerNet, after confirming", Usage: "exit", Handler: (*UI).cmdExit},
+		{Name: "/quit", Description: "leave every room and exit PeerNet immediately", Handler: (*UI).cmdQuit},
+		{Name: "/room", Args: "<roomname> [passphrase]", Description: "leave the current room and join another in its place, encrypted with the passphrase if given", Usage: "switch rooms", Secret: true, Handler: (*UI).cmdRoom},
+		{Name: "/join", Args: "<roomname> [passphrase]", Description: "join another room in a new tab, keeping the current rooms open", Usage: "join room", Secret: true, Handler: (*UI).cmdJoin},
+		{Name: "/switch", Args: "[roomname]", Description: "show an open room, or the next tab when no name is given (also Ctrl+N)", Handler: (*UI).cmdSwitch},
+		{Name: "/leave", Description: "leave the current room and close its tab", Handler: (*UI).cmdLeave},
+		{Name: "/user", Args: "<username>", Description: "change your display name", Usage: "change name", Handler: (*UI).cmdUser},
+		{Name: "/profile", Args: "[name] [color]", Description: "switch to a profile with its own display name and name color, e.g. /profile alice orange", Handler: (*UI).cmdProfile},
+		{Name: "/msg", Args: "<peer> <message>", Description: "send a private message to a peer by username or short ID", Usage: "direct message", Handler: (*UI).cmdMsg},
+		{Name: "/sendfile", Args: "<path>", Description: "send a file to every peer in the room", Handler: (*UI).cmdSendFile},
+		{Name: "/block", Args: "<peer>", Description: "disconnect from a peer by username, short or full ID and refuse its connections", Handler: (*UI).cmdBlock},
+		{Name: "/unblock", Args: "<peer>", Description: "allow connections with a blocked peer again, by username, short or full ID", Handler: (*UI).cmdUnblock},
+		{Name: "/ignore", Args: "[peer]", Description: "hide the messages of a peer by username or ID in every room, or list the ignored peers", Handler: (*UI).cmdIgnore},
+		{Name: "/unignore", Args: "<peer>", Description: "show the messages of an ignored peer again", Handler: (*UI).cmdUnignore},
+		{Name: "/me", Args: "<action>", Description: "describe an action in the third person, e.g. /me waves", Handler: (*UI).cmdMe},
+		{Name: "/react", Args: "[n] <reaction>", Description: "react to the n-th most recent message, the latest by default", Handler: (*UI).cmdReact},
+		{Name: "/connect", Args: "<multiaddr>", Description: "connect to a peer directly by a multiaddr ending in /p2p/<peer-id>", Handler: (*UI).cmdConnect},
+		{Name: "/whoami", Description: "show your peer ID and the addresses other peers can dial", Handler: (*UI).showWhoami},
+		{Name: "/peers", Description: "list connected peers with their full IDs, usernames and addresses", Handler: (*UI).showPeers},
+		{Name: "/stats", Description: "show session and network statistics", Handler: (*UI).showStats},
+		{Name: "/theme", Args: "[name]", Description: "switch the color theme, or list the themes", Handler: (*UI).cmdTheme},
+		{Name: "/search", Args: "<term>", Description: "list the messages in the message box that contain a term, ignoring case, or match /regex/", Handler: (*UI).cmdSearch},
+		{Name: "/exportkey", Args: "<path> <passphrase>", Description: "save the key of the current encrypted room to a file, encrypted with the passphrase", Secret: true, Handler: (*UI).cmdExportKey},
+		{Name: "/importkey", Args: "<path> <passphrase>", Description: "join the room of a key file saved by /exportkey in a new tab, using its key", Secret: true, Handler: (*UI).cmdImportKey},
+		{Name: "/export", Args: "<path>", Description: "save the messages of the message box to a text file, or JSON if the path ends in .json", Handler: (*UI).cmdExport},
+		{Name: "/clear", Description: "clear the message box", Usage: "clear chat", Handler: (*UI).cmdClear},
+		{Name: "/clearhistory", Description: "clear the message box and delete the current room's stored history, after confirming", Handler: (*UI).cmdClearHistory},
+	}
+}
+
+// lookupCommand returns the registered command with the given name.
+func lookupCommand(name string) (uiCommandSpec, bool) {
+	for _, spec := range uiCommands {
+		if spec.Name == name {
+			return spec, true
+		}
+	}
+	return uiCommandSpec{}, false
+}
+
+// historyEntry returns an input line as it is kept in the input history. Commands
+// taking a secret are kept with their first argument only, so a passphrase can never
+// be recalled with the up arrow.
+func historyEntry(line string) string {
+	name, arg, _ := strings.Cut(line, " ")
+	spec, ok := lookupCommand(name)
+	if !ok || !spec.Secret {
+		return line
+	}
+	if first, _, secret := strings.Cut(arg, " "); secret {
+		return name + " " + first
+	}
+	return line
+}
+
+// syntax returns the command name followed by its argument syntax.
+func (spec uiCommandSpec) syntax() string {
+	if spec.Args == "" {
+		return spec.Name
+	}
+	return spec.Name + " " + spec.Args
+}
+
+// usageText builds the usage box text from the command registry.
+func usageText() string {
+	var entries []string
+	for _, spec := range uiCommands {
+		if spec.Usage != "" {
+			entries = append(entries, fmt.Sprintf("[red]%s[green] - %s", tview.Escape(spec.syntax()), spec.Usage))
+		}
+	}
+	return strings.Join(entries, " | ")
+}
+
+// showHelp lists every registered command in the message box.
+func (ui *UI) showHelp(string) {
+	ui.App.QueueUpdateDraw(func() {
+		ui.writeLine("[red](help)[-] available commands:", "")
+		for _, spec := range uiCommands {
+			ui.writeLine(fmt.Sprintf("  [yellow]%s[-] - %s", tview.Escape(spec.syntax()), spec.Description), "")
+		}
+		ui.writeLine(fmt.Sprintf("[red](help)[-] text macros, followed by optional text: %s", tview.Escape(strings.Join(ui.macroNames(), ", "))), "")
+		ui.MessageBox.ScrollToEnd()
+	})
+}
+
+// showPeers lists every connected peer with its full ID, known username and
+// multiaddrs, sorted by peer ID.
+func (ui *UI) showPeers(string) {
+	cr := ui.CurrentRoom()
+	nodeHost := cr.Host.Host
+	peers := nodeHost.Network().Peers()
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Pretty() < peers[j].Pretty() })
+
+	ui.App.QueueUpdateDraw(func() {
+		ui.writeLine(fmt.Sprintf("[red](peers)[-] %d connected", len(peers)), "")
+		for _, id := range peers {
+			name, ok := cr.PeerName(id)
+			if !ok {
+				name = "-"
+			}
+			ui.writeLine(fmt.Sprintf("  [yellow]%s[-] %s", id.Pretty(), tview.Escape(name)), "")
+
+			var addrs []string
+			for _, addr := range nodeHost.Peerstore().Addrs(id) {
+				addrs = append(addrs, addr.String())
+			}
+			sort.Strings(addrs)
+			for _, addr := range addrs {
+				ui.writeLine("    "+addr, "")
+			}
+		}
+		ui.MessageBox.ScrollToEnd()
+	})
+}
+
+// cmdConnect connects to a peer by its multiaddr in the background.
+func (ui *UI) cmdConnect(arg string) {
+	if arg == "" {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: "missing multiaddr"})
+		return
+	}
+	go ui.connect(ui.Host, arg)
+}
+
+// connect dials a peer from the host and reports the outcome in the message box.
+func (ui *UI) connect(host *PeerNetwork, addr string) {
+	ui.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("connecting to %s", addr)})
+	id, err := host.Connect(addr)
+	if err != nil {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("could not connect: %s", err)})
+		return
+	}
+	ui.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("connected to %s", id.Pretty())})
+	ui.updatePeerBox()
+}
+
+// showWhoami shows the local peer ID, username and dialable multiaddrs.
+func (ui *UI) showWhoami(string) {
+	id, name, addrs := ui.Host.Host.ID(), ui.UserName(), ui.Host.Addrs()
+
+	ui.App.QueueUpdateDraw(func() {
+		ui.writeLine(fmt.Sprintf("[red](whoami)[-] [yellow]%s[-] %s", id.Pretty(), tview.Escape(name)), "")
+		for _, addr := range addrs {
+			ui.writeLine("    "+addr, "")
+		}
+		ui.MessageBox.ScrollToEnd()
+	})
+}
+
+// cmdExit stops the UI, asking for confirmation first unless it is disabled.
+func (ui *UI) cmdExit(string) {
+	if !ui.confirmExit {
+		ui.Close()
+		return
+	}
+	ui.App.QueueUpdateDraw(ui.confirmAndExit)
+}
+
+// cmdQuit stops the UI without asking.
+func (ui *UI) cmdQuit(string) {
+	ui.Close()
+}
+
+// cmdClear clears the message box.
+func (ui *UI) cmdClear(string) {
+	ui.App.QueueUpdateDraw(func() {
+		ui.clearMessages()
+	})
+}
+
+// cmdClearHistory clears the message box and deletes the stored history of the
+// current room once confirmed.
+func (ui *UI) cmdClearHistory(string) {
+	ui.App.QueueUpdateDraw(ui.confirmClearHistory)
+}
+
+// cmdRoom switches to another chat room.
+func (ui *UI) cmdRoom(arg string) {
+	if arg == "" {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: "missing room name"})
+		return
+	}
+	roomName, passphrase, _ := strings.Cut(arg, " ")
+	ui.switchRoom(roomName, passphrase)
+}
+
+// cmdJoin joins another room in a new tab.
+func (ui *UI) cmdJoin(arg string) {
+	if arg == "" {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: "missing room name"})
+		return
+	}
+	roomName, passphrase, _ := strings.Cut(arg, " ")
+	ui.joinRoom(roomName, WithRoomKey(passphrase))
+}
+
+// cmdSwitch shows an open room, or the next tab.
+func (ui *UI) cmdSwitch(arg string) {
+	if arg == "" {
+		ui.cycleRoom()
+		return
+	}
+	v, ok := ui.findRoom(arg)
+	if !ok {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("not in room '%s', use /join to join it", arg)})
+		return
+	}
+	ui.activate(v)
+}
+
+// cmdLeave leaves the current room.
+func (ui *UI) cmdLeave(string) {
+	ui.leaveRoom()
+}
+
+// cmdMe posts a /me action to the current room.
+func (ui *UI) cmdMe(arg string) {
+	if arg == "" {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: "missing action"})
+		return
+	}
+	chatMsg, err := ui.PostAction(arg)
+	if err != nil {
+		if err != ErrMessageDropped {
+			ui.OnLog(ChatLog{Prefix: "puberr", Msg: err.Error()})
+		}
+		return
+	}
+	ui.displayChatMessage(chatMsg, senderColor(chatMsg, ui.currentTheme().Self))
+}
+
+// cmdUser changes the display name.
+func (ui *UI) cmdUser(arg string) {
+	if arg == "" {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: "missing username"})
+		return
+	}
+	ui.UpdateUser(arg)
+	ui.InputBox.SetLabel(ui.UserName() + " > ")
+}
+
+// cmdMsg sends a direct message to a peer.
+func (ui *UI) cmdMsg(arg string) {
+	parts := strings.SplitN(arg, " ", 2)
+	if len(parts) < 2 || parts[1] == "" {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: "usage: /msg <peer-id> <message>"})
+		return
+	}
+	go ui.sendDirect(ui.CurrentRoom(), parts[0], parts[1])
+}
+
+// cmdSendFile sends a file to the room in the background.
+func (ui *UI) cmdSendFile(arg string) {
+	if arg == "" {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: "missing file path"})
+		return
+	}
+	go ui.sendFile(ui.CurrentRoom(), arg)
+}
+
+// cmdBlock blocks a peer and disconnects from it.
+func (ui *UI) cmdBlock(arg string) {
+	if arg == "" {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: "missing peer"})
+		return
+	}
+
+	// Peers that are not connected can still be blocked by their full ID
+	id, err := ui.resolvePeer(arg)
+	if err != nil {
+		var decodeErr error
+		if id, decodeErr = peer.Decode(arg); decodeErr != nil {
+			ui.OnLog(ChatLog{Prefix: "error", Msg: err.Error()})
+			return
+		}
+	}
+
+	if err := ui.Host.BlockPeer(id); err != nil {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("blocked %s, but could not disconnect from it: %s", ui.displayName(id), err)})
+		return
+	}
+	ui.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("blocked %s", ui.displayName(id))})
+}
+
+// cmdUnblock allows connections with a blocked peer again.
+func (ui *UI) cmdUnblock(arg string) {
+	if arg == "" {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: "missing peer"})
+		return
+	}
+	id, err := ui.resolvePeer(arg)
+	if err != nil {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: err.Error()})
+		return
+	}
+	if !ui.Host.UnblockPeer(id) {
+		ui.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("%s is not blocked", ui.displayName(id))})
+		return
+	}
+	ui.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("unblocked %s, it can connect again", ui.displayName(id))})
+}
+
+// cmdReact reacts to a recent message, given by how many messages back it is.
+func (ui *UI) cmdReact(arg string) {
+	n, reaction := 1, strings.TrimSpace(arg)
+	if first, rest, ok := strings.Cut(reaction, " "); ok {
+		if back, err := strconv.Atoi(first); err == nil {
+			n, reaction = back, strings.TrimSpace(rest)
+		}
+	}
+	if reaction == "" || n < 1 {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: "usage: /react [n] <reaction>"})
+		return
+	}
+
+	cr := ui.CurrentRoom()
+	ui.App.QueueUpdateDraw(func() {
+		target, ok := ui.recentMessageID(n)
+		if !ok {
+			ui.writeLine("[red](error)[-] no such message to react to", "")
+			return
+		}
+		go ui.sendReaction(cr, target, reaction)
+	})
+}
+
+// sendReaction publishes a reaction to a room and counts it locally.
+func (ui *UI) sendReaction(cr *ChatRoom, target, reaction string) {
+	chatMsg, err := cr.React(target, reaction)
+	if err != nil {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("could not react: %s", err)})
+		return
+	}
+	ui.OnMessage(chatMsg)
+}
@@ -0,0 +1,23 @@
+package pkg
+
+import "testing"
+
+func TestHistoryEntryDropsSecrets(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"/join dev", "/join dev"},
+		{"/join dev room secret", "/join dev"},
+		{"/room dev secret", "/room dev"},
+		{"/exportkey key.json secret", "/exportkey key.json"},
+		{"/importkey key.json secret", "/importkey key.json"},
+		{"/msg bob secret", "/msg bob secret"},
+		{"hello /join dev secret", "hello /join dev secret"},
+	}
+	for _, tt := range tests {
+		if got := historyEntry(tt.line); got != tt.want {
+			t.Errorf("historyEntry(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"sort"
+	"strings"
+)
+
+// tabCompleter completes the last token of the input line, cycling through the
+// candidates on repeated presses. It is only accessed from the UI goroutine.
+type tabCompleter struct {
+	peers func() []string // Peer short IDs and usernames offered after /msg and /block
+
+	last    string   // Line produced by the previous completion
+	base    string   // Line up to the token being completed
+	options []string // Candidates for the token being completed
+	index   int      // Index of the candidate currently shown
+}
+
+// Complete returns the line with its last token completed. Pressing Tab again on an
+// unchanged line moves to the next candidate. It reports false when nothing matches.
+func (c *tabCompleter) Complete(line string) (string, bool) {
+	if len(c.options) > 0 && line == c.last {
+		c.index = (c.index + 1) % len(c.options)
+	} else {
+		c.base, c.options = c.candidates(line)
+		c.index = 0
+		if len(c.options) == 0 {
+			c.last = ""
+			return "", false
+		}
+	}
+
+	c.last = c.base + c.options[c.index] + " "
+	return c.last, true
+}
+
+// candidates splits the line before its last token and returns the sorted
+// candidates matching that token case-insensitively.
+func (c *tabCompleter) candidates(line string) (string, []string) {
+	split := strings.LastIndex(line, " ") + 1
+	base, token := line[:split], strings.ToLower(line[split:])
+
+	var pool []string
+	switch {
+	case strings.HasPrefix(line, "/") && split == 0:
+		for _, spec := range uiCommands {
+			pool = append(pool, spec.Name)
+		}
+	case (strings.HasPrefix(line, "/msg ") || strings.HasPrefix(line, "/block ")) && strings.Count(line, " ") == 1:
+		if c.peers != nil {
+			pool = c.peers()
+		}
+	}
+
+	var options []string
+	for _, candidate := range pool {
+		if strings.HasPrefix(strings.ToLower(candidate), token) {
+			options = append(options, candidate)
+		}
+	}
+	sort.Strings(options)
+	return base, options
+}
+
+// completionPeers returns the short IDs and usernames of connected peers that /msg and /block accept.
+// Usernames containing spaces are left out since they cannot be typed as a single argument.
+func (ui *UI) completionPeers() []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string) {
+		if name != "" && !seen[name] && !strings.Contains(name, " ") {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	// Completion runs on the UI goroutine, so read the room the event loop last switched to
+	cr := ui.CurrentRoom()
+	for _, id := range cr.Host.Host.Network().Peers() {
+		add(shortID(id))
+		if name, ok := cr.PeerName(id); ok {
+			add(name)
+		}
+	}
+	return names
+}
@@ -1,58 +1,179 @@
 package pkg
 
 import (
-	"context"
 	"crypto/sha256"
+	"fmt"
 	"time"
 
 	"github.com/ipfs/go-cid"
-	"github.com/libp2p/go-libp2p-core/host"
+	coreDiscovery "github.com/libp2p/go-libp2p-core/discovery"
 	"github.com/libp2p/go-libp2p-core/peer"
+	mdns "github.com/libp2p/go-libp2p/p2p/discovery"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/multiformats/go-multihash"
 	"github.com/sirupsen/logrus"
 )
 
+// mdnsInterval is how often the local network is queried for peers.
+const mdnsInterval = 10 * time.Second
+
+// DefaultDiscoveryInterval is the default interval between discovery rounds after the first.
+const DefaultDiscoveryInterval = time.Minute
+
+// Default readiness requirements checked before the first discovery round.
+const (
+	DefaultReadyPeers   = 1                // Minimum peers in the DHT routing table
+	DefaultReadyTimeout = 30 * time.Second // Time allowed to reach the minimum
+)
+
+// DefaultAdvertiseTTL is the default TTL of service advertisements, the longest the DHT
+// discovery accepts.
+const DefaultAdvertiseTTL = 3 * time.Hour
+
+// DefaultMinAdvertiseInterval is the default minimum time between two advertisements of
+// the service, however short the advertisement TTL.
+const DefaultMinAdvertiseInterval = time.Minute
+
+// readvertiseFraction is the part of the advertisement TTL after which the service is
+// advertised again, leaving time for the advertisement to reach the DHT before it expires.
+const readvertiseFraction = 0.75
+
+// readyPollInterval is how often the DHT routing table size is checked while waiting.
+const readyPollInterval = 250 * time.Millisecond
+
 // AdvertiseConnect advertises the PeerChat service and connects to peers.
+// Peer lookup is repeated every discovery interval, and the advertisement is renewed
+// before its TTL elapses, until the PeerNetwork context is cancelled, so peers joining
+// later are found and the node stays discoverable.
 func (p *PeerNetwork) AdvertiseConnect() error {
-	ttl, err := p.Discovery.Advertise(p.Ctx, SERVICE)
+	if err := p.waitForDHT(); err != nil {
+		return err
+	}
+
+	ttl, err := p.advertise()
 	if err != nil {
 		return err
 	}
+
+	if err := p.findServicePeers(); err != nil {
+		return err
+	}
+
+	go p.advertiseLoop(ttl)
+	go p.discoveryLoop(p.findServicePeers)
+	return nil
+}
+
+// advertise advertises the PeerChat service with the configured TTL and returns the
+// TTL the advertisement is valid for.
+func (p *PeerNetwork) advertise() (time.Duration, error) {
+	ttl, err := p.Discovery.Advertise(p.Ctx, p.serviceName(), coreDiscovery.TTL(p.advertiseTTL))
+	if err != nil {
+		return 0, err
+	}
 	logrus.Debugf("Advertised PeerChat Service, TTL: %s", ttl)
+	return ttl, nil
+}
+
+// advertiseLoop advertises the service again before the TTL of the last advertisement
+// elapses, until the PeerNetwork context is cancelled. Advertisements are never closer
+// together than the minimum advertise interval, and failed ones are retried after it.
+func (p *PeerNetwork) advertiseLoop(ttl time.Duration) {
+	for {
+		delay := time.Duration(float64(ttl) * readvertiseFraction)
+		if delay < p.minAdvertiseInterval {
+			delay = p.minAdvertiseInterval
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-p.Ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
 
-	// Allow time for the advertisement to propagate
-	time.Sleep(5 * time.Second)
+		next, err := p.advertise()
+		if err != nil {
+			logrus.Debugf("Failed to advertise the PeerChat Service: %v", err)
+			next = 0
+		}
+		ttl = next
+	}
+}
 
-	peerChan, err := p.Discovery.FindPeers(p.Ctx, SERVICE)
+// findServicePeers looks up peers advertising the PeerChat service and connects to them.
+func (p *PeerNetwork) findServicePeers() error {
+	peerChan, err := p.Discovery.FindPeers(p.Ctx, p.serviceName())
 	if err != nil {
 		return err
 	}
 
-	go handlePeerDiscovery(p.Host, peerChan)
+	go p.handlePeerDiscovery(peerChan)
 	return nil
 }
 
-// AnnounceConnect announces the PeerChat service CID and connects to peers.
-func (p *PeerNetwork) AnnounceConnect() error {
+// DefaultProviderLimit is the default maximum number of service providers
+// queried from the DHT by AnnounceConnect.
+const DefaultProviderLimit = 20
+
+// AnnounceConnect announces the PeerChat service CID and connects to at most
+// providerLimit discovered providers per discovery round. A limit of zero means unlimited.
+func (p *PeerNetwork) AnnounceConnect(providerLimit int) error {
 	// Generate the Service CID
-	cidValue, err := generateCID(SERVICE)
+	cidValue, err := generateCID(p.serviceName())
 	if err != nil {
 		return err
 	}
 
-	// Announce that this host can provide the service
-	if err := p.KadDHT.Provide(p.Ctx, cidValue, true); err != nil {
+	// Announce that this host can provide the service once the DHT can carry it
+	if err := p.waitForDHT(); err != nil {
+		return err
+	}
+	if err := p.providers.Provide(p.Ctx, cidValue, true); err != nil {
 		return err
 	}
 	logrus.Debugln("Announced the PeerChat Service")
-	time.Sleep(5 * time.Second)
 
-	// Discover other providers for the service CID
-	peerChan := p.KadDHT.FindProvidersAsync(p.Ctx, cidValue, 0)
-	go handlePeerDiscovery(p.Host, peerChan)
+	// Discover other providers for the service CID, repeating every discovery interval
+	go p.handlePeerDiscovery(p.providers.FindProvidersAsync(p.Ctx, cidValue, providerLimit))
+	go p.discoveryLoop(func() error {
+		if err := p.providers.Provide(p.Ctx, cidValue, true); err != nil {
+			return err
+		}
+		go p.handlePeerDiscovery(p.providers.FindProvidersAsync(p.Ctx, cidValue, providerLimit))
+		return nil
+	})
+	return nil
+}
+
+// MdnsConnect discovers PeerNet peers on the local network using mDNS and connects to them.
+// It does not rely on the public DHT, so it works on offline LANs.
+func (p *PeerNetwork) MdnsConnect() error {
+	service, err := mdns.NewMdnsService(p.Ctx, p.Host, mdnsInterval, p.mdnsServiceTag())
+	if err != nil {
+		return err
+	}
+	p.mdns = service
+	logrus.Debugln("Started the mDNS Discovery Service")
+
+	peerChan := make(chan peer.AddrInfo, 16)
+	service.RegisterNotifee(&mdnsNotifee{peerChan: peerChan})
+	go p.handlePeerDiscovery(peerChan)
 	return nil
 }
 
+// mdnsNotifee forwards peers found by the mDNS service to a discovery channel.
+type mdnsNotifee struct {
+	peerChan chan peer.AddrInfo
+}
+
+// HandlePeerFound is called by the mDNS service for every peer found on the local network.
+func (n *mdnsNotifee) HandlePeerFound(peerInfo peer.AddrInfo) {
+	n.peerChan <- peerInfo
+}
+
 // generateCID creates a CID (Content Identifier) from a given name by hashing it with SHA-256
 // and encoding it as a multihash.
 func generateCID(name string) (cid.Cid, error) {
@@ -68,11 +189,100 @@ func generateCID(name string) (cid.Cid, error) {
 	return newCID, nil
 }
 
-// handlePeerDiscovery listens on a peer channel for discovered peers and connects to them.
-func handlePeerDiscovery(nodeHost host.Host, peerChan <-chan peer.AddrInfo) {
-	for peer := range peerChan {
-		if peer.ID != nodeHost.ID() {
-			nodeHost.Connect(context.Background(), peer)
+// discoveryLoop runs a discovery round every discovery interval until the
+// PeerNetwork context is cancelled. Failed rounds are logged and retried on the next tick.
+// The round can also be run early with Rediscover.
+func (p *PeerNetwork) discoveryLoop(round func() error) {
+	p.discoveryMu.Lock()
+	p.discoveryRound = round
+	p.discoveryMu.Unlock()
+
+	ticker := time.NewTicker(p.discoveryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.Ctx.Done():
+			return
+		case <-ticker.C:
+			if err := round(); err != nil {
+				logrus.Debugf("Discovery round failed: %v", err)
+			}
 		}
 	}
 }
+
+// Rediscover runs a DHT discovery round right away, e.g. after every peer of a room
+// was lost. mDNS discovery queries the local network continuously and needs no extra
+// round, and without any discovery method there is nothing to rerun.
+func (p *PeerNetwork) Rediscover() error {
+	p.discoveryMu.Lock()
+	round := p.discoveryRound
+	p.discoveryMu.Unlock()
+
+	if round == nil {
+		return nil
+	}
+	return round()
+}
+
+// waitForDHT waits until the DHT routing table holds the minimum number of peers
+// required for discovery, returning an error if that takes longer than the ready
+// timeout or the PeerNetwork context is cancelled.
+func (p *PeerNetwork) waitForDHT() error {
+	timeout := time.NewTimer(p.readyTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+
+	for {
+		size := p.KadDHT.RoutingTable().Size()
+		if size >= p.readyPeers {
+			logrus.Debugf("DHT routing table ready with %d peers", size)
+			return nil
+		}
+
+		select {
+		case <-p.Ctx.Done():
+			return p.Ctx.Err()
+		case <-timeout.C:
+			return fmt.Errorf("DHT routing table has %d of the %d peers required for discovery after %s", size, p.readyPeers, p.readyTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// handlePeerDiscovery listens on a peer channel for discovered peers and connects to them,
+// retrying unreachable peers with backoff. Peers may be found with IPv4 and IPv6
+// addresses alike, and are dialed on whichever of them work.
+func (p *PeerNetwork) handlePeerDiscovery(peerChan <-chan peer.AddrInfo) {
+	for peerInfo := range peerChan {
+		if peerInfo.ID == p.Host.ID() {
+			continue
+		}
+		peerInfo.Addrs = dialableAddrs(peerInfo.Addrs)
+		p.discoveredMu.Lock()
+		p.discovered[peerInfo.ID] = struct{}{}
+		p.discoveredMu.Unlock()
+		p.dialer.Dial(p.Ctx, peerInfo)
+	}
+}
+
+// dialableAddrs drops the addresses of a discovered peer that cannot be dialed from
+// another machine, such as IPv6 link-local addresses, which lack the interface zone.
+func dialableAddrs(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+	dialable := make([]multiaddr.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		if !manet.IsIP6LinkLocal(addr) {
+			dialable = append(dialable, addr)
+		}
+	}
+	return dialable
+}
+
+// DiscoveredPeers returns the number of PeerNet peers found by discovery so far.
+func (p *PeerNetwork) DiscoveredPeers() int {
+	p.discoveredMu.Lock()
+	defer p.discoveredMu.Unlock()
+	return len(p.discovered)
+}
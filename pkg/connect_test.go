@@ -0,0 +1,52 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// fakeProviders stands in for the DHT, recording the provider limits it is asked for.
+type fakeProviders struct {
+	limits chan int
+}
+
+func (f fakeProviders) Provide(context.Context, cid.Cid, bool) error {
+	return nil
+}
+
+func (f fakeProviders) FindProvidersAsync(_ context.Context, _ cid.Cid, count int) <-chan peer.AddrInfo {
+	f.limits <- count
+	providers := make(chan peer.AddrInfo)
+	close(providers)
+	return providers
+}
+
+func TestAnnounceConnectProviderLimit(t *testing.T) {
+	for _, limit := range []int{DefaultProviderLimit, 7, 0} {
+		limit := limit
+		t.Run(fmt.Sprint(limit), func(t *testing.T) {
+			t.Parallel()
+			p := newTestPeerNetwork(t)
+			providers := fakeProviders{limits: make(chan int, 1)}
+			p.providers = providers
+			p.readyPeers = 0
+
+			if err := p.AnnounceConnect(limit); err != nil {
+				t.Fatalf("AnnounceConnect: %v", err)
+			}
+			select {
+			case got := <-providers.limits:
+				if got != limit {
+					t.Errorf("FindProvidersAsync got a limit of %d, want %d", got, limit)
+				}
+			case <-time.After(testTimeout):
+				t.Fatal("FindProvidersAsync was not called")
+			}
+		})
+	}
+}
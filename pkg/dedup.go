@@ -0,0 +1,118 @@
+package pkg
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+)
+
+// Default settings of the inbound duplicate message filter.
+const (
+	DefaultDedupSize   = 1024            // Message keys remembered per room
+	DefaultDedupExpiry = 2 * time.Minute // Time after which a key is forgotten
+)
+
+// WithDedup drops inbound messages already received within the expiry, remembering
+// up to size messages. A zero size or expiry disables the filter.
+func WithDedup(size int, expiry time.Duration) RoomOption {
+	return func(cr *ChatRoom) {
+		cr.dedup = newDedupCache(size, expiry)
+	}
+}
+
+// dedupEntry is a remembered message key and when it was first seen.
+type dedupEntry struct {
+	key  string
+	seen time.Time
+}
+
+// dedupCache is a bounded LRU of recently seen message keys, evicting the least
+// recently seen key once full. It is only accessed from the subscribeLoop goroutine.
+type dedupCache struct {
+	size   int
+	expiry time.Duration
+	order  *list.List               // Entries, most recently seen first
+	keys   map[string]*list.Element // Key -> element in order
+}
+
+// newDedupCache creates a dedupCache, returning nil when the size or expiry is not positive.
+func newDedupCache(size int, expiry time.Duration) *dedupCache {
+	if size <= 0 || expiry <= 0 {
+		return nil
+	}
+	return &dedupCache{
+		size:   size,
+		expiry: expiry,
+		order:  list.New(),
+		keys:   make(map[string]*list.Element),
+	}
+}
+
+// Seen records a message from its author and reports whether it was already received
+// within the expiry.
+func (c *dedupCache) Seen(author peer.ID, msg ChatMessage) bool {
+	if c == nil {
+		return false
+	}
+
+	key := dedupKey(author, msg)
+	if key == "" {
+		return false
+	}
+
+	now := time.Now()
+	if elem, ok := c.keys[key]; ok {
+		entry := elem.Value.(*dedupEntry)
+		if now.Sub(entry.seen) < c.expiry {
+			c.order.MoveToFront(elem)
+			return true
+		}
+		// The key expired, so treat the message as new
+		entry.seen = now
+		c.order.MoveToFront(elem)
+		return false
+	}
+
+	c.keys[key] = c.order.PushFront(&dedupEntry{key: key, seen: now})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.keys, oldest.Value.(*dedupEntry).key)
+	}
+	return false
+}
+
+// dedupKey identifies a message by its author and ID. Messages from older clients
+// without an ID are identified by a hash of their timestamp, type and body instead, so
+// identical text sent again later is still delivered. Messages with neither cannot be
+// told apart from intentional repeats and get an empty key.
+func dedupKey(author peer.ID, msg ChatMessage) string {
+	if msg.ID != "" {
+		return string(author) + "/" + msg.ID
+	}
+	if msg.Timestamp.IsZero() {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(msg.Timestamp.UTC().Format(time.RFC3339Nano) + "\x00" + msg.Type + "\x00" + msg.Target + "\x00" + msg.Message))
+	return string(author) + "#" + hex.EncodeToString(hash[:])
+}
+
+// ContentMessageID is a PubSub message ID function, for HostConfig.MessageIDFunc, that
+// identifies a message by a hash of its topic, author and payload rather than its
+// sequence number. A frame published again with the same bytes, e.g. after a
+// reconnection, is then dropped by every peer that already relayed it. Frames carry
+// the ID and timestamp of their messages, so messages sent again on purpose still
+// differ and are delivered, as with the per-room filter of WithDedup.
+func ContentMessageID(pmsg *pb.Message) string {
+	hash := sha256.New()
+	hash.Write([]byte(pmsg.GetTopic()))
+	hash.Write([]byte{0})
+	hash.Write(pmsg.GetFrom())
+	hash.Write([]byte{0})
+	hash.Write(pmsg.GetData())
+	return string(hash.Sum(nil))
+}
@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestDedupCacheDropsDuplicates(t *testing.T) {
+	c := newDedupCache(8, time.Minute)
+	alice, bob := peer.ID("alice"), peer.ID("bob")
+	msg := ChatMessage{ID: "m1", Message: "hi"}
+
+	if c.Seen(alice, msg) {
+		t.Fatal("first message reported as a duplicate")
+	}
+	if !c.Seen(alice, msg) {
+		t.Error("second copy not reported as a duplicate")
+	}
+	if c.Seen(bob, msg) {
+		t.Error("another author's message with the same ID reported as a duplicate")
+	}
+	if c.Seen(alice, ChatMessage{ID: "m2", Message: "hi"}) {
+		t.Error("identical text with a new ID reported as a duplicate")
+	}
+}
+
+func TestDedupCacheEvictsLeastRecentlySeen(t *testing.T) {
+	c := newDedupCache(2, time.Minute)
+	author := peer.ID("alice")
+	first, second, third := ChatMessage{ID: "1"}, ChatMessage{ID: "2"}, ChatMessage{ID: "3"}
+
+	c.Seen(author, first)
+	c.Seen(author, second)
+	c.Seen(author, first) // first is now the most recently seen
+	c.Seen(author, third) // evicts second
+
+	if !c.Seen(author, first) {
+		t.Error("recently seen message was evicted")
+	}
+	if c.Seen(author, second) {
+		t.Error("least recently seen message was not evicted")
+	}
+}
+
+func TestDedupCacheExpiry(t *testing.T) {
+	c := newDedupCache(8, 50*time.Millisecond)
+	author := peer.ID("alice")
+	msg := ChatMessage{ID: "m1"}
+
+	c.Seen(author, msg)
+	time.Sleep(60 * time.Millisecond)
+	if c.Seen(author, msg) {
+		t.Error("message seen before the expiry reported as a duplicate")
+	}
+}
+
+func TestDedupKeyLegacyMessages(t *testing.T) {
+	author := peer.ID("alice")
+	now := time.Now()
+	if key := dedupKey(author, ChatMessage{Message: "hi"}); key != "" {
+		t.Errorf("message without ID or timestamp got key %q, want none", key)
+	}
+	first := dedupKey(author, ChatMessage{Message: "hi", Timestamp: now})
+	again := dedupKey(author, ChatMessage{Message: "hi", Timestamp: now.Add(time.Minute)})
+	if first == "" || first == again {
+		t.Errorf("identical text sent a minute apart got keys %q and %q, want distinct keys", first, again)
+	}
+}
+
+func TestDisabledDedupCache(t *testing.T) {
+	c := newDedupCache(0, time.Minute)
+	msg := ChatMessage{ID: "m1"}
+	if c.Seen("alice", msg) || c.Seen("alice", msg) {
+		t.Error("disabled cache reported a duplicate")
+	}
+}
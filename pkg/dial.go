@@ -0,0 +1,153 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultDialAttempts is the default number of times a discovered peer is dialed.
+const DefaultDialAttempts = 3
+
+// DefaultDialBackoff is the default delay before the first redial of a discovered peer.
+// The delay doubles after every failed attempt.
+const DefaultDialBackoff = time.Second
+
+// connectTimeout bounds a manual connection attempt made with Connect.
+const connectTimeout = 30 * time.Second
+
+// peerDialer connects to discovered peers, retrying failed dials with jittered
+// exponential backoff. Each peer is dialed by at most one goroutine at a time.
+type peerDialer struct {
+	host      host.Host
+	attempts  int           // Total dial attempts per peer
+	baseDelay time.Duration // Delay before the first retry
+	metrics   *Metrics      // Counts dial outcomes, nil when disabled
+
+	mu       sync.Mutex
+	inflight map[peer.ID]struct{} // Peers currently being dialed
+}
+
+// newPeerDialer creates a peerDialer, falling back to the defaults for non-positive settings.
+func newPeerDialer(nodeHost host.Host, attempts int, baseDelay time.Duration, metrics *Metrics) *peerDialer {
+	if attempts <= 0 {
+		attempts = DefaultDialAttempts
+	}
+	if baseDelay <= 0 {
+		baseDelay = DefaultDialBackoff
+	}
+	return &peerDialer{
+		host:      nodeHost,
+		attempts:  attempts,
+		baseDelay: baseDelay,
+		metrics:   metrics,
+		inflight:  make(map[peer.ID]struct{}),
+	}
+}
+
+// Dial connects to a peer in the background unless it is ourselves, already
+// connected or already being dialed.
+func (d *peerDialer) Dial(ctx context.Context, peerInfo peer.AddrInfo) {
+	d.DialThen(ctx, peerInfo, nil)
+}
+
+// DialThen is like Dial, and calls done, if not nil, with the outcome of the dial once
+// all attempts are made. A peer that is already connected counts as a success, while
+// done is not called for ourselves or a peer that is already being dialed.
+func (d *peerDialer) DialThen(ctx context.Context, peerInfo peer.AddrInfo, done func(error)) {
+	if peerInfo.ID == d.host.ID() {
+		return
+	}
+	if d.host.Network().Connectedness(peerInfo.ID) == network.Connected {
+		if done != nil {
+			done(nil)
+		}
+		return
+	}
+
+	d.mu.Lock()
+	if _, ok := d.inflight[peerInfo.ID]; ok {
+		d.mu.Unlock()
+		return
+	}
+	d.inflight[peerInfo.ID] = struct{}{}
+	d.mu.Unlock()
+
+	go func() {
+		defer func() {
+			d.mu.Lock()
+			delete(d.inflight, peerInfo.ID)
+			d.mu.Unlock()
+		}()
+		err := d.dialWithRetry(ctx, peerInfo)
+		if done != nil {
+			done(err)
+		}
+	}()
+}
+
+// dialWithRetry dials a peer until it succeeds, the attempts run out or the context ends,
+// returning the last dial error.
+func (d *peerDialer) dialWithRetry(ctx context.Context, peerInfo peer.AddrInfo) error {
+	delay := d.baseDelay
+	var err error
+	for attempt := 1; attempt <= d.attempts; attempt++ {
+		err = d.host.Connect(ctx, peerInfo)
+		d.metrics.dialed(err)
+		if err == nil {
+			return nil
+		}
+		if attempt == d.attempts {
+			break
+		}
+
+		// Wait between half and the full delay so overlapping retries spread out
+		jittered := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(jittered):
+		}
+		delay *= 2
+	}
+	logrus.Debugf("Failed to connect to peer %s after %d attempts: %v", peerInfo.ID, d.attempts, err)
+	return err
+}
+
+// Connect dials a peer by a multiaddr ending in its /p2p/ peer ID component, such as one
+// printed by /whoami, and returns the peer's ID once connected.
+func (p *PeerNetwork) Connect(addr string) (peer.ID, error) {
+	peerInfo, err := parsePeerAddr(addr)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(p.Ctx, connectTimeout)
+	defer cancel()
+	if err := p.Host.Connect(ctx, *peerInfo); err != nil {
+		return "", err
+	}
+	return peerInfo.ID, nil
+}
+
+// parsePeerAddr parses a peer multiaddr, explaining the common mistake of leaving out
+// the /p2p/ component.
+func parsePeerAddr(addr string) (*peer.AddrInfo, error) {
+	multiAddr, err := multiaddr.NewMultiaddr(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multiaddr '%s': %w", addr, err)
+	}
+	if _, err := multiAddr.ValueForProtocol(multiaddr.P_P2P); err != nil {
+		return nil, errors.New("the address is missing its /p2p/<peer-id> component, e.g. /ip4/1.2.3.4/tcp/4001/p2p/<peer-id>")
+	}
+	return peer.AddrInfoFromP2pAddr(multiAddr)
+}
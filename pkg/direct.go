@@ -0,0 +1,201 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+// DirectMessageProtocol is the stream protocol used for private messages between two peers.
+const DirectMessageProtocol = "/peernet/dm/1.0.0"
+
+// maxDirectMessageSize bounds how much data is read from a single direct message stream.
+const maxDirectMessageSize = 64 << 10
+
+// directMessageTimeout bounds how long sending a direct message may take.
+const directMessageTimeout = 10 * time.Second
+
+// directAckTimeout bounds how long the sender of a direct message waits for the
+// receiver to acknowledge it.
+const directAckTimeout = 10 * time.Second
+
+// directAck is written back on a direct message stream once the message has been
+// delivered to the receiving user.
+type directAck struct {
+	ID string `json:"id"` // ID of the acknowledged message
+}
+
+// handleDirectStream reads a single direct message from an inbound stream, delivers
+// it to the DirectInbound channel and acknowledges it to the sender.
+func (p *PeerNetwork) handleDirectStream(stream network.Stream) {
+	defer stream.Close()
+	defer p.protectTransfer(stream.Conn().RemotePeer())()
+
+	data, err := io.ReadAll(io.LimitReader(stream, maxDirectMessageSize))
+	if err != nil {
+		logrus.Debugf("Failed to read direct message from %s: %v", stream.Conn().RemotePeer(), err)
+		stream.Reset()
+		return
+	}
+
+	var msg ChatMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		logrus.Debugf("Failed to unmarshal direct message from %s: %v", stream.Conn().RemotePeer(), err)
+		return
+	}
+
+	// The sender is whoever opened the stream, regardless of what the message claims
+	msg.SenderID = stream.Conn().RemotePeer().Pretty()
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+
+	select {
+	case p.DirectInbound <- msg:
+	case <-p.Ctx.Done():
+		return
+	}
+
+	if msg.ID == "" {
+		return
+	}
+	if err := json.NewEncoder(stream).Encode(directAck{ID: msg.ID}); err != nil {
+		logrus.Debugf("Failed to acknowledge direct message from %s: %v", stream.Conn().RemotePeer(), err)
+	}
+}
+
+// SendDirectMessage delivers a message to a single peer over a dedicated stream and
+// waits for the peer to acknowledge it. An error is returned if the message could not
+// be sent, or was not acknowledged within directAckTimeout, e.g. because the peer
+// disconnected.
+func (p *PeerNetwork) SendDirectMessage(to peer.ID, msg ChatMessage) error {
+	ctx, cancel := context.WithTimeout(p.Ctx, directMessageTimeout)
+	defer cancel()
+	defer p.protectTransfer(to)()
+
+	stream, err := p.Host.NewStream(ctx, to, DirectMessageProtocol)
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(stream).Encode(msg); err != nil {
+		stream.Reset()
+		return err
+	}
+	if err := stream.CloseWrite(); err != nil {
+		stream.Reset()
+		return err
+	}
+
+	var ack directAck
+	stream.SetReadDeadline(time.Now().Add(directAckTimeout))
+	if err := json.NewDecoder(io.LimitReader(stream, maxDirectMessageSize)).Decode(&ack); err != nil {
+		stream.Reset()
+		return fmt.Errorf("message was not acknowledged: %w", err)
+	}
+	if ack.ID != msg.ID {
+		stream.Reset()
+		return fmt.Errorf("peer acknowledged message %s instead of %s", ack.ID, msg.ID)
+	}
+	return stream.Close()
+}
+
+// FindPeer resolves a short peer ID, as shown in the peer box, to a connected peer.
+func (p *PeerNetwork) FindPeer(short string) (peer.ID, error) {
+	var matches []peer.ID
+	for _, id := range p.Host.Network().Peers() {
+		if strings.HasSuffix(id.Pretty(), short) {
+			matches = append(matches, id)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no connected peer matches '%s'", short)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("'%s' matches %d connected peers, use a longer ID", short, len(matches))
+	}
+}
+
+// SendDirect sends a private message from the room's user to the peer identified by a
+// username or short ID, waiting until the peer acknowledges it.
+func (cr *ChatRoom) SendDirect(short, message string) error {
+	to, chatMsg, err := cr.prepareDirect(short, message)
+	if err != nil {
+		return err
+	}
+	return cr.Host.SendDirectMessage(to, chatMsg)
+}
+
+// prepareDirect resolves the recipient of a private message and prepares the message
+// for sending.
+func (cr *ChatRoom) prepareDirect(short, message string) (peer.ID, ChatMessage, error) {
+	to, err := cr.resolvePeer(short)
+	if err != nil {
+		return "", ChatMessage{}, err
+	}
+
+	chatMsg, err := cr.prepareOutbound(message)
+	if err != nil {
+		return "", ChatMessage{}, err
+	}
+	return to, chatMsg, nil
+}
+
+// resolvePeer resolves a username or short peer ID to a peer, preferring a peer known
+// by that username. Peers that went offline can still be addressed by their username,
+// a short ID seen in the room, or their full peer ID, so messages can be queued for them.
+func (cr *ChatRoom) resolvePeer(nameOrID string) (peer.ID, error) {
+	if id, ok := cr.nameOwner(nameOrID); ok {
+		return id, nil
+	}
+	id, err := cr.Host.FindPeer(nameOrID)
+	if err == nil {
+		return id, nil
+	}
+	if id, decodeErr := peer.Decode(nameOrID); decodeErr == nil {
+		return id, nil
+	}
+	if id, ok := cr.seenPeer(nameOrID); ok {
+		return id, nil
+	}
+	return "", err
+}
+
+// seenPeer resolves a short peer ID to a peer seen in the room, connected or not. It
+// fails if the ID is ambiguous.
+func (cr *ChatRoom) seenPeer(short string) (peer.ID, bool) {
+	if short == "" {
+		return "", false
+	}
+	cr.namesMu.RLock()
+	defer cr.namesMu.RUnlock()
+	var match peer.ID
+	for id := range cr.peerNames {
+		if strings.HasSuffix(id.Pretty(), short) {
+			if match != "" {
+				return "", false
+			}
+			match = id
+		}
+	}
+	return match, match != ""
+}
+
+// shortID returns the abbreviated form of a peer ID shown in the UI.
+func shortID(id peer.ID) string {
+	pretty := id.Pretty()
+	if len(pretty) <= 8 {
+		return pretty
+	}
+	return pretty[len(pretty)-8:]
+}
@@ -0,0 +1,241 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// selfTestTimeout bounds how long any single self-test check may wait on the network.
+const selfTestTimeout = 30 * time.Second
+
+// CheckResult reports the outcome of a single self-test check.
+type CheckResult struct {
+	Name     string // Short name of the check
+	Critical bool   // Whether a failure makes PeerNet unusable
+	Err      error  // Failure reason, nil when the check passed
+	Hint     string // Remediation hint shown on failure
+}
+
+// Passed reports whether the check succeeded.
+func (c CheckResult) Passed() bool {
+	return c.Err == nil
+}
+
+// SelfTest sequentially runs connectivity checks with the identity and host settings of
+// cfg, passing each result to report as it completes. It returns false if any critical
+// check failed.
+func SelfTest(ctx context.Context, cfg HostConfig, report func(CheckResult)) bool {
+	passed := true
+	run := func(name string, critical bool, hint string, check func() error) bool {
+		err := check()
+		report(CheckResult{Name: name, Critical: critical, Err: err, Hint: hint})
+		if err != nil && critical {
+			passed = false
+		}
+		return err == nil
+	}
+	if cfg.Ephemeral {
+		cfg.IdentityPath = ""
+	}
+
+	// Identity loading or generation
+	var prvKey crypto.PrivKey
+	if !run("identity", true, "check that the -identity file is readable and valid, and that the system has a working source of randomness", func() (err error) {
+		prvKey, err = loadIdentity(cfg.IdentityPath, cfg.KeyType)
+		return err
+	}) {
+		return false
+	}
+
+	// Listen bind
+	var nodeHost host.Host
+	var kadDHT *dht.IpfsDHT
+	if !run("listen bind", true, "ensure binding TCP sockets on the -listen addresses is permitted on this machine", func() (err error) {
+		nodeHost, kadDHT, err = setupHost(ctx, prvKey, cfg, nil, nil)
+		if err != nil {
+			return err
+		}
+		if len(nodeHost.Addrs()) == 0 {
+			return errors.New("host has no listen addresses")
+		}
+		return nil
+	}) {
+		return false
+	}
+	defer nodeHost.Close()
+
+	// Private and local-only networks have no bootstrap peers to check
+	bootstrapPeers, err := cfg.bootstrapPeers()
+	if err != nil {
+		report(CheckResult{Name: "bootstrap reachability", Critical: true, Err: err, Hint: "fix the -bootstrap addresses"})
+		return false
+	}
+	if len(bootstrapPeers) > 0 {
+		// Bootstrap reachability
+		run("bootstrap reachability", true, "check internet access and that outbound TCP connections are not blocked by a firewall", func() error {
+			if connectBootstrapPeers(ctx, nodeHost, bootstrapPeers) == 0 {
+				return errors.New("could not connect to any bootstrap peer")
+			}
+			return nil
+		})
+
+		// DHT bootstrap
+		run("DHT bootstrap", true, "bootstrap peers are reachable but the DHT is not; retry later or check for restrictive NAT", func() error {
+			if err := kadDHT.Bootstrap(ctx); err != nil {
+				return err
+			}
+			return waitUntil(ctx, selfTestTimeout, func() bool {
+				return kadDHT.RoutingTable().Size() > 0
+			})
+		})
+	}
+
+	// Reachability (AutoNAT)
+	run("reachability", false, "peers can still reach you through relays; enable UPnP/NAT-PMP or forward a port for direct connections", func() error {
+		reachability, err := awaitReachability(ctx, nodeHost, selfTestTimeout)
+		if err != nil {
+			return err
+		}
+		if reachability != network.ReachabilityPublic {
+			return fmt.Errorf("node is %s", reachability)
+		}
+		return nil
+	})
+
+	// Room loopback
+	run("room loopback", true, "PubSub messages could not be exchanged between two local hosts; check local firewall rules", func() error {
+		return roomLoopback(ctx, nodeHost, cfg)
+	})
+
+	return passed
+}
+
+// roomLoopback joins a test room from nodeHost and a second in-process host, publishes
+// a message from the second host and waits for nodeHost to receive it. The second host
+// listens on loopback only, with the settings of cfg that decide whether it can connect
+// to nodeHost.
+func roomLoopback(ctx context.Context, nodeHost host.Host, cfg HostConfig) error {
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+
+	prvKey, err := generateIdentity(KeyTypeEd25519)
+	if err != nil {
+		return err
+	}
+	peerCfg := HostConfig{
+		LocalOnly:   true,
+		DisableIPv6: cfg.DisableIPv6,
+		Security:    cfg.Security,
+		Muxers:      cfg.Muxers,
+		PSKFile:     cfg.PSKFile,
+	}
+	peerHost, _, err := setupHost(ctx, prvKey, peerCfg, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer peerHost.Close()
+
+	if err := peerHost.Connect(ctx, peer.AddrInfo{ID: nodeHost.ID(), Addrs: nodeHost.Addrs()}); err != nil {
+		return fmt.Errorf("could not connect local hosts: %w", err)
+	}
+
+	topicName := fmt.Sprintf("room-peerchat-selftest-%s", nodeHost.ID().Pretty())
+	nodeTopic, err := joinTestTopic(ctx, nodeHost, topicName)
+	if err != nil {
+		return err
+	}
+	defer nodeTopic.Close()
+	sub, err := nodeTopic.Subscribe()
+	if err != nil {
+		return err
+	}
+	defer sub.Cancel()
+
+	peerTopic, err := joinTestTopic(ctx, peerHost, topicName)
+	if err != nil {
+		return err
+	}
+	defer peerTopic.Close()
+
+	// Wait for the hosts to see each other on the topic before publishing
+	if err := waitUntil(ctx, selfTestTimeout, func() bool {
+		return len(peerTopic.ListPeers()) > 0
+	}); err != nil {
+		return errors.New("hosts did not join a common topic mesh")
+	}
+
+	payload := []byte("peernet self-test")
+	if err := peerTopic.Publish(ctx, payload); err != nil {
+		return err
+	}
+
+	msg, err := sub.Next(ctx)
+	if err != nil {
+		return fmt.Errorf("message was not received: %w", err)
+	}
+	if msg.ReceivedFrom != peerHost.ID() || string(msg.Data) != string(payload) {
+		return errors.New("received an unexpected message")
+	}
+	return nil
+}
+
+// joinTestTopic creates a GossipSub router on nodeHost and joins the named topic.
+func joinTestTopic(ctx context.Context, nodeHost host.Host, topicName string) (*pubsub.Topic, error) {
+	ps, err := pubsub.NewGossipSub(ctx, nodeHost)
+	if err != nil {
+		return nil, err
+	}
+	return ps.Join(topicName)
+}
+
+// awaitReachability waits until AutoNAT has determined whether the host is publicly reachable.
+func awaitReachability(ctx context.Context, nodeHost host.Host, timeout time.Duration) (network.Reachability, error) {
+	sub, err := nodeHost.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		return network.ReachabilityUnknown, err
+	}
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return network.ReachabilityUnknown, errors.New("reachability could not be determined in time")
+		case evt := <-sub.Out():
+			reachability := evt.(event.EvtLocalReachabilityChanged).Reachability
+			if reachability != network.ReachabilityUnknown {
+				return reachability, nil
+			}
+		}
+	}
+}
+
+// waitUntil polls cond until it returns true or the timeout elapses.
+func waitUntil(ctx context.Context, timeout time.Duration, cond func() bool) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for !cond() {
+		select {
+		case <-ctx.Done():
+			return errors.New("timed out")
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// exportEntry is a message written by /export.
+type exportEntry struct {
+	Time    time.Time `json:"time"`
+	Sender  string    `json:"sender"`
+	Message string    `json:"message"`
+	Action  bool      `json:"action,omitempty"` // A /me action rather than a plain message
+}
+
+// cmdExport saves the messages shown in the message box to a file. A directory is
+// given a generated file name, and existing files are never overwritten.
+func (ui *UI) cmdExport(arg string) {
+	if arg == "" {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: "usage: /export <path>"})
+		return
+	}
+
+	type snapshot struct {
+		room    string
+		entries []exportEntry
+	}
+	snapshots := make(chan snapshot, 1)
+	ui.App.QueueUpdateDraw(func() {
+		var entries []exportEntry
+		for _, line := range ui.active.transcript {
+			if line.entry != nil {
+				entries = append(entries, *line.entry)
+			}
+		}
+		snapshots <- snapshot{room: ui.active.name, entries: entries}
+	})
+
+	go func() {
+		var snap snapshot
+		select {
+		case snap = <-snapshots:
+		case <-ui.done:
+			return
+		}
+		if len(snap.entries) == 0 {
+			ui.OnLog(ChatLog{Prefix: "info", Msg: "no messages to export"})
+			return
+		}
+		path, err := exportMessages(arg, snap.room, snap.entries)
+		if err != nil {
+			ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("could not export messages: %s", err)})
+			return
+		}
+		ui.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("exported %d messages to %s", len(snap.entries), path)})
+	}()
+}
+
+// exportMessages writes messages to a new file at path and returns the path written.
+// If path is a directory, the file is created in it with a name derived from the room
+// and the current time. Messages are written as JSON if the path ends in ".json", and
+// as plain text lines otherwise.
+func exportMessages(path, room string, entries []exportEntry) (string, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		name := fmt.Sprintf("%s-%s-%s.txt", SERVICE, room, time.Now().Format("20060102-150405"))
+		path = filepath.Join(path, strings.ReplaceAll(name, string(filepath.Separator), "_"))
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		return "", fmt.Errorf("%s already exists", path)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	w := bufio.NewWriter(file)
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(entries)
+	} else {
+		for _, entry := range entries {
+			stamp := entry.Time.Local().Format("2006-01-02 15:04:05")
+			if entry.Action {
+				fmt.Fprintf(w, "%s * %s %s\n", stamp, entry.Sender, entry.Message)
+			} else {
+				fmt.Fprintf(w, "%s <%s> %s\n", stamp, entry.Sender, entry.Message)
+			}
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,354 @@
+package pkg
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+// FileTransferProtocol is the stream protocol used to send a file to a single peer.
+const FileTransferProtocol = "/peernet/file/1.0.0"
+
+// DefaultDownloadDir returns the directory received files are saved to when none is
+// configured: a peernet directory in $XDG_DOWNLOAD_DIR if set, or else in the XDG data
+// directory, $XDG_DATA_HOME or ~/.local/share. Without a home directory, files are
+// saved to a downloads directory in the working directory.
+func DefaultDownloadDir() string {
+	if dir := os.Getenv("XDG_DOWNLOAD_DIR"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, SERVICE)
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, SERVICE, "downloads")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".local", "share", SERVICE, "downloads")
+	}
+	return "downloads"
+}
+
+// maxDownloadSuffix bounds the numeric suffixes tried to avoid overwriting an existing file.
+const maxDownloadSuffix = 1000
+
+// MaxFileSize is the largest file that can be sent or received, in bytes.
+const MaxFileSize = 16 << 20
+
+// maxFileHeaderSize bounds the size of the header preceding a file's contents.
+const maxFileHeaderSize = 4 << 10
+
+// fileTransferTimeout bounds how long sending or receiving a single file may take.
+const fileTransferTimeout = 5 * time.Minute
+
+// Limits on the files received from a single peer.
+const (
+	maxConcurrentFilesPerPeer = 2               // Files received from a peer at the same time
+	maxReceivedBytesPerPeer   = 4 * MaxFileSize // Total size of the files received from a peer per session
+)
+
+// fileHeader describes the file that follows it on a file transfer stream.
+// On the wire it is preceded by its length as a big-endian uint32.
+type fileHeader struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// ReceivedFile describes a file received from a peer and saved to the download directory.
+type ReceivedFile struct {
+	From peer.ID // Peer that sent the file
+	Name string  // File name given by the sender
+	Path string  // Location the file was saved to
+	Size int64   // File size in bytes
+}
+
+// handleFileStream receives a single file from an inbound stream, saves it to the
+// download directory and delivers a notice to the FileInbound channel. Only peers in a
+// joined room may send files, within the per-peer limits of fileQuota. Partially
+// received files are discarded.
+func (p *PeerNetwork) handleFileStream(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(fileTransferTimeout))
+	from := stream.Conn().RemotePeer()
+
+	if !p.inJoinedRoom(from) {
+		logrus.Debugf("Refused file from %s, which is in none of the joined rooms", from)
+		stream.Reset()
+		return
+	}
+	if !p.fileQuota.start(from) {
+		logrus.Debugf("Refused file from %s, which is already sending %d files", from, maxConcurrentFilesPerPeer)
+		stream.Reset()
+		return
+	}
+	defer p.fileQuota.done(from)
+	defer p.protectTransfer(from)()
+
+	received, err := p.receiveFile(from, stream)
+	if err != nil {
+		logrus.Debugf("Failed to receive file from %s: %v", from, err)
+		stream.Reset()
+		return
+	}
+	received.From = from
+
+	select {
+	case p.FileInbound <- received:
+	case <-p.Ctx.Done():
+	}
+}
+
+// receiveFile reads a file header and contents from the stream into the download
+// directory, counting the file against the sender's quota unless it is discarded.
+func (p *PeerNetwork) receiveFile(from peer.ID, stream io.Reader) (received ReceivedFile, err error) {
+	var headerSize uint32
+	if err := binary.Read(stream, binary.BigEndian, &headerSize); err != nil {
+		return ReceivedFile{}, err
+	}
+	if headerSize > maxFileHeaderSize {
+		return ReceivedFile{}, fmt.Errorf("file header of %d bytes exceeds the limit", headerSize)
+	}
+
+	headerBytes := make([]byte, headerSize)
+	if _, err := io.ReadFull(stream, headerBytes); err != nil {
+		return ReceivedFile{}, err
+	}
+	var header fileHeader
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return ReceivedFile{}, fmt.Errorf("invalid file header: %w", err)
+	}
+	if header.Size < 0 || header.Size > MaxFileSize {
+		return ReceivedFile{}, fmt.Errorf("file size %d exceeds the %d byte limit", header.Size, MaxFileSize)
+	}
+	name, err := downloadName(header.Name)
+	if err != nil {
+		return ReceivedFile{}, err
+	}
+	if err := p.fileQuota.reserve(from, header.Size); err != nil {
+		return ReceivedFile{}, err
+	}
+	defer func() {
+		if err != nil {
+			p.fileQuota.refund(from, header.Size)
+		}
+	}()
+
+	// Write to a temporary file first so interrupted transfers leave nothing behind
+	if err := os.MkdirAll(p.downloadDir, 0700); err != nil {
+		return ReceivedFile{}, err
+	}
+	tmp, err := os.CreateTemp(p.downloadDir, ".peernet-*")
+	if err != nil {
+		return ReceivedFile{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.CopyN(tmp, stream, header.Size); err != nil {
+		tmp.Close()
+		return ReceivedFile{}, fmt.Errorf("incomplete transfer of %s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return ReceivedFile{}, err
+	}
+
+	path, err := saveDownload(tmp.Name(), p.downloadDir, name)
+	if err != nil {
+		return ReceivedFile{}, err
+	}
+	return ReceivedFile{Name: name, Path: path, Size: header.Size}, nil
+}
+
+// fileQuota limits the files received from each peer: how many are received at the
+// same time, and their total size over the session.
+type fileQuota struct {
+	mu       sync.Mutex
+	active   map[peer.ID]int   // Files being received from each peer
+	received map[peer.ID]int64 // Bytes received or being received from each peer
+}
+
+// newFileQuota creates an empty fileQuota.
+func newFileQuota() *fileQuota {
+	return &fileQuota{
+		active:   make(map[peer.ID]int),
+		received: make(map[peer.ID]int64),
+	}
+}
+
+// start records a file transfer from a peer, reporting false if the peer is already
+// sending maxConcurrentFilesPerPeer files. Every successful start must be followed by done.
+func (q *fileQuota) start(id peer.ID) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.active[id] >= maxConcurrentFilesPerPeer {
+		return false
+	}
+	q.active[id]++
+	return true
+}
+
+// done records the end of a file transfer from a peer.
+func (q *fileQuota) done(id peer.ID) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.active[id]--; q.active[id] <= 0 {
+		delete(q.active, id)
+	}
+}
+
+// reserve counts a file of the given size against a peer's total, returning an error
+// if it would exceed maxReceivedBytesPerPeer.
+func (q *fileQuota) reserve(id peer.ID, size int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.received[id]+size > maxReceivedBytesPerPeer {
+		return fmt.Errorf("file of %d bytes exceeds the %d bytes a peer may send per session", size, maxReceivedBytesPerPeer)
+	}
+	q.received[id] += size
+	return nil
+}
+
+// refund returns the size of a discarded file to a peer's total.
+func (q *fileQuota) refund(id peer.ID, size int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.received[id] -= size
+}
+
+// downloadName turns a file name given by a sender into a safe name in the download
+// directory. Directory components are stripped, whichever separator the sender's
+// platform uses, and names that would refer to a directory are rejected.
+func downloadName(senderName string) (string, error) {
+	name := senderName
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = sanitizeText(name)
+	if name == "" || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid file name %q", senderName)
+	}
+	return name, nil
+}
+
+// saveDownload moves a received file into the download directory under the given
+// name. If a file of that name exists, a numeric suffix is added before the
+// extension, e.g. "notes (1).txt", rather than overwriting it. It returns the path
+// the file was saved to.
+func saveDownload(tmpPath, dir, name string) (string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; i <= maxDownloadSuffix; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+		path := filepath.Join(dir, candidate)
+
+		// Claim the name exclusively before moving the file over the placeholder,
+		// so a file saved concurrently under the same name is never replaced
+		placeholder, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		placeholder.Close()
+		if err := os.Rename(tmpPath, path); err != nil {
+			os.Remove(path)
+			return "", err
+		}
+		return path, nil
+	}
+	return "", fmt.Errorf("too many files named %s in %s", name, dir)
+}
+
+// SendFile sends the file at path to a single peer over a dedicated stream.
+func (p *PeerNetwork) SendFile(to peer.ID, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	if info.Size() > MaxFileSize {
+		return fmt.Errorf("file size %d exceeds the %d byte limit", info.Size(), MaxFileSize)
+	}
+
+	header, err := json.Marshal(fileHeader{Name: filepath.Base(path), Size: info.Size()})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(p.Ctx, fileTransferTimeout)
+	defer cancel()
+	defer p.protectTransfer(to)()
+
+	stream, err := p.Host.NewStream(ctx, to, FileTransferProtocol)
+	if err != nil {
+		return err
+	}
+	stream.SetDeadline(time.Now().Add(fileTransferTimeout))
+
+	// Write the length-prefixed header followed by the file contents
+	if err := binary.Write(stream, binary.BigEndian, uint32(len(header))); err != nil {
+		stream.Reset()
+		return err
+	}
+	if _, err := stream.Write(header); err != nil {
+		stream.Reset()
+		return err
+	}
+	if _, err := io.CopyN(stream, file, info.Size()); err != nil {
+		stream.Reset()
+		return err
+	}
+	return stream.Close()
+}
+
+// SendFile sends the file at path to every peer in the room concurrently and
+// returns the number of peers that received it.
+func (cr *ChatRoom) SendFile(path string) (int, error) {
+	peers := cr.PeerList()
+	if len(peers) == 0 {
+		return 0, errors.New("no peers in the room")
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var errs []error
+	sent := 0
+	for _, id := range peers {
+		wg.Add(1)
+		go func(id peer.ID) {
+			defer wg.Done()
+			err := cr.Host.SendFile(id, path)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", cr.displayName(id), err))
+				return
+			}
+			sent++
+		}(id)
+	}
+	wg.Wait()
+	return sent, errors.Join(errs...)
+}
@@ -0,0 +1,65 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestFileQuotaConcurrency(t *testing.T) {
+	q := newFileQuota()
+	alice, bob := peer.ID("alice"), peer.ID("bob")
+	for i := 0; i < maxConcurrentFilesPerPeer; i++ {
+		if !q.start(alice) {
+			t.Fatalf("transfer %d was refused", i+1)
+		}
+	}
+	if q.start(alice) {
+		t.Fatal("transfer beyond the concurrency limit was accepted")
+	}
+	if !q.start(bob) {
+		t.Fatal("another peer's transfer was refused")
+	}
+	q.done(alice)
+	if !q.start(alice) {
+		t.Fatal("transfer was refused after another one finished")
+	}
+}
+
+func TestFileQuotaSize(t *testing.T) {
+	q := newFileQuota()
+	alice := peer.ID("alice")
+	for i := int64(0); i < maxReceivedBytesPerPeer/MaxFileSize; i++ {
+		if err := q.reserve(alice, MaxFileSize); err != nil {
+			t.Fatalf("file %d was refused: %v", i+1, err)
+		}
+	}
+	if err := q.reserve(alice, 1); err == nil {
+		t.Fatal("file beyond the size limit was accepted")
+	}
+	q.refund(alice, MaxFileSize)
+	if err := q.reserve(alice, MaxFileSize); err != nil {
+		t.Fatalf("file was refused after a refund: %v", err)
+	}
+}
+
+func TestDownloadName(t *testing.T) {
+	tests := []struct {
+		sender, want string
+	}{
+		{"notes.txt", "notes.txt"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\Users\me\notes.txt`, "notes.txt"},
+	}
+	for _, tt := range tests {
+		got, err := downloadName(tt.sender)
+		if err != nil || got != tt.want {
+			t.Errorf("downloadName(%q) = %q, %v, want %q", tt.sender, got, err, tt.want)
+		}
+	}
+	for _, name := range []string{"", "dir/", "..", "a/.."} {
+		if _, err := downloadName(name); err == nil {
+			t.Errorf("downloadName(%q) accepted an invalid name", name)
+		}
+	}
+}
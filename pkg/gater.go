@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/control"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// peerGater is a connection gater refusing connections to and from blocked peers and,
+// when an allowlist is configured, from any peer not on it. The blocklist can be
+// extended at runtime.
+type peerGater struct {
+	mu      sync.RWMutex
+	blocked map[peer.ID]struct{} // Peers that are always refused
+	allowed map[peer.ID]struct{} // Peers that are accepted, nil to accept everyone not blocked
+}
+
+// newPeerGater creates a peerGater from peer ID strings.
+func newPeerGater(blocked, allowed []string) (*peerGater, error) {
+	g := &peerGater{blocked: make(map[peer.ID]struct{})}
+
+	for _, s := range blocked {
+		id, err := peer.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blocked peer ID %q: %w", s, err)
+		}
+		g.blocked[id] = struct{}{}
+	}
+
+	if len(allowed) > 0 {
+		g.allowed = make(map[peer.ID]struct{})
+		for _, s := range allowed {
+			id, err := peer.Decode(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowed peer ID %q: %w", s, err)
+			}
+			g.allowed[id] = struct{}{}
+		}
+	}
+	return g, nil
+}
+
+// Block adds a peer to the blocklist.
+func (g *peerGater) Block(id peer.ID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.blocked[id] = struct{}{}
+}
+
+// Unblock removes a peer from the blocklist, reporting whether it was blocked.
+func (g *peerGater) Unblock(id peer.ID) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, blocked := g.blocked[id]
+	delete(g.blocked, id)
+	return blocked
+}
+
+// permitted reports whether connections with the peer are allowed.
+func (g *peerGater) permitted(id peer.ID) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if _, ok := g.blocked[id]; ok {
+		return false
+	}
+	if g.allowed != nil {
+		_, ok := g.allowed[id]
+		return ok
+	}
+	return true
+}
+
+func (g *peerGater) InterceptPeerDial(id peer.ID) bool {
+	return g.permitted(id)
+}
+
+func (g *peerGater) InterceptAddrDial(id peer.ID, _ multiaddr.Multiaddr) bool {
+	return g.permitted(id)
+}
+
+// InterceptAccept allows every inbound connection since the remote peer is not known yet.
+func (g *peerGater) InterceptAccept(network.ConnMultiaddrs) bool {
+	return true
+}
+
+func (g *peerGater) InterceptSecured(_ network.Direction, id peer.ID, _ network.ConnMultiaddrs) bool {
+	return g.permitted(id)
+}
+
+func (g *peerGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
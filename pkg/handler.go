@@ -0,0 +1,50 @@
+package pkg
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Handler receives chat room events. Methods are called from the room's own
+// goroutines, so implementations must be safe for concurrent use and should
+// return promptly since they hold up the loop that produced the event.
+type Handler interface {
+	OnMessage(msg ChatMessage) // A chat message or reaction was received from another peer
+	OnLog(log ChatLog)         // The room reported a status or error message
+	OnPeerJoin(id peer.ID)     // A peer subscribed to the room topic
+	OnPeerLeave(id peer.ID)    // A peer left the room topic
+}
+
+// WithHandler routes room events to the given handler instead of the Inbound and
+// Logs channels, which then stay idle. A handler that also wants the channels
+// populated can forward to them itself.
+func WithHandler(h Handler) RoomOption {
+	return func(cr *ChatRoom) {
+		cr.handler = h
+	}
+}
+
+// channelHandler is the default Handler, delivering events to the room's channels.
+// Peer joins and leaves are reported as log messages. Full channels are handled by the
+// room's overflow policy, and once the room is left, events are dropped instead of
+// blocking on channels nobody reads anymore.
+type channelHandler struct {
+	cr *ChatRoom
+}
+
+func (h channelHandler) OnMessage(msg ChatMessage) {
+	offer(h.cr.Inbound, msg, h.cr.overflow, h.cr.psCtx.Done())
+}
+
+func (h channelHandler) OnLog(log ChatLog) {
+	offer(h.cr.Logs, log, h.cr.overflow, h.cr.psCtx.Done())
+}
+
+func (h channelHandler) OnPeerJoin(id peer.ID) {
+	h.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("%s joined", h.cr.displayName(id))})
+}
+
+func (h channelHandler) OnPeerLeave(id peer.ID) {
+	h.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("%s left", h.cr.displayName(id))})
+}
@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// testTimeout bounds how long the in-process tests wait for the network.
+const testTimeout = 10 * time.Second
+
+// newTestPeerNetwork creates a host for in-process tests. It listens on loopback only
+// and never dials the public bootstrap peers, so tests do not depend on the network.
+func newTestPeerNetwork(t *testing.T) *PeerNetwork {
+	t.Helper()
+	return newTestPeerNetworkWith(t, HostConfig{})
+}
+
+// newTestPeerNetworkWith creates a host for in-process tests like newTestPeerNetwork,
+// with the settings of cfg not decided by the test harness.
+func newTestPeerNetworkWith(t *testing.T, cfg HostConfig) *PeerNetwork {
+	t.Helper()
+	cfg.KeyType = KeyTypeEd25519
+	cfg.LocalOnly = true
+	cfg.DisableIPv6 = true
+	if cfg.DownloadDir == "" {
+		cfg.DownloadDir = t.TempDir()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err := NewP2P(ctx, cfg)
+	if err != nil {
+		cancel()
+		t.Fatalf("NewP2P: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		p.Close()
+	})
+	return p
+}
+
+// newTestNetwork creates n hosts for in-process tests, each connected to all others
+// by dialing them directly.
+func newTestNetwork(t *testing.T, n int) []*PeerNetwork {
+	t.Helper()
+	nodes := make([]*PeerNetwork, n)
+	for i := range nodes {
+		nodes[i] = newTestPeerNetwork(t)
+	}
+	for i, a := range nodes {
+		for _, b := range nodes[i+1:] {
+			connect(t, a, b)
+		}
+	}
+	return nodes
+}
+
+// joinTestRoom joins every host to a room and waits until messages published from
+// then on reach everyone.
+func joinTestRoom(t *testing.T, nodes []*PeerNetwork, roomName string, opts ...RoomOption) []*ChatRoom {
+	t.Helper()
+	rooms := make([]*ChatRoom, len(nodes))
+	for i, p := range nodes {
+		cr, err := JoinChatRoom(p, "user", roomName, opts...)
+		if err != nil {
+			t.Fatalf("JoinChatRoom: %v", err)
+		}
+		t.Cleanup(cr.Exit)
+		rooms[i] = cr
+	}
+
+	deadline := time.Now().Add(testTimeout)
+	for _, cr := range rooms {
+		for len(cr.PeerList()) < len(nodes)-1 {
+			if time.Now().After(deadline) {
+				t.Fatalf("room '%s' sees %d of %d peers", cr.RoomName, len(cr.PeerList()), len(nodes)-1)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	// GossipSub only publishes to the room mesh, which the next heartbeat builds from
+	// the peers seen in the room
+	time.Sleep(2 * pubsub.GossipSubHeartbeatInterval)
+	return rooms
+}
+
+// receive waits for the next message of a room.
+func receive(t *testing.T, cr *ChatRoom) ChatMessage {
+	t.Helper()
+	select {
+	case msg, ok := <-cr.Inbound:
+		if !ok {
+			t.Fatal("Inbound was closed")
+		}
+		return msg
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for a message")
+	}
+	return ChatMessage{}
+}
+
+// connect dials a host from another one.
+func connect(t *testing.T, from, to *PeerNetwork) {
+	t.Helper()
+	info := peer.AddrInfo{ID: to.Host.ID(), Addrs: to.Host.Addrs()}
+	if err := from.Host.Connect(context.Background(), info); err != nil {
+		t.Fatalf("connecting %s to %s: %v", from.Host.ID(), to.Host.ID(), err)
+	}
+}
+
+func TestRoomMessageReachesAllPeers(t *testing.T) {
+	rooms := joinTestRoom(t, newTestNetwork(t, 3), "harness")
+	rooms[0].Outbound <- "hello"
+	for _, cr := range rooms[1:] {
+		msg := receive(t, cr)
+		if msg.Message != "hello" || msg.SenderID != rooms[0].selfID.Pretty() {
+			t.Errorf("got %q from %s, want %q from %s", msg.Message, msg.SenderID, "hello", rooms[0].selfID.Pretty())
+		}
+	}
+}
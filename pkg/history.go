@@ -0,0 +1,155 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultHistoryLines is the default number of history lines replayed when joining a room.
+const DefaultHistoryLines = 100
+
+// maxHistoryLineSize bounds the length of a single line read back from a history file.
+const maxHistoryLineSize = 1 << 20
+
+// chatHistory is an append-only JSON lines log of a room's messages.
+type chatHistory struct {
+	mu   sync.Mutex // Serializes writes from the publish and subscribe loops
+	file *os.File
+}
+
+// WithHistory persists the room's messages to a JSON lines file in dir and replays
+// up to limit of the most recent messages when the room is joined.
+func WithHistory(dir string, limit int) RoomOption {
+	return func(cr *ChatRoom) {
+		cr.historyDir = dir
+		cr.historyLimit = limit
+	}
+}
+
+// historyPath returns the history file path for a room.
+func historyPath(dir, roomName string) string {
+	return filepath.Join(dir, url.PathEscape(roomName)+".jsonl")
+}
+
+// openHistory opens the room's history file for appending, creating it if needed.
+func openHistory(path string) (*chatHistory, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &chatHistory{file: file}, nil
+}
+
+// Append writes a message to the end of the history file.
+func (h *chatHistory) Append(msg ChatMessage) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file == nil {
+		return nil
+	}
+	_, err = h.file.Write(append(line, '\n'))
+	return err
+}
+
+// Purge empties the history file and returns the number of messages it held.
+func (h *chatHistory) Purge() (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file == nil {
+		return 0, errors.New("history is closed")
+	}
+
+	msgs, err := loadHistory(h.file.Name(), 0)
+	if err != nil {
+		return 0, err
+	}
+	// Appends keep going to the end of the file, which is now its start
+	if err := h.file.Truncate(0); err != nil {
+		return 0, err
+	}
+	return len(msgs), nil
+}
+
+// Close closes the history file. Later appends are ignored.
+func (h *chatHistory) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file == nil {
+		return nil
+	}
+	err := h.file.Close()
+	h.file = nil
+	return err
+}
+
+// loadHistory reads up to limit of the most recent messages from a history file.
+// A missing file yields no messages; malformed lines are skipped.
+func loadHistory(path string, limit int) ([]ChatMessage, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var msgs []ChatMessage
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxHistoryLineSize)
+	for scanner.Scan() {
+		var msg ChatMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		msgs = append(msgs, msg)
+		if limit > 0 && len(msgs) > limit {
+			msgs = msgs[1:]
+		}
+	}
+	return msgs, scanner.Err()
+}
+
+// recordHistory appends messages to the room's history, if enabled.
+func (cr *ChatRoom) recordHistory(msgs ...ChatMessage) {
+	if cr.history == nil {
+		return
+	}
+	for _, msg := range msgs {
+		if msg.Type != MessageTypeChat && msg.Type != MessageTypeAction {
+			continue
+		}
+		if err := cr.history.Append(msg); err != nil {
+			cr.handler.OnLog(ChatLog{Prefix: "error", Msg: "failed to write chat history"})
+			return
+		}
+	}
+}
+
+// ClearHistory deletes the messages stored in the room's history and returns how many
+// were deleted. Messages received afterwards are recorded as before.
+func (cr *ChatRoom) ClearHistory() (int, error) {
+	if cr.history == nil {
+		return 0, errors.New("history is not enabled, see -history-dir")
+	}
+	return cr.history.Purge()
+}
+
+// Backlog returns the messages replayed from the room's history when it was joined.
+func (cr *ChatRoom) Backlog() []ChatMessage {
+	return cr.backlog
+}
@@ -1,63 +1,196 @@
 package pkg
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/libp2p/go-libp2p"
+	circuit "github.com/libp2p/go-libp2p-circuit"
 	connmgr "github.com/libp2p/go-libp2p-connmgr"
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/host"
+	coremetrics "github.com/libp2p/go-libp2p-core/metrics"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/pnet"
 	"github.com/libp2p/go-libp2p-core/routing"
 	discovery "github.com/libp2p/go-libp2p-discovery"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
+	mplex "github.com/libp2p/go-libp2p-mplex"
+	noise "github.com/libp2p/go-libp2p-noise"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	tls "github.com/libp2p/go-libp2p-tls"
 	yamux "github.com/libp2p/go-libp2p-yamux"
 	"github.com/libp2p/go-tcp-transport"
 	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/sirupsen/logrus"
 )
 
+// pskLength is the size in bytes of a private network pre-shared key.
+const pskLength = 32
+
+// DefaultListenAddr and DefaultListenAddr6 are the multiaddrs the host listens on when
+// none are configured, the IPv6 one unless IPv6 is disabled.
+const (
+	DefaultListenAddr  = "/ip4/0.0.0.0/tcp/0"
+	DefaultListenAddr6 = "/ip6/::/tcp/0"
+)
+
+// LocalListenAddr and LocalListenAddr6 are the multiaddrs the host listens on in
+// local-only mode when none are configured, the IPv6 one unless IPv6 is disabled.
+const (
+	LocalListenAddr  = "/ip4/127.0.0.1/tcp/0"
+	LocalListenAddr6 = "/ip6/::1/tcp/0"
+)
+
+// Default connection manager limits.
+const (
+	DefaultConnLow   = 100
+	DefaultConnHigh  = 400
+	DefaultConnGrace = time.Minute
+)
+
+// Supported identity key types.
+const (
+	KeyTypeRSA     = "rsa"
+	KeyTypeEd25519 = "ed25519"
+)
+
+// Supported security transport modes.
+const (
+	SecurityTLS   = "tls"
+	SecurityNoise = "noise"
+	SecurityBoth  = "both"
+)
+
+// Supported stream muxers.
+const (
+	MuxerYamux = "yamux"
+	MuxerMplex = "mplex"
+)
+
+// generateIdentity generates a new PeerNetwork identity (cryptographic key pair) of the given type.
+// An empty key type defaults to RSA.
+func generateIdentity(keyType string) (crypto.PrivKey, error) {
+	var algorithm, bits int
+	switch keyType {
+	case "", KeyTypeRSA:
+		algorithm, bits = crypto.RSA, 2048
+	case KeyTypeEd25519:
+		algorithm, bits = crypto.Ed25519, -1
+	default:
+		return nil, fmt.Errorf("unsupported key type %q (expected %q or %q)", keyType, KeyTypeRSA, KeyTypeEd25519)
+	}
+
+	prvKey, _, err := crypto.GenerateKeyPairWithReader(algorithm, bits, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("Generated PeerNetwork Identity (%s).", prvKey.Type())
+	return prvKey, nil
+}
+
 // setupHost initializes and configures a libP2P host with various networking and security options,
 // including Kademlia DHT, GossipSub, NAT traversal, auto-relay, and connection management.
-func setupHost(ctx context.Context) (host.Host, *dht.IpfsDHT, error) {
-	// Generate PeerNetwork identity (cryptographic key pair)
-	prvKey, _, err := crypto.GenerateKeyPairWithReader(crypto.RSA, 2048, rand.Reader)
+// Connections are filtered through the gater when one is given, and traffic is counted
+// by the bandwidth reporter when one is given.
+func setupHost(ctx context.Context, prvKey crypto.PrivKey, cfg HostConfig, gater *peerGater, bandwidth coremetrics.Reporter) (host.Host, *dht.IpfsDHT, error) {
+	// Configure security, transport, and listener options
+	securityOpts, err := securityOptions(prvKey, cfg.Security)
 	if err != nil {
 		return nil, nil, err
 	}
-	logrus.Debugln("Generated PeerNetwork Identity.")
 
-	// Configure security, transport, and listener options
-	tlsTransport, err := tls.New(prvKey)
+	listenAddrs, err := cfg.listenAddrs()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	multiAddr, err := multiaddr.NewMultiaddr("/ip4/0.0.0.0/tcp/0")
+	bootstrapPeers, err := cfg.bootstrapPeers()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	connLow, connHigh, connGrace, err := cfg.connManagerLimits()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	relays, err := cfg.staticRelays()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	muxerOpts, err := muxerOptions(cfg.Muxers)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	opts := []libp2p.Option{
 		libp2p.Identity(prvKey),
-		libp2p.Security(tls.ID, tlsTransport),
-		libp2p.ListenAddrs(multiAddr),
+		libp2p.ChainOptions(securityOpts...),
+		libp2p.ListenAddrs(listenAddrs...),
 		libp2p.Transport(tcp.NewTCPTransport),
-		libp2p.Muxer("/yamux/1.0.0", yamux.DefaultTransport),
-		libp2p.ConnectionManager(connmgr.NewConnManager(100, 400, time.Minute)),
-		libp2p.NATPortMap(),
-		libp2p.EnableAutoRelay(),
+		libp2p.ChainOptions(muxerOpts...),
+		libp2p.ConnectionManager(connmgr.NewConnManager(connLow, connHigh, connGrace)),
+	}
+
+	// Traverse NATs, unless the host only talks to other local instances. The AutoNAT
+	// service dials peers back to tell them whether they are reachable, once this host
+	// is found to be publicly reachable itself.
+	if !cfg.LocalOnly {
+		opts = append(opts, libp2p.NATPortMap(), libp2p.EnableAutoRelay(), libp2p.EnableNATService())
+	}
+
+	// Use known relays instead of relays discovered through the DHT
+	if len(relays) > 0 {
+		opts = append(opts, libp2p.StaticRelays(relays))
+		logrus.Debugf("Configured %d static relays", len(relays))
+	}
+
+	// Relay traffic for peers that cannot reach each other directly
+	if cfg.RelayServer {
+		opts = append(opts, libp2p.EnableRelay(circuit.OptHop))
+		configureRelayLimits(cfg)
+		logrus.Debugln("Enabled the Circuit Relay Service.")
+	}
+
+	// Count the bytes sent and received
+	if bandwidth != nil {
+		opts = append(opts, libp2p.BandwidthReporter(bandwidth))
+	}
+
+	// Refuse connections with blocked peers
+	if gater != nil {
+		opts = append(opts, libp2p.ConnectionGater(gater))
+	}
+
+	// Restrict connections to peers holding the same pre-shared key
+	if cfg.PSKFile != "" {
+		psk, err := loadPSK(cfg.PSKFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, libp2p.PrivateNetwork(psk))
+		logrus.Debugln("Enabled Private Network Mode.")
 	}
 
-	// Add Kademlia DHT setup to libP2P options
+	// Add Kademlia DHT setup to libP2P options. Private networks only query the DHT and
+	// never serve it
+	dhtMode := dht.ModeServer
+	if cfg.PSKFile != "" {
+		dhtMode = dht.ModeClient
+	}
 	var kadDHT *dht.IpfsDHT
 	opts = append(opts, libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
-		kadDHT = setupKadDHT(ctx, h)
+		kadDHT = setupKadDHT(ctx, h, dhtMode, bootstrapPeers)
 		return kadDHT, nil
 	}))
 
@@ -70,9 +203,179 @@ func setupHost(ctx context.Context) (host.Host, *dht.IpfsDHT, error) {
 	return libHost, kadDHT, nil
 }
 
-// setupKadDHT initializes the Kademlia DHT in server mode with bootstrap peers.
-func setupKadDHT(ctx context.Context, nodeHost host.Host) *dht.IpfsDHT {
-	kadDHT, err := dht.New(ctx, nodeHost, dht.Mode(dht.ModeServer), dht.BootstrapPeers(dht.GetDefaultBootstrapPeerAddrInfos()...))
+// securityOptions builds the security transport options for the given mode. Transports are
+// listed in order of preference, so TLS is negotiated first when both are enabled.
+func securityOptions(prvKey crypto.PrivKey, mode string) ([]libp2p.Option, error) {
+	var opts []libp2p.Option
+
+	if mode == "" || mode == SecurityTLS || mode == SecurityBoth {
+		tlsTransport, err := tls.New(prvKey)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, libp2p.Security(tls.ID, tlsTransport))
+	}
+
+	if mode == SecurityNoise || mode == SecurityBoth {
+		noiseTransport, err := noise.New(prvKey)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, libp2p.Security(noise.ID, noiseTransport))
+	}
+
+	if len(opts) == 0 {
+		return nil, fmt.Errorf("unsupported security mode %q (expected %q, %q or %q)", mode, SecurityTLS, SecurityNoise, SecurityBoth)
+	}
+	return opts, nil
+}
+
+// muxerOptions builds the stream muxer options for the given muxer names. Muxers are
+// offered in the given order of preference, and yamux alone is used when none are given.
+// Two peers connect as long as they share at least one muxer.
+func muxerOptions(names []string) ([]libp2p.Option, error) {
+	if len(names) == 0 {
+		names = []string{MuxerYamux}
+	}
+
+	var opts []libp2p.Option
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("stream muxer %q is listed more than once", name)
+		}
+		seen[name] = true
+
+		switch name {
+		case MuxerYamux:
+			opts = append(opts, libp2p.Muxer("/yamux/1.0.0", yamux.DefaultTransport))
+		case MuxerMplex:
+			opts = append(opts, libp2p.Muxer("/mplex/6.7.0", mplex.DefaultTransport))
+		default:
+			return nil, fmt.Errorf("unsupported stream muxer %q (expected %q or %q)", name, MuxerYamux, MuxerMplex)
+		}
+	}
+	return opts, nil
+}
+
+// parseListenAddrs validates the given listen addresses. Every malformed address is
+// reported in the returned error.
+func parseListenAddrs(addrs []string) ([]multiaddr.Multiaddr, error) {
+	var listenAddrs []multiaddr.Multiaddr
+	var errs []error
+	for _, addr := range addrs {
+		multiAddr, err := multiaddr.NewMultiaddr(addr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: %w", addr, err))
+			continue
+		}
+		listenAddrs = append(listenAddrs, multiAddr)
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid listen addresses: %w", errors.Join(errs...))
+	}
+	return listenAddrs, nil
+}
+
+// listenAddrs parses the configured listen addresses. When none are configured, the
+// host listens on all IPv4 and IPv6 interfaces, or on loopback in local-only mode,
+// where every address must be a loopback address. A listen address that cannot be
+// bound, e.g. IPv6 on an IPv4-only machine, is skipped by the host as long as another
+// one works.
+func (cfg HostConfig) listenAddrs() ([]multiaddr.Multiaddr, error) {
+	addrs := cfg.ListenAddrs
+	if len(addrs) == 0 {
+		switch {
+		case cfg.LocalOnly && cfg.DisableIPv6:
+			addrs = []string{LocalListenAddr}
+		case cfg.LocalOnly:
+			addrs = []string{LocalListenAddr, LocalListenAddr6}
+		case cfg.DisableIPv6:
+			addrs = []string{DefaultListenAddr}
+		default:
+			addrs = []string{DefaultListenAddr, DefaultListenAddr6}
+		}
+	}
+	listenAddrs, err := parseListenAddrs(addrs)
+	if err != nil || !cfg.LocalOnly {
+		return listenAddrs, err
+	}
+	for _, addr := range listenAddrs {
+		if !manet.IsIPLoopback(addr) {
+			return nil, fmt.Errorf("listen address %s is not a loopback address, as required in local-only mode", addr)
+		}
+	}
+	return listenAddrs, nil
+}
+
+// bootstrapPeers parses the configured bootstrap peer multiaddrs, falling back to the
+// default IPFS bootstrap peers when none are given. Private networks and local-only
+// mode never use the defaults. Each address must include a /p2p/ component.
+func (cfg HostConfig) bootstrapPeers() ([]peer.AddrInfo, error) {
+	if len(cfg.BootstrapPeers) == 0 {
+		if cfg.PSKFile != "" || cfg.LocalOnly {
+			return nil, nil
+		}
+		return dht.GetDefaultBootstrapPeerAddrInfos(), nil
+	}
+
+	var bootstrapPeers []peer.AddrInfo
+	for _, addr := range cfg.BootstrapPeers {
+		multiAddr, err := multiaddr.NewMultiaddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap peer %q: %w", addr, err)
+		}
+		peerInfo, err := peer.AddrInfoFromP2pAddr(multiAddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap peer %q: %w", addr, err)
+		}
+		bootstrapPeers = append(bootstrapPeers, *peerInfo)
+	}
+	return bootstrapPeers, nil
+}
+
+// configureRelayLimits applies the relay service limits that were given, leaving the
+// circuit relay package defaults for unset values. The limits are package variables of
+// the circuit relay package, so they apply to every relay in the process; it is only
+// called when the relay service is enabled.
+func configureRelayLimits(cfg HostConfig) {
+	if cfg.RelayMaxCircuits > 0 {
+		circuit.HopStreamLimit = cfg.RelayMaxCircuits
+	}
+	if cfg.RelayConnectTimeout > 0 {
+		circuit.HopConnectTimeout = cfg.RelayConnectTimeout
+	}
+}
+
+// connManagerLimits returns the configured connection manager low-water mark, high-water
+// mark and grace period, substituting the defaults for unset values.
+func (cfg HostConfig) connManagerLimits() (int, int, time.Duration, error) {
+	low, high, grace := cfg.ConnLow, cfg.ConnHigh, cfg.ConnGrace
+	if low == 0 {
+		low = DefaultConnLow
+	}
+	if high == 0 {
+		high = DefaultConnHigh
+	}
+	if grace == 0 {
+		grace = DefaultConnGrace
+	}
+
+	switch {
+	case low < 0 || high < 0:
+		return 0, 0, 0, fmt.Errorf("connection limits must be positive (low %d, high %d)", low, high)
+	case low >= high:
+		return 0, 0, 0, fmt.Errorf("connection low-water mark %d must be below the high-water mark %d", low, high)
+	case grace < 0:
+		return 0, 0, 0, fmt.Errorf("connection grace period must not be negative, got %s", grace)
+	}
+	return low, high, grace, nil
+}
+
+// setupKadDHT initializes the Kademlia DHT in the given mode with bootstrap peers.
+func setupKadDHT(ctx context.Context, nodeHost host.Host, mode dht.ModeOpt, bootstrapPeers []peer.AddrInfo) *dht.IpfsDHT {
+	kadDHT, err := dht.New(ctx, nodeHost, dht.Mode(mode), dht.BootstrapPeers(bootstrapPeers...))
 	if err != nil {
 		logrus.WithError(err).Fatalln("Failed to create Kademlia DHT")
 	}
@@ -80,31 +383,119 @@ func setupKadDHT(ctx context.Context, nodeHost host.Host) *dht.IpfsDHT {
 }
 
 // setupPubSub initializes a GossipSub-based PubSub system using the given node host and routing discovery.
-func setupPubSub(ctx context.Context, nodeHost host.Host, discovery *discovery.RoutingDiscovery) (*pubsub.PubSub, error) {
-	pubSubHandler, err := pubsub.NewGossipSub(ctx, nodeHost, pubsub.WithDiscovery(discovery))
+// Messages are signed and unsigned ones are dropped unless the config relaxes signatures, and
+// peers are only scored when the config enables peer scoring.
+func setupPubSub(ctx context.Context, nodeHost host.Host, discovery *discovery.RoutingDiscovery, cfg HostConfig) (*pubsub.PubSub, error) {
+	opts := []pubsub.Option{pubsub.WithDiscovery(discovery)}
+	if cfg.LaxSignatures {
+		opts = append(opts, pubsub.WithMessageSignaturePolicy(pubsub.LaxSign))
+	}
+	if cfg.MessageIDFunc != nil {
+		opts = append(opts, pubsub.WithMessageIdFn(cfg.MessageIDFunc))
+	}
+	if cfg.PeerScoring {
+		opts = append(opts, pubsub.WithPeerScore(defaultPeerScoreParams(), defaultPeerScoreThresholds()))
+	}
+
+	pubSubHandler, err := pubsub.NewGossipSub(ctx, nodeHost, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return pubSubHandler, nil
 }
 
-// bootstrapDHT bootstraps the Kademlia DHT and connects the host to the default bootstrap peers.
-func bootstrapDHT(ctx context.Context, nodeHost host.Host, kadDHT *dht.IpfsDHT) error {
+// defaultPeerScoreParams returns the GossipSub peer scoring parameters used when peer scoring
+// is enabled. Rooms come and go at runtime, so only topic-independent behaviour is scored:
+// protocol misbehaviour such as broken promises and early re-grafts, and many peers sharing
+// an IP address, as is typical of a Sybil attack.
+func defaultPeerScoreParams() *pubsub.PeerScoreParams {
+	return &pubsub.PeerScoreParams{
+		Topics:                      make(map[string]*pubsub.TopicScoreParams),
+		AppSpecificScore:            func(peer.ID) float64 { return 0 },
+		IPColocationFactorWeight:    -100,
+		IPColocationFactorThreshold: 5,
+		BehaviourPenaltyWeight:      -10,
+		BehaviourPenaltyThreshold:   6,
+		BehaviourPenaltyDecay:       pubsub.ScoreParameterDecay(10 * time.Minute),
+		DecayInterval:               time.Second,
+		DecayToZero:                 0.01,
+		RetainScore:                 30 * time.Minute,
+	}
+}
+
+// defaultPeerScoreThresholds returns the score thresholds below which a peer stops receiving
+// gossip, stops being published to and is ignored altogether. Peer exchange is still
+// accepted from any peer with a non-negative score.
+func defaultPeerScoreThresholds() *pubsub.PeerScoreThresholds {
+	return &pubsub.PeerScoreThresholds{
+		GossipThreshold:   -500,
+		PublishThreshold:  -1000,
+		GraylistThreshold: -2500,
+	}
+}
+
+// loadPSK reads a 32-byte pre-shared key from a file, either as raw bytes or in the
+// standard "/key/swarm/psk/1.0.0/" swarm key format.
+func loadPSK(path string) (pnet.PSK, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading PSK file %s: %w", path, err)
+	}
+	if len(data) == pskLength {
+		return pnet.PSK(data), nil
+	}
+
+	psk, err := pnet.DecodeV1PSK(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("PSK file %s must hold %d raw bytes or a swarm key: %w", path, pskLength, err)
+	}
+	return psk, nil
+}
+
+// DefaultMinBootstrapPeers is the default number of bootstrap peers that must be reached at startup.
+const DefaultMinBootstrapPeers = 1
+
+// ErrBootstrapFailed is returned when too few bootstrap peers could be reached for
+// DHT discovery to work.
+var ErrBootstrapFailed = errors.New("too few bootstrap peers reachable")
+
+// bootstrapDHT bootstraps the Kademlia DHT and connects the host to the given bootstrap
+// peers, failing with ErrBootstrapFailed unless at least minimum of them connect. The
+// minimum is capped at the number of bootstrap peers.
+func bootstrapDHT(ctx context.Context, nodeHost host.Host, kadDHT *dht.IpfsDHT, bootstrapPeers []peer.AddrInfo, minimum int) error {
 	if err := kadDHT.Bootstrap(ctx); err != nil {
 		return err
 	}
 
+	if minimum > len(bootstrapPeers) {
+		minimum = len(bootstrapPeers)
+	}
+	connected := connectBootstrapPeers(ctx, nodeHost, bootstrapPeers)
+	if connected < minimum {
+		return fmt.Errorf("%w: connected to %d of %d, %d required; check your network or the -bootstrap peers", ErrBootstrapFailed, connected, len(bootstrapPeers), minimum)
+	}
+	logrus.Debugf("Connected to %d of %d bootstrap peers", connected, len(bootstrapPeers))
+	return nil
+}
+
+// connectBootstrapPeers concurrently dials the given bootstrap peers and returns
+// the number of successful connections.
+func connectBootstrapPeers(ctx context.Context, nodeHost host.Host, bootstrapPeers []peer.AddrInfo) int {
+	var connected int32
 	var wg sync.WaitGroup
-	for _, peerAddr := range dht.DefaultBootstrapPeers {
-		peerInfo, _ := peer.AddrInfoFromP2pAddr(peerAddr)
+	for _, peerInfo := range bootstrapPeers {
 		wg.Add(1)
 		go func(peerInfo peer.AddrInfo) {
 			defer wg.Done()
-			if err := nodeHost.Connect(ctx, peerInfo); err == nil {
-				logrus.Debugf("Connected to bootstrap peer: %s", peerInfo.ID)
+			if err := nodeHost.Connect(ctx, peerInfo); err != nil {
+				logrus.Debugf("Failed to connect to bootstrap peer %s: %v", peerInfo.ID, err)
+				return
 			}
-		}(*peerInfo)
+			atomic.AddInt32(&connected, 1)
+			nodeHost.ConnManager().Protect(peerInfo.ID, BootstrapTag)
+			logrus.Debugf("Connected to bootstrap peer: %s", peerInfo.ID)
+		}(peerInfo)
 	}
 	wg.Wait()
-	return nil
+	return int(connected)
 }
@@ -0,0 +1,38 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestMuxerNegotiation(t *testing.T) {
+	for _, tc := range []struct {
+		dialer, listener []string
+		connects         bool
+	}{
+		{[]string{MuxerYamux}, []string{MuxerYamux}, true},
+		{[]string{MuxerYamux}, []string{MuxerMplex, MuxerYamux}, true},
+		{[]string{MuxerYamux, MuxerMplex}, []string{MuxerMplex}, true},
+		{[]string{MuxerMplex}, []string{MuxerYamux}, false},
+	} {
+		a := newTestPeerNetworkWith(t, HostConfig{Muxers: tc.dialer})
+		b := newTestPeerNetworkWith(t, HostConfig{Muxers: tc.listener})
+		err := a.Host.Connect(context.Background(), peer.AddrInfo{ID: b.Host.ID(), Addrs: b.Host.Addrs()})
+		if connected := err == nil; connected != tc.connects {
+			t.Errorf("muxers %v dialing %v: connect error %v, want connected %t", tc.dialer, tc.listener, err, tc.connects)
+		}
+	}
+}
+
+func TestMuxerOptionsErrors(t *testing.T) {
+	for _, names := range [][]string{
+		{MuxerYamux, MuxerYamux},
+		{"spdy"},
+	} {
+		if _, err := muxerOptions(names); err == nil {
+			t.Errorf("muxerOptions(%v) succeeded, want an error", names)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/sirupsen/logrus"
+)
+
+// loadIdentity returns the identity stored at keyPath, or a freshly generated one of the
+// given key type when keyPath is empty.
+func loadIdentity(keyPath, keyType string) (crypto.PrivKey, error) {
+	if keyPath == "" {
+		return generateIdentity(keyType)
+	}
+	return loadOrCreateIdentity(keyPath, keyType)
+}
+
+// loadOrCreateIdentity loads the private key stored at keyPath. If the file does not exist,
+// a new identity of the given key type is generated and persisted there with owner-only permissions.
+// A file that exists but cannot be decoded is reported as an error rather than replaced.
+func loadOrCreateIdentity(keyPath, keyType string) (crypto.PrivKey, error) {
+	data, err := os.ReadFile(keyPath)
+	if err == nil {
+		prvKey, err := crypto.UnmarshalPrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("identity file %s is corrupt or truncated: %w", keyPath, err)
+		}
+		logrus.Debugf("Loaded PeerNetwork Identity from %s", keyPath)
+		return prvKey, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error reading identity file %s: %w", keyPath, err)
+	}
+
+	// Generate and persist a new identity
+	prvKey, err := generateIdentity(keyType)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveIdentity(keyPath, prvKey); err != nil {
+		return nil, err
+	}
+	logrus.Debugf("Saved PeerNetwork Identity to %s", keyPath)
+	return prvKey, nil
+}
+
+// saveIdentity writes the private key to keyPath with 0600 permissions, refusing to
+// overwrite an existing file.
+func saveIdentity(keyPath string, prvKey crypto.PrivKey) error {
+	data, err := crypto.MarshalPrivateKey(prvKey)
+	if err != nil {
+		return fmt.Errorf("error marshaling identity: %w", err)
+	}
+
+	file, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("error creating identity file %s: %w", keyPath, err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing identity file %s: %w", keyPath, err)
+	}
+	return file.Close()
+}
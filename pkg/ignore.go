@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ignoreList holds the peers whose messages are hidden in the UI for the rest of the
+// session, in every room. Ignored peers stay connected, unlike blocked ones.
+type ignoreList struct {
+	mu    sync.RWMutex
+	peers map[string]peer.ID // Pretty peer ID -> peer
+}
+
+// Add ignores a peer, reporting whether it was not ignored before.
+func (l *ignoreList) Add(id peer.ID) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.peers[id.Pretty()]; ok {
+		return false
+	}
+	if l.peers == nil {
+		l.peers = make(map[string]peer.ID)
+	}
+	l.peers[id.Pretty()] = id
+	return true
+}
+
+// Remove stops ignoring a peer, reporting whether it was ignored.
+func (l *ignoreList) Remove(id peer.ID) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.peers[id.Pretty()]; !ok {
+		return false
+	}
+	delete(l.peers, id.Pretty())
+	return true
+}
+
+// Contains reports whether the peer with the given pretty ID, as carried in
+// ChatMessage.SenderID, is ignored.
+func (l *ignoreList) Contains(senderID string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.peers[senderID]
+	return ok
+}
+
+// List returns the ignored peers.
+func (l *ignoreList) List() []peer.ID {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	ids := make([]peer.ID, 0, len(l.peers))
+	for _, id := range l.peers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// cmdIgnore hides the messages of a peer, given by username or ID, in every room.
+// Without an argument it lists the ignored peers.
+func (ui *UI) cmdIgnore(arg string) {
+	if arg == "" {
+		ui.showIgnored()
+		return
+	}
+	id, ok := ui.resolveIgnored(arg)
+	if !ok {
+		return
+	}
+	if !ui.ignored.Add(id) {
+		ui.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("%s is already ignored", ui.displayName(id))})
+		return
+	}
+	ui.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("ignoring messages from %s, use /unignore to show them again", ui.displayName(id))})
+}
+
+// cmdUnignore shows the messages of an ignored peer again.
+func (ui *UI) cmdUnignore(arg string) {
+	if arg == "" {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: "missing peer"})
+		return
+	}
+	id, ok := ui.resolveIgnored(arg)
+	if !ok {
+		return
+	}
+	if !ui.ignored.Remove(id) {
+		ui.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("%s is not ignored", ui.displayName(id))})
+		return
+	}
+	ui.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("no longer ignoring %s", ui.displayName(id))})
+}
+
+// resolveIgnored resolves the peer argument of /ignore and /unignore. Besides the
+// peers in the current room, ignored peers can be given by their short ID and any
+// peer by its full ID, so peers that have left can still be unignored.
+func (ui *UI) resolveIgnored(arg string) (peer.ID, bool) {
+	if id, err := ui.resolvePeer(arg); err == nil {
+		return id, true
+	}
+	for _, id := range ui.ignored.List() {
+		if shortID(id) == arg || strings.EqualFold(ui.displayName(id), arg) {
+			return id, true
+		}
+	}
+	id, err := peer.Decode(arg)
+	if err != nil {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("unknown peer %q", arg)})
+		return "", false
+	}
+	return id, true
+}
+
+// showIgnored lists the ignored peers in the message box.
+func (ui *UI) showIgnored() {
+	ids := ui.ignored.List()
+	if len(ids) == 0 {
+		ui.OnLog(ChatLog{Prefix: "info", Msg: "no peers are ignored"})
+		return
+	}
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		names = append(names, fmt.Sprintf("%s (%s)", ui.displayName(id), shortID(id)))
+	}
+	sort.Strings(names)
+	ui.OnLog(ChatLog{Prefix: "info", Msg: "ignored peers: " + strings.Join(names, ", ")})
+}
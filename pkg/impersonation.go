@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ImpersonationPolicy decides what happens to a message sent under a name that another
+// peer in the room already uses, e.g. an impersonator copying a trusted user's name.
+type ImpersonationPolicy int
+
+const (
+	// ImpersonationWarn delivers the message marked with the peer whose name it uses.
+	ImpersonationWarn ImpersonationPolicy = iota
+	// ImpersonationDrop drops the message and logs a warning.
+	ImpersonationDrop
+	// ImpersonationOff does not check names.
+	ImpersonationOff
+)
+
+// Impersonation policy names accepted by ParseImpersonationPolicy.
+const (
+	ImpersonationWarnName = "warn"
+	ImpersonationDropName = "drop"
+	ImpersonationOffName  = "off"
+)
+
+// ParseImpersonationPolicy returns the impersonation policy with the given name.
+func ParseImpersonationPolicy(name string) (ImpersonationPolicy, error) {
+	switch name {
+	case "", ImpersonationWarnName:
+		return ImpersonationWarn, nil
+	case ImpersonationDropName:
+		return ImpersonationDrop, nil
+	case ImpersonationOffName:
+		return ImpersonationOff, nil
+	default:
+		return 0, fmt.Errorf("unsupported impersonation policy %q (expected %q, %q or %q)", name, ImpersonationWarnName, ImpersonationDropName, ImpersonationOffName)
+	}
+}
+
+// WithImpersonationPolicy sets how the room handles messages sent under a name another
+// peer already uses. The default is ImpersonationWarn.
+func WithImpersonationPolicy(policy ImpersonationPolicy) RoomOption {
+	return func(cr *ChatRoom) {
+		cr.impersonation = policy
+	}
+}
+
+// checkImpersonation records the name a verified author sends under and returns the
+// peer whose name it copies, if any. A name belongs to the local user, or else to its
+// owner in the room's nameOwners. Names are compared ignoring case, as they are when
+// warning about collisions with the local name.
+func (cr *ChatRoom) checkImpersonation(author peer.ID, name string) (peer.ID, bool) {
+	name = sanitizeText(name)
+	if cr.impersonation == ImpersonationOff || name == "" {
+		return "", false
+	}
+	if author != cr.selfID && strings.EqualFold(name, cr.UserName()) {
+		return cr.selfID, true
+	}
+	return cr.names.claim(author, name)
+}
+
+// nameOwners tracks which peer each name in a room belongs to. A name belongs to the
+// first peer seen using it, for as long as that peer keeps it. Names are compared
+// ignoring case.
+type nameOwners struct {
+	mu     sync.Mutex
+	owners map[string]peer.ID // Lowercased names and the peers they belong to
+	names  map[peer.ID]string // Name each peer last sent under
+}
+
+func newNameOwners() *nameOwners {
+	return &nameOwners{
+		owners: make(map[string]peer.ID),
+		names:  make(map[peer.ID]string),
+	}
+}
+
+// claim records that a peer sent a message under a name. If the name belongs to
+// another peer, it returns that peer and true.
+func (n *nameOwners) claim(id peer.ID, name string) (peer.ID, bool) {
+	key := strings.ToLower(name)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.names[id] = name
+	if owner, ok := n.owners[key]; ok && owner != id && strings.EqualFold(n.names[owner], name) {
+		return owner, true
+	}
+	n.owners[key] = id
+	return "", false
+}
@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestNameOwnersClaim(t *testing.T) {
+	alice, mallory := peer.ID("alice"), peer.ID("mallory")
+	names := newNameOwners()
+
+	if _, impersonating := names.claim(alice, "alice"); impersonating {
+		t.Fatal("first use of a name was flagged")
+	}
+	if _, impersonating := names.claim(alice, "alice"); impersonating {
+		t.Fatal("owner of a name was flagged")
+	}
+	if owner, impersonating := names.claim(mallory, "Alice"); !impersonating || owner != alice {
+		t.Fatalf("copied name = %q, %v, want %q, true", owner, impersonating, alice)
+	}
+
+	// Once alice changes her name, the old one is free again
+	names.claim(alice, "alice2")
+	if _, impersonating := names.claim(mallory, "alice"); impersonating {
+		t.Fatal("released name was flagged")
+	}
+	if owner, impersonating := names.claim(alice, "alice"); !impersonating || owner != mallory {
+		t.Fatalf("reclaimed name = %q, %v, want %q, true", owner, impersonating, mallory)
+	}
+}
+
+// newNameRoom returns a room with just the state used to check peer names.
+func newNameRoom(policy ImpersonationPolicy) *ChatRoom {
+	return &ChatRoom{
+		userName:      "me",
+		selfID:        peer.ID("self"),
+		names:         newNameOwners(),
+		impersonation: policy,
+	}
+}
+
+func TestCheckImpersonationLocalName(t *testing.T) {
+	cr := newNameRoom(ImpersonationWarn)
+	if owner, impersonating := cr.checkImpersonation(peer.ID("mallory"), "Me"); !impersonating || owner != cr.selfID {
+		t.Fatalf("local name = %q, %v, want %q, true", owner, impersonating, cr.selfID)
+	}
+	if _, impersonating := cr.checkImpersonation(cr.selfID, "me"); impersonating {
+		t.Fatal("local user was flagged for its own name")
+	}
+}
+
+func TestCheckImpersonationOff(t *testing.T) {
+	cr := newNameRoom(ImpersonationOff)
+	cr.checkImpersonation(peer.ID("alice"), "alice")
+	if _, impersonating := cr.checkImpersonation(peer.ID("mallory"), "alice"); impersonating {
+		t.Fatal("copied name was flagged with the check disabled")
+	}
+	if _, impersonating := cr.checkImpersonation(peer.ID("mallory"), "me"); impersonating {
+		t.Fatal("local name was flagged with the check disabled")
+	}
+}
+
+func TestParseImpersonationPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		want ImpersonationPolicy
+	}{
+		{"", ImpersonationWarn},
+		{"warn", ImpersonationWarn},
+		{"drop", ImpersonationDrop},
+		{"off", ImpersonationOff},
+	}
+	for _, tt := range tests {
+		got, err := ParseImpersonationPolicy(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseImpersonationPolicy(%q) = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := ParseImpersonationPolicy("ban"); err == nil {
+		t.Error("ParseImpersonationPolicy accepted an unknown policy")
+	}
+}
@@ -0,0 +1,50 @@
+package pkg
+
+// maxInputHistory bounds the number of recalled input lines.
+const maxInputHistory = 100
+
+// inputHistory keeps recently submitted input lines for shell-like recall.
+// It is only accessed from the UI goroutine.
+type inputHistory struct {
+	entries []string // Submitted lines, oldest first
+	pos     int      // Index of the recalled entry, len(entries) when not navigating
+	draft   string   // In-progress line saved when navigation starts
+}
+
+// Add records a submitted line and resets navigation.
+func (h *inputHistory) Add(line string) {
+	if len(h.entries) == 0 || h.entries[len(h.entries)-1] != line {
+		h.entries = append(h.entries, line)
+		if len(h.entries) > maxInputHistory {
+			h.entries = h.entries[len(h.entries)-maxInputHistory:]
+		}
+	}
+	h.pos = len(h.entries)
+	h.draft = ""
+}
+
+// Prev returns the entry before the current position, saving the in-progress
+// line when navigation starts. It reports false when there is no older entry.
+func (h *inputHistory) Prev(current string) (string, bool) {
+	if h.pos == 0 {
+		return "", false
+	}
+	if h.pos == len(h.entries) {
+		h.draft = current
+	}
+	h.pos--
+	return h.entries[h.pos], true
+}
+
+// Next returns the entry after the current position, restoring the in-progress
+// line past the newest entry. It reports false when not navigating.
+func (h *inputHistory) Next() (string, bool) {
+	if h.pos >= len(h.entries) {
+		return "", false
+	}
+	h.pos++
+	if h.pos == len(h.entries) {
+		return h.draft, true
+	}
+	return h.entries[h.pos], true
+}
@@ -0,0 +1,193 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+// maxKnownPeers is the number of peers remembered in the peerstore file; the ones
+// seen least recently are dropped first.
+const maxKnownPeers = 100
+
+// knownPeerExpiry is how long a peer is remembered after it was last seen.
+const knownPeerExpiry = 30 * 24 * time.Hour
+
+// knownPeerEntry is a peer remembered in the peerstore file.
+type knownPeerEntry struct {
+	ID       string    `json:"id"`
+	Addrs    []string  `json:"addrs"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// knownPeers remembers the peers we chatted with across restarts so they can be dialed
+// again at startup, independently of discovery. All methods are safe for concurrent
+// use, and a nil *knownPeers remembers nothing.
+type knownPeers struct {
+	path string // File the peers are saved to
+
+	mu       sync.Mutex
+	lastSeen map[peer.ID]time.Time             // Time each peer last sent us a message
+	addrs    map[peer.ID][]multiaddr.Multiaddr // Addresses loaded from the file
+}
+
+// loadKnownPeers reads the peerstore file at path, which need not exist yet. Expired
+// and malformed entries are skipped.
+func loadKnownPeers(path string) (*knownPeers, error) {
+	k := &knownPeers{
+		path:     path,
+		lastSeen: make(map[peer.ID]time.Time),
+		addrs:    make(map[peer.ID][]multiaddr.Multiaddr),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading peerstore file %s: %w", path, err)
+	}
+	var entries []knownPeerEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid peerstore file %s: %w", path, err)
+	}
+
+	for _, entry := range entries {
+		if time.Since(entry.LastSeen) > knownPeerExpiry {
+			continue
+		}
+		id, err := peer.Decode(entry.ID)
+		if err != nil {
+			continue
+		}
+		var addrs []multiaddr.Multiaddr
+		for _, s := range entry.Addrs {
+			if addr, err := multiaddr.NewMultiaddr(s); err == nil {
+				addrs = append(addrs, addr)
+			}
+		}
+		if len(addrs) == 0 {
+			continue
+		}
+		k.lastSeen[id] = entry.LastSeen
+		k.addrs[id] = addrs
+	}
+	return k, nil
+}
+
+// Peers returns the remembered peers with their addresses.
+func (k *knownPeers) Peers() []peer.AddrInfo {
+	if k == nil {
+		return nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	peers := make([]peer.AddrInfo, 0, len(k.addrs))
+	for id, addrs := range k.addrs {
+		peers = append(peers, peer.AddrInfo{ID: id, Addrs: addrs})
+	}
+	return peers
+}
+
+// Seen records that a peer sent us a message just now.
+func (k *knownPeers) Seen(id peer.ID) {
+	if k == nil {
+		return
+	}
+	k.mu.Lock()
+	k.lastSeen[id] = time.Now()
+	k.mu.Unlock()
+}
+
+// Forget drops a peer, e.g. after it could not be reached.
+func (k *knownPeers) Forget(id peer.ID) {
+	if k == nil {
+		return
+	}
+	k.mu.Lock()
+	delete(k.lastSeen, id)
+	delete(k.addrs, id)
+	k.mu.Unlock()
+}
+
+// Save writes the most recently seen peers to the peerstore file, taking their
+// current addresses from the peerstore. Peers without addresses are left out.
+func (k *knownPeers) Save(ps peerstore.Peerstore) error {
+	if k == nil {
+		return nil
+	}
+	k.mu.Lock()
+	entries := make([]knownPeerEntry, 0, len(k.lastSeen))
+	for id, lastSeen := range k.lastSeen {
+		addrs := ps.Addrs(id)
+		if len(addrs) == 0 {
+			addrs = k.addrs[id]
+		}
+		if len(addrs) == 0 {
+			continue
+		}
+		entry := knownPeerEntry{ID: id.Pretty(), LastSeen: lastSeen}
+		for _, addr := range addrs {
+			entry.Addrs = append(entry.Addrs, addr.String())
+		}
+		entries = append(entries, entry)
+	}
+	k.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].LastSeen.After(entries[j].LastSeen) })
+	if len(entries) > maxKnownPeers {
+		entries = entries[:maxKnownPeers]
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Replace the file atomically so a crash never leaves it truncated
+	if err := os.MkdirAll(filepath.Dir(k.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(k.path), ".peerstore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), k.path)
+}
+
+// reconnectKnownPeers dials the peers remembered from previous sessions, forgetting
+// the ones that cannot be reached.
+func (p *PeerNetwork) reconnectKnownPeers() {
+	peers := p.knownPeers.Peers()
+	if len(peers) == 0 {
+		return
+	}
+	logrus.Debugf("Reconnecting to %d known peers", len(peers))
+	for _, peerInfo := range peers {
+		id := peerInfo.ID
+		p.dialer.DialThen(p.Ctx, peerInfo, func(err error) {
+			if err != nil && p.Ctx.Err() == nil {
+				logrus.Debugf("Forgetting unreachable known peer %s", id)
+				p.knownPeers.Forget(id)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package pkg
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+// LogHandler is a Handler that writes room events to the log, for running a room
+// without the chat UI.
+type LogHandler struct {
+	Room string // Room name attached to every entry
+}
+
+// OnMessage logs a chat message, action or reaction.
+func (h LogHandler) OnMessage(msg ChatMessage) {
+	entry := logrus.WithFields(logrus.Fields{"room": h.Room, "from": msg.SenderName, "peer": msg.SenderID, "id": msg.ID})
+	if msg.Impersonates != "" {
+		entry = entry.WithField("impersonates", msg.Impersonates.Pretty())
+	}
+	switch msg.Type {
+	case MessageTypeAction:
+		entry.Infof("* %s %s", msg.SenderName, msg.Message)
+	case MessageTypeReaction:
+		entry.WithField("target", msg.Target).Infof("reacted %s", msg.Message)
+	default:
+		entry.Info(msg.Message)
+	}
+}
+
+// OnLog logs a room status or error message.
+func (h LogHandler) OnLog(log ChatLog) {
+	logrus.WithFields(logrus.Fields{"room": h.Room, "prefix": log.Prefix}).Info(log.Msg)
+}
+
+// OnPeerJoin logs a peer joining the room.
+func (h LogHandler) OnPeerJoin(id peer.ID) {
+	logrus.WithFields(logrus.Fields{"room": h.Room, "peer": id.Pretty()}).Info("Peer joined")
+}
+
+// OnPeerLeave logs a peer leaving the room.
+func (h LogHandler) OnPeerLeave(id peer.ID) {
+	logrus.WithFields(logrus.Fields{"room": h.Room, "peer": id.Pretty()}).Info("Peer left")
+}
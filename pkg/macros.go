@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultMacros are the text macros available without a macros file. A macro is
+// typed like a command and sends its expansion, appended to any text after it.
+var DefaultMacros = map[string]string{
+	"/shrug":     `¯\_(ツ)_/¯`,
+	"/tableflip": "(╯°□°)╯︵ ┻━┻",
+	"/unflip":    "┬─┬ノ( º _ ºノ)",
+	"/lenny":     "( ͡° ͜ʖ ͡°)",
+}
+
+// LoadMacros reads text macros from a file with one "name = expansion" definition per
+// line, e.g. "shrug = ¯\_(ツ)_/¯". The leading slash of a name is optional. Blank lines
+// and lines starting with # are ignored. Macros cannot replace commands.
+func LoadMacros(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading macros file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	macros := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, expansion, ok := strings.Cut(line, "=")
+		name, expansion = strings.TrimSpace(name), strings.TrimSpace(expansion)
+		if !ok || expansion == "" || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("%s:%d: expected 'name = expansion'", path, lineNo)
+		}
+		name = "/" + strings.TrimPrefix(name, "/")
+		if _, isCommand := lookupCommand(name); isCommand {
+			return nil, fmt.Errorf("%s:%d: macro %s would replace a command", path, lineNo, name)
+		}
+		macros[name] = expansion
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading macros file %s: %w", path, err)
+	}
+	return macros, nil
+}
+
+// SetMacros adds text macros to the defaults, replacing default macros of the same name.
+func (ui *UI) SetMacros(macros map[string]string) {
+	for name, expansion := range macros {
+		ui.macros[name] = expansion
+	}
+}
+
+// expandMacro returns the message a macro expands to, with the text typed after the
+// macro in front of its expansion.
+func (ui *UI) expandMacro(name, text string) (string, bool) {
+	expansion, ok := ui.macros[name]
+	if !ok {
+		return "", false
+	}
+	if text = strings.TrimSpace(text); text != "" {
+		return text + " " + expansion, true
+	}
+	return expansion, true
+}
+
+// macroNames returns the names of the available macros, sorted.
+func (ui *UI) macroNames() []string {
+	names := make([]string, 0, len(ui.macros))
+	for name := range ui.macros {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,164 @@
+package pkg
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// Metrics counts node activity for scraping by Prometheus. All methods are safe for
+// concurrent use, and a nil *Metrics records nothing, so instrumented code costs a
+// nil check when metrics are disabled.
+type Metrics struct {
+	messagesSent     *prometheus.CounterVec // Messages published, by room
+	messagesReceived *prometheus.CounterVec // Messages received, by room
+	publishErrors    *prometheus.CounterVec // Failed publishes, by room
+	dialSuccesses    prometheus.Counter
+	dialFailures     prometheus.Counter
+}
+
+// NewMetrics creates an empty set of metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		messagesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "peernet_messages_sent_total",
+			Help: "Chat messages published, by room.",
+		}, []string{"room"}),
+		messagesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "peernet_messages_received_total",
+			Help: "Chat messages received, by room.",
+		}, []string{"room"}),
+		publishErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "peernet_publish_errors_total",
+			Help: "Failed publishes, by room.",
+		}, []string{"room"}),
+		dialSuccesses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "peernet_dial_successes_total",
+			Help: "Successful dials to discovered peers.",
+		}),
+		dialFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "peernet_dial_failures_total",
+			Help: "Failed dial attempts to discovered peers.",
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// collectors returns the counters making up the metrics.
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.messagesSent, m.messagesReceived, m.publishErrors, m.dialSuccesses, m.dialFailures}
+}
+
+// messagesPublished counts the messages of a frame published to a room, leaving out
+// presence announcements.
+func (m *Metrics) messagesPublished(room string, msgs []ChatMessage) {
+	if m == nil {
+		return
+	}
+	m.messagesSent.WithLabelValues(room).Add(float64(chatMessageCount(msgs)))
+}
+
+// chatMessageCount counts the messages of a frame, leaving out presence announcements
+// and typing notifications.
+func chatMessageCount(msgs []ChatMessage) uint64 {
+	var count uint64
+	for _, msg := range msgs {
+		if msg.Type != MessageTypePresence && msg.Type != MessageTypeTyping {
+			count++
+		}
+	}
+	return count
+}
+
+// messageReceived counts a message received in a room.
+func (m *Metrics) messageReceived(room string) {
+	if m == nil {
+		return
+	}
+	m.messagesReceived.WithLabelValues(room).Inc()
+}
+
+// publishFailed counts a failed publish to a room.
+func (m *Metrics) publishFailed(room string) {
+	if m == nil {
+		return
+	}
+	m.publishErrors.WithLabelValues(room).Inc()
+}
+
+// dialed counts the outcome of a dial attempt to a discovered peer.
+func (m *Metrics) dialed(err error) {
+	if m == nil {
+		return
+	}
+	if err == nil {
+		m.dialSuccesses.Inc()
+	} else {
+		m.dialFailures.Inc()
+	}
+}
+
+// ServeMetrics serves the node's metrics with promhttp at /metrics on the given
+// address until the network's context ends. It returns once listening.
+func (p *PeerNetwork) ServeMetrics(addr string) error {
+	if p.metrics == nil {
+		return errors.New("metrics are not enabled for this host")
+	}
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(p.metrics); err != nil {
+		return err
+	}
+	for _, gauge := range []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "peernet_connected_peers",
+			Help: "Number of connected peers.",
+		}, func() float64 { return float64(len(p.Host.Network().Peers())) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "peernet_dht_routing_table_size",
+			Help: "Number of peers in the Kademlia DHT routing table.",
+		}, func() float64 { return float64(p.KadDHT.RoutingTable().Size()) }),
+	} {
+		if err := registry.Register(gauge); err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-p.Ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.Errorf("Metrics server stopped: %v", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"strings"
+	"unicode"
+)
+
+// MessageMiddleware inspects, modifies or drops chat messages on their way into and out of a room.
+// Registered middleware runs in order; returning false from either method drops the message
+// and skips any remaining middleware.
+type MessageMiddleware interface {
+	// ProcessInbound handles a message received from a peer before it reaches Inbound.
+	ProcessInbound(msg ChatMessage) (ChatMessage, bool)
+	// ProcessOutbound handles a message sent by the local user before it is published.
+	ProcessOutbound(msg ChatMessage) (ChatMessage, bool)
+}
+
+// WithMiddleware appends middleware to the room's chain. The chain is empty by default;
+// add SanitizeMiddleware to strip control characters from messages.
+func WithMiddleware(middleware ...MessageMiddleware) RoomOption {
+	return func(cr *ChatRoom) {
+		cr.middleware = append(cr.middleware, middleware...)
+	}
+}
+
+// processInbound runs an inbound message through the middleware chain.
+func (cr *ChatRoom) processInbound(msg ChatMessage) (ChatMessage, bool) {
+	for _, mw := range cr.middleware {
+		var ok bool
+		if msg, ok = mw.ProcessInbound(msg); !ok {
+			return msg, false
+		}
+	}
+	return msg, true
+}
+
+// processOutbound runs an outbound message through the middleware chain.
+func (cr *ChatRoom) processOutbound(msg ChatMessage) (ChatMessage, bool) {
+	for _, mw := range cr.middleware {
+		var ok bool
+		if msg, ok = mw.ProcessOutbound(msg); !ok {
+			return msg, false
+		}
+	}
+	return msg, true
+}
+
+// SanitizeMiddleware strips control characters and surrounding whitespace from message
+// text and sender names, dropping messages that are empty once sanitized.
+type SanitizeMiddleware struct{}
+
+// ProcessInbound sanitizes a received message.
+func (SanitizeMiddleware) ProcessInbound(msg ChatMessage) (ChatMessage, bool) {
+	msg.SenderName = sanitizeText(msg.SenderName)
+	return SanitizeMiddleware{}.ProcessOutbound(msg)
+}
+
+// ProcessOutbound sanitizes a message before it is published.
+func (SanitizeMiddleware) ProcessOutbound(msg ChatMessage) (ChatMessage, bool) {
+	msg.Message = sanitizeText(msg.Message)
+	return msg, msg.Message != ""
+}
+
+// sanitizeText removes control characters and trims surrounding whitespace.
+func sanitizeText(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tagMiddleware appends its tag to the text of every message, recording the order in
+// which middleware ran.
+type tagMiddleware string
+
+func (m tagMiddleware) ProcessInbound(msg ChatMessage) (ChatMessage, bool) {
+	msg.Message += string(m)
+	return msg, true
+}
+
+func (m tagMiddleware) ProcessOutbound(msg ChatMessage) (ChatMessage, bool) {
+	return m.ProcessInbound(msg)
+}
+
+// dropMiddleware drops every message containing its text.
+type dropMiddleware string
+
+func (m dropMiddleware) ProcessInbound(msg ChatMessage) (ChatMessage, bool) {
+	return msg, !strings.Contains(msg.Message, string(m))
+}
+
+func (m dropMiddleware) ProcessOutbound(msg ChatMessage) (ChatMessage, bool) {
+	return m.ProcessInbound(msg)
+}
+
+func TestMiddlewareChainRunsInOrder(t *testing.T) {
+	cr := &ChatRoom{}
+	WithMiddleware(tagMiddleware("-a"), tagMiddleware("-b"))(cr)
+	WithMiddleware(tagMiddleware("-c"))(cr)
+
+	for name, process := range map[string]func(ChatMessage) (ChatMessage, bool){
+		"inbound":  cr.processInbound,
+		"outbound": cr.processOutbound,
+	} {
+		msg, ok := process(ChatMessage{Message: "hi"})
+		if !ok || msg.Message != "hi-a-b-c" {
+			t.Errorf("%s chain = %q, %v, want %q, true", name, msg.Message, ok, "hi-a-b-c")
+		}
+	}
+}
+
+func TestMiddlewareDropSkipsRestOfChain(t *testing.T) {
+	cr := &ChatRoom{}
+	WithMiddleware(tagMiddleware("-a"), dropMiddleware("spam"), tagMiddleware("-b"))(cr)
+
+	if msg, ok := cr.processInbound(ChatMessage{Message: "spam"}); ok || msg.Message != "spam-a" {
+		t.Errorf("dropped message = %q, %v, want %q, false", msg.Message, ok, "spam-a")
+	}
+	if msg, ok := cr.processOutbound(ChatMessage{Message: "hi"}); !ok || msg.Message != "hi-a-b" {
+		t.Errorf("kept message = %q, %v, want %q, true", msg.Message, ok, "hi-a-b")
+	}
+}
+
+func TestEmptyMiddlewareChainKeepsMessages(t *testing.T) {
+	cr := &ChatRoom{}
+	want := ChatMessage{Message: " \x1b[31mhi ", SenderName: "bob\n"}
+	if msg, ok := cr.processInbound(want); !ok || !reflect.DeepEqual(msg, want) {
+		t.Errorf("processInbound = %+v, %v, want the message unchanged", msg, ok)
+	}
+}
+
+func TestSanitizeMiddleware(t *testing.T) {
+	msg, ok := SanitizeMiddleware{}.ProcessInbound(ChatMessage{Message: " \x1b[31mhi\x07 ", SenderName: "bob\n"})
+	if !ok || msg.Message != "[31mhi" || msg.SenderName != "bob" {
+		t.Errorf("ProcessInbound = %+v, %v, want control characters and spaces stripped", msg, ok)
+	}
+	if _, ok := (SanitizeMiddleware{}).ProcessOutbound(ChatMessage{Message: "\t\x00 "}); ok {
+		t.Error("ProcessOutbound kept a message that is empty once sanitized")
+	}
+}
+
+func TestOutboundMiddlewareDropsMessage(t *testing.T) {
+	cr, transport := joinFakeRoom(t, WithMiddleware(dropMiddleware("spam")))
+	cr.Outbound <- "buy spam"
+	cr.Outbound <- "hello"
+	if msgs := nextPublished(t, cr, transport); len(msgs) != 1 || msgs[0].Message != "hello" {
+		t.Errorf("published %+v, want only %q", msgs, "hello")
+	}
+}
@@ -0,0 +1,44 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// namespacePattern restricts namespaces to what fits in an mDNS service name.
+var namespacePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,30}[a-z0-9])?$`)
+
+// validateNamespace checks that a network namespace is usable in discovery names.
+// The empty namespace is the default network shared by all PeerNet nodes.
+func validateNamespace(namespace string) error {
+	if namespace != "" && !namespacePattern.MatchString(namespace) {
+		return fmt.Errorf("invalid namespace %q: use up to 32 lowercase letters, digits and inner hyphens", namespace)
+	}
+	return nil
+}
+
+// serviceName returns the name the PeerChat service is advertised and announced
+// under. Nodes in different namespaces look for different names, so they never
+// discover each other.
+func (p *PeerNetwork) serviceName() string {
+	if p.namespace == "" {
+		return SERVICE
+	}
+	return p.namespace + "." + SERVICE
+}
+
+// topicName returns the PubSub topic of a room, scoped to the network namespace.
+func (p *PeerNetwork) topicName(roomName string) string {
+	if p.namespace == "" {
+		return fmt.Sprintf("room-peerchat-%s", roomName)
+	}
+	return fmt.Sprintf("%s/room-peerchat-%s", p.namespace, roomName)
+}
+
+// mdnsServiceTag returns the mDNS service name, scoped to the network namespace.
+func (p *PeerNetwork) mdnsServiceTag() string {
+	if p.namespace == "" {
+		return "_" + SERVICE + "-discovery._udp"
+	}
+	return "_" + SERVICE + "-" + p.namespace + "-discovery._udp"
+}
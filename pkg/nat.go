@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/sirupsen/logrus"
+)
+
+// natLogInterval is how often the NAT status is logged again in debug mode.
+const natLogInterval = 10 * time.Minute
+
+// Reachability returns whether AutoNAT found the host to be reachable from the public
+// internet. It is network.ReachabilityUnknown until enough peers have dialed back.
+func (p *PeerNetwork) Reachability() network.Reachability {
+	return network.Reachability(p.reachability.Load())
+}
+
+// natStatus describes a reachability as "public", "private" or "unknown".
+func natStatus(r network.Reachability) string {
+	switch r {
+	case network.ReachabilityPublic:
+		return "public"
+	case network.ReachabilityPrivate:
+		return "private"
+	default:
+		return "unknown"
+	}
+}
+
+// watchReachability records the reachability AutoNAT reports on the subscription and
+// logs every change, until the PeerNetwork context is cancelled.
+func (p *PeerNetwork) watchReachability(sub event.Subscription) {
+	defer sub.Close()
+	ticker := time.NewTicker(natLogInterval)
+	defer ticker.Stop()
+
+	logrus.Infof("NAT status: %s, waiting for AutoNAT", natStatus(p.Reachability()))
+	for {
+		select {
+		case <-p.Ctx.Done():
+			return
+		case evt, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			reachability := evt.(event.EvtLocalReachabilityChanged).Reachability
+			p.reachability.Store(int32(reachability))
+			logReachability(reachability, p.localOnly)
+		case <-ticker.C:
+			logrus.Debugf("NAT status: %s", natStatus(p.Reachability()))
+		}
+	}
+}
+
+// logReachability logs a new NAT status, with a hint on how a private node stays
+// reachable.
+func logReachability(reachability network.Reachability, localOnly bool) {
+	if reachability != network.ReachabilityPrivate {
+		logrus.Infof("NAT status: %s", natStatus(reachability))
+		return
+	}
+	if localOnly {
+		logrus.Info("NAT status: private, as expected in local-only mode")
+		return
+	}
+	logrus.Info("NAT status: private; peers reach this node through relays (auto-relay) and UPnP/NAT-PMP port mapping where the router allows it")
+}
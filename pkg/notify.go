@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Notifier shows desktop notifications.
+type Notifier interface {
+	Notify(title, body string) error
+}
+
+// NotifierFunc adapts a function to the Notifier interface.
+type NotifierFunc func(title, body string) error
+
+// Notify calls f(title, body).
+func (f NotifierFunc) Notify(title, body string) error {
+	return f(title, body)
+}
+
+// NewDesktopNotifier returns a Notifier using the platform's notification tool:
+// notify-send on Linux and the BSDs, and osascript on macOS. It returns nil on
+// platforms without a supported tool.
+func NewDesktopNotifier() Notifier {
+	switch runtime.GOOS {
+	case "darwin":
+		return NotifierFunc(func(title, body string) error {
+			script := fmt.Sprintf("display notification %s with title %s", strconv.Quote(body), strconv.Quote(title))
+			return exec.Command("osascript", "-e", script).Run()
+		})
+	case "linux", "freebsd", "openbsd", "netbsd":
+		if _, err := exec.LookPath("notify-send"); err != nil {
+			return nil
+		}
+		return NotifierFunc(func(title, body string) error {
+			return exec.Command("notify-send", "--app-name=PeerNet", "--", title, body).Run()
+		})
+	default:
+		return nil
+	}
+}
+
+// SetNotifier sets the notifier used to report mentions of the user while the
+// terminal window is unfocused. A nil notifier disables notifications.
+func (ui *UI) SetNotifier(n Notifier) {
+	ui.notifier = n
+}
+
+// mentionPattern compiles a pattern matching the name as a whole word, ignoring case.
+// It returns nil for an empty name, which nothing mentions.
+func mentionPattern(name string) *regexp.Regexp {
+	if name == "" {
+		return nil
+	}
+	return regexp.MustCompile(`(?i)(^|[^\pL\pN_])` + regexp.QuoteMeta(name) + `($|[^\pL\pN_])`)
+}
+
+// mentions reports whether a message mentions the user of the room.
+func (cr *ChatRoom) mentions(message string) bool {
+	cr.userMu.RLock()
+	defer cr.userMu.RUnlock()
+	return cr.mention != nil && cr.mention.MatchString(message)
+}
+
+// notifyMention shows a desktop notification for a message mentioning the user in a
+// room, unless notifications are disabled or the terminal window has focus.
+func (ui *UI) notifyMention(cr *ChatRoom, msg ChatMessage) {
+	if ui.notifier == nil || ui.focused.Load() || !cr.mentions(msg.Message) {
+		return
+	}
+
+	title := fmt.Sprintf("%s in %s", msg.SenderName, cr.RoomName)
+	go func() {
+		// Errors are not shown in the message box since they would repeat on every mention
+		_ = ui.notifier.Notify(title, msg.Message)
+	}()
+}
+
+// focusScreen is a tcell.Screen that reports whether the terminal window has focus,
+// for terminals that support focus reporting. Other terminals always count as unfocused,
+// so mentions are still notified.
+type focusScreen struct {
+	tcell.Screen
+	focused *atomic.Bool
+}
+
+// Init initializes the screen and enables focus reporting.
+func (s focusScreen) Init() error {
+	if err := s.Screen.Init(); err != nil {
+		return err
+	}
+	s.Screen.EnableFocus()
+	return nil
+}
+
+// PollEvent returns the next event, recording focus changes.
+func (s focusScreen) PollEvent() tcell.Event {
+	event := s.Screen.PollEvent()
+	if focus, ok := event.(*tcell.EventFocus); ok {
+		s.focused.Store(focus.Focused)
+	}
+	return event
+}
@@ -0,0 +1,291 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+// maxPendingDirect is the number of direct messages queued for a single offline peer.
+const maxPendingDirect = 50
+
+// pendingDirectExpiry is how long a queued direct message waits for its peer to come
+// back before it is given up.
+const pendingDirectExpiry = 24 * time.Hour
+
+// outboxSweepInterval is how often expired messages are given up, and queued messages
+// for peers that connected meanwhile are sent.
+const outboxSweepInterval = time.Minute
+
+// ErrOutboxFull is returned when a direct message cannot be queued because the
+// offline peer already has maxPendingDirect messages waiting.
+var ErrOutboxFull = errors.New("too many messages are waiting for this peer")
+
+// DirectReceipt reports the outcome of a queued direct message.
+type DirectReceipt struct {
+	ID        string  // ID of the queued message
+	To        peer.ID // Peer the message was addressed to
+	Delivered bool    // Whether the peer acknowledged it; false if it expired
+}
+
+// pendingDirect is a direct message waiting for its peer to reconnect.
+type pendingDirect struct {
+	To       string      `json:"to"`
+	Msg      ChatMessage `json:"msg"`
+	QueuedAt time.Time   `json:"queued_at"`
+}
+
+// directOutbox holds direct messages for offline peers until they reconnect, saving
+// them to a file when a path is set. All methods are safe for concurrent use.
+type directOutbox struct {
+	path string // File the queue is saved to, empty keeps it in memory only
+
+	mu      sync.Mutex
+	pending map[peer.ID][]pendingDirect // Queued messages per peer, oldest first
+}
+
+// loadDirectOutbox reads the outbox file at path, which need not exist yet. Expired
+// and malformed entries are skipped.
+func loadDirectOutbox(path string) (*directOutbox, error) {
+	o := &directOutbox{
+		path:    path,
+		pending: make(map[peer.ID][]pendingDirect),
+	}
+	if path == "" {
+		return o, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading outbox file %s: %w", path, err)
+	}
+	var entries []pendingDirect
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid outbox file %s: %w", path, err)
+	}
+
+	for _, entry := range entries {
+		if time.Since(entry.QueuedAt) > pendingDirectExpiry {
+			continue
+		}
+		id, err := peer.Decode(entry.To)
+		if err != nil {
+			continue
+		}
+		o.pending[id] = append(o.pending[id], entry)
+	}
+	return o, nil
+}
+
+// Add queues a message for a peer.
+func (o *directOutbox) Add(to peer.ID, msg ChatMessage) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.pending[to]) >= maxPendingDirect {
+		return ErrOutboxFull
+	}
+	o.pending[to] = append(o.pending[to], pendingDirect{To: to.Pretty(), Msg: msg, QueuedAt: time.Now()})
+	return o.save()
+}
+
+// Peek returns the oldest message queued for a peer.
+func (o *directOutbox) Peek(to peer.ID) (ChatMessage, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.pending[to]) == 0 {
+		return ChatMessage{}, false
+	}
+	return o.pending[to][0].Msg, true
+}
+
+// Remove drops a delivered message from a peer's queue.
+func (o *directOutbox) Remove(to peer.ID, id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	queue := o.pending[to]
+	for i, entry := range queue {
+		if entry.Msg.ID == id {
+			queue = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) == 0 {
+		delete(o.pending, to)
+	} else {
+		o.pending[to] = queue
+	}
+	if err := o.save(); err != nil {
+		logrus.Debugf("Failed to save the direct message outbox: %v", err)
+	}
+}
+
+// Peers returns the peers that have messages waiting.
+func (o *directOutbox) Peers() []peer.ID {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	peers := make([]peer.ID, 0, len(o.pending))
+	for id := range o.pending {
+		peers = append(peers, id)
+	}
+	return peers
+}
+
+// Expire drops the messages queued longer than pendingDirectExpiry and returns them.
+func (o *directOutbox) Expire() []pendingDirect {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var expired []pendingDirect
+	for id, queue := range o.pending {
+		kept := queue[:0]
+		for _, entry := range queue {
+			if time.Since(entry.QueuedAt) > pendingDirectExpiry {
+				expired = append(expired, entry)
+			} else {
+				kept = append(kept, entry)
+			}
+		}
+		if len(kept) == 0 {
+			delete(o.pending, id)
+		} else {
+			o.pending[id] = kept
+		}
+	}
+	if len(expired) > 0 {
+		if err := o.save(); err != nil {
+			logrus.Debugf("Failed to save the direct message outbox: %v", err)
+		}
+	}
+	return expired
+}
+
+// save writes the queued messages to the outbox file, oldest first. The caller must
+// hold o.mu.
+func (o *directOutbox) save() error {
+	if o.path == "" {
+		return nil
+	}
+	entries := []pendingDirect{}
+	for _, queue := range o.pending {
+		entries = append(entries, queue...)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].QueuedAt.Before(entries[j].QueuedAt) })
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Replace the file atomically so a crash never leaves it truncated
+	if err := os.MkdirAll(filepath.Dir(o.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(o.path), ".outbox-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), o.path)
+}
+
+// SendOrQueueDirect delivers a message to a peer like SendDirectMessage. If the peer
+// is not connected and cannot be reached, the message is queued instead and sent once
+// the peer reconnects, and queued is true. The outcome of a queued message is later
+// reported on DirectReceipts.
+func (p *PeerNetwork) SendOrQueueDirect(to peer.ID, msg ChatMessage) (queued bool, err error) {
+	err = p.SendDirectMessage(to, msg)
+	if err == nil || p.Host.Network().Connectedness(to) == network.Connected {
+		// A connected peer that did not acknowledge is not offline, e.g. an older client
+		return false, err
+	}
+	if err := p.outbox.Add(to, msg); err != nil {
+		return false, err
+	}
+	logrus.Debugf("Queued direct message %s for offline peer %s", msg.ID, shortID(to))
+	return true, nil
+}
+
+// runOutbox sends queued direct messages whenever their peer connects and gives up
+// expired ones, until the PeerNetwork context is cancelled.
+func (p *PeerNetwork) runOutbox() {
+	connected := make(chan peer.ID, 16)
+	notifee := &network.NotifyBundle{
+		ConnectedF: func(_ network.Network, conn network.Conn) {
+			select {
+			case connected <- conn.RemotePeer():
+			default:
+				// The periodic sweep picks the peer up instead
+			}
+		},
+	}
+	p.Host.Network().Notify(notifee)
+	defer p.Host.Network().StopNotify(notifee)
+
+	ticker := time.NewTicker(outboxSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.Ctx.Done():
+			return
+		case id := <-connected:
+			p.flushOutbox(id)
+		case <-ticker.C:
+			for _, entry := range p.outbox.Expire() {
+				id, _ := peer.Decode(entry.To)
+				logrus.Debugf("Gave up direct message %s to %s after %s", entry.Msg.ID, shortID(id), pendingDirectExpiry)
+				p.directReceipt(DirectReceipt{ID: entry.Msg.ID, To: id})
+			}
+			for _, id := range p.outbox.Peers() {
+				if p.Host.Network().Connectedness(id) == network.Connected {
+					p.flushOutbox(id)
+				}
+			}
+		}
+	}
+}
+
+// flushOutbox sends the messages queued for a peer in order, stopping at the first
+// one that is not acknowledged; it is retried on the next connection or sweep.
+func (p *PeerNetwork) flushOutbox(to peer.ID) {
+	for {
+		msg, ok := p.outbox.Peek(to)
+		if !ok {
+			return
+		}
+		if err := p.SendDirectMessage(to, msg); err != nil {
+			logrus.Debugf("Failed to deliver queued direct message %s to %s: %v", msg.ID, shortID(to), err)
+			return
+		}
+		p.outbox.Remove(to, msg.ID)
+		p.directReceipt(DirectReceipt{ID: msg.ID, To: to, Delivered: true})
+	}
+}
+
+// directReceipt reports the outcome of a queued message on DirectReceipts, dropping it
+// when nobody keeps up with the channel.
+func (p *PeerNetwork) directReceipt(receipt DirectReceipt) {
+	select {
+	case p.DirectReceipts <- receipt:
+	default:
+		logrus.Debugf("Dropped the receipt of direct message %s", receipt.ID)
+	}
+}
@@ -2,16 +2,39 @@ package pkg
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/libp2p/go-libp2p-core/event"
 	"github.com/libp2p/go-libp2p-core/host"
+	coremetrics "github.com/libp2p/go-libp2p-core/metrics"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/libp2p/go-libp2p-core/routing"
 	discovery "github.com/libp2p/go-libp2p-discovery"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	mdns "github.com/libp2p/go-libp2p/p2p/discovery"
+	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/sirupsen/logrus"
 )
 
+// SERVICE is the name PeerNet nodes discover each other by, scoped by the network
+// namespace when one is configured.
 const SERVICE = "peernet"
 
+// BootstrapTag is the connection manager protection tag applied to bootstrap peers.
+const BootstrapTag = "peernet-bootstrap"
+
+// TransferTag prefixes the connection manager protection tags applied to peers while
+// a direct message or file is exchanged with them.
+const TransferTag = "peernet-transfer"
+
 // PeerNetwork represents a structure that encapsulates P2P communication components.
 type PeerNetwork struct {
 	Ctx       context.Context
@@ -19,39 +42,498 @@ type PeerNetwork struct {
 	KadDHT    *dht.IpfsDHT
 	Discovery *discovery.RoutingDiscovery
 	PubSub    *pubsub.PubSub
+
+	DirectInbound  chan ChatMessage   // Incoming direct messages channel
+	FileInbound    chan ReceivedFile  // Notices of files received from peers
+	DirectReceipts chan DirectReceipt // Outcomes of direct messages queued for offline peers
+
+	mdns       mdns.Service  // Local network discovery service, if started
+	dialer     *peerDialer   // Connects to discovered peers with retries
+	gater      *peerGater    // Refuses connections with blocked peers
+	knownPeers *knownPeers   // Peers remembered across restarts, nil when not persisted
+	outbox     *directOutbox // Direct messages waiting for offline peers
+	localOnly  bool          // Whether the host only talks to other local instances
+	namespace  string        // Network namespace scoping discovery and room topics, empty for the default network
+	metrics    *Metrics      // Activity counters, nil when metrics are disabled
+
+	started          time.Time                     // Time the host was created
+	bandwidth        *coremetrics.BandwidthCounter // Bytes sent and received by the host
+	messagesSent     atomic.Uint64                 // Chat messages published this session
+	messagesReceived atomic.Uint64                 // Chat messages received this session
+	reachability     atomic.Int32                  // Last network.Reachability reported by AutoNAT
+	transfers        atomic.Uint64                 // Direct messages and files exchanged so far, numbering their protection tags
+
+	discoveryInterval    time.Duration   // Interval between discovery rounds
+	readyPeers           int             // DHT routing table size required before discovery
+	readyTimeout         time.Duration   // Time allowed to reach readyPeers
+	advertiseTTL         time.Duration   // TTL requested for service advertisements
+	minAdvertiseInterval time.Duration   // Minimum time between two service advertisements
+	bootstrapPeers       []peer.AddrInfo // Bootstrap peers dialed again when the routing table shrinks
+	downloadDir          string          // Directory received files are saved to
+	fileQuota            *fileQuota      // Limits on the files received from each peer
+
+	roomsMu sync.Mutex             // Guards rooms
+	rooms   map[*ChatRoom]struct{} // Rooms joined on the host and not yet left
+
+	providers routing.ContentRouting // Announces and finds service providers, the DHT unless replaced in tests
+
+	discoveryMu    sync.Mutex   // Guards discoveryRound
+	discoveryRound func() error // Reruns the started DHT discovery, nil until started
+
+	discoveredMu sync.Mutex           // Guards discovered
+	discovered   map[peer.ID]struct{} // Peers found by discovery
+
+	closeOnce sync.Once // Guards closing the host
+	closeErr  error     // Error returned by the first Close
+}
+
+// HostConfig holds optional settings for the PeerNetwork host.
+// The zero value reproduces the default behaviour.
+type HostConfig struct {
+	IdentityPath      string   // Path to a persistent identity key; empty generates a fresh identity
+	KeyType           string   // Key type for generated identities (KeyTypeRSA or KeyTypeEd25519); defaults to RSA
+	ListenAddrs       []string // Multiaddrs to listen on; defaults to DefaultListenAddr and DefaultListenAddr6 when empty
+	DisableIPv6       bool     // Listen on IPv4 only when ListenAddrs is empty
+	LocalOnly         bool     // Listen on loopback only (LocalListenAddr by default) without public bootstrap or NAT traversal
+	Ephemeral         bool     // Write nothing to disk: IdentityPath, PeerstorePath and OutboxPath are ignored and files from peers are refused
+	Security          string   // Security transports (SecurityTLS, SecurityNoise or SecurityBoth); defaults to TLS
+	Muxers            []string // Stream muxers in order of preference (MuxerYamux, MuxerMplex); defaults to yamux only
+	BootstrapPeers    []string // Bootstrap peer multiaddrs replacing the IPFS defaults when non-empty
+	MinBootstrapPeers int      // Bootstrap peers that must connect at startup; defaults to DefaultMinBootstrapPeers
+	BootstrapOptional bool     // Only warn when fewer than MinBootstrapPeers connect, for peers found without the DHT such as through mDNS
+	Namespace         string   // Network namespace isolating discovery and rooms from other nodes; empty joins the default network
+	PeerstorePath     string   // File remembering the peers chatted with, dialed again at startup; empty disables it
+	OutboxPath        string   // File keeping direct messages queued for offline peers across restarts; empty keeps them in memory
+	PSKFile           string   // Pre-shared key file enabling private network mode; the IPFS defaults are never used and the DHT runs in client mode
+	BlockedPeers      []string // Peer IDs whose connections are always refused
+	AllowedPeers      []string // Peer IDs that are the only ones accepted when non-empty, bootstrap peers included
+
+	DialAttempts int           // Dial attempts per discovered peer; defaults to DefaultDialAttempts
+	DialBackoff  time.Duration // Delay before the first redial, doubled per attempt; defaults to DefaultDialBackoff
+
+	DiscoveryInterval time.Duration // Interval between discovery rounds; defaults to DefaultDiscoveryInterval
+	ReadyPeers        int           // DHT routing table size required before discovery; defaults to DefaultReadyPeers
+	ReadyTimeout      time.Duration // Time allowed to reach ReadyPeers; defaults to DefaultReadyTimeout
+
+	AdvertiseTTL         time.Duration // TTL requested for 'advertise' discovery, which renews it before it elapses; defaults to DefaultAdvertiseTTL
+	MinAdvertiseInterval time.Duration // Minimum time between two advertisements, however short the TTL; defaults to DefaultMinAdvertiseInterval
+
+	RebootstrapInterval time.Duration // Interval between routing table health checks; zero disables re-bootstrapping
+	RebootstrapPeers    int           // Routing table size below which the DHT is re-bootstrapped; defaults to DefaultRebootstrapPeers
+
+	DownloadDir string // Directory received files are saved to; defaults to DefaultDownloadDir
+
+	ConnLow   int           // Connection count the connection manager trims down to; defaults to DefaultConnLow
+	ConnHigh  int           // Connection count that triggers trimming; defaults to DefaultConnHigh
+	ConnGrace time.Duration // Age below which new connections are never trimmed; defaults to DefaultConnGrace
+
+	LaxSignatures bool                 // Accept unsigned messages, verifying signatures only when present; by default they are dropped
+	MessageIDFunc pubsub.MsgIdFunction // Computes PubSub message IDs, e.g. ContentMessageID; defaults to pubsub.DefaultMsgIdFn (author and sequence number)
+	PeerScoring   bool                 // Score peers and stop routing messages for ones that misbehave
+
+	StaticRelays        []string      // Relay multiaddrs used by auto-relay; relays are discovered through the DHT when empty
+	RelayServer         bool          // Relay traffic for other peers as a circuit relay
+	RelayMaxCircuits    int           // Circuits relayed at the same time, process-wide; defaults to the circuit relay package limit
+	RelayConnectTimeout time.Duration // Time allowed to reach a circuit's destination, process-wide; defaults to the circuit relay package timeout
+
+	StreamHandlers map[protocol.ID]network.StreamHandler // Extra inbound stream handlers registered on the host; PeerNet's own protocols are reserved
+
+	Metrics *Metrics // Activity counters served by ServeMetrics; nil disables metrics
 }
 
 // NewP2P initializes a new PeerNetwork instance with a Kademlia DHT and PubSub service.
-func NewP2P(ctx context.Context) (*PeerNetwork, error) {
+func NewP2P(ctx context.Context, cfg HostConfig) (*PeerNetwork, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Ephemeral {
+		cfg.IdentityPath, cfg.PeerstorePath, cfg.OutboxPath = "", "", ""
+	}
+
+	// Load or generate the host identity
+	prvKey, err := loadIdentity(cfg.IdentityPath, cfg.KeyType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the peers remembered from previous sessions
+	var known *knownPeers
+	if cfg.PeerstorePath != "" {
+		if known, err = loadKnownPeers(cfg.PeerstorePath); err != nil {
+			return nil, err
+		}
+	}
+
+	// Load the direct messages still waiting for offline peers
+	outbox, err := loadDirectOutbox(cfg.OutboxPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the connection gater from the block and allow lists
+	gater, err := newPeerGater(cfg.BlockedPeers, cfg.AllowedPeers)
+	if err != nil {
+		return nil, err
+	}
+
 	// Setup the host and KadDHT
-	nodehost, kaddht, err := setupHost(ctx)
+	bandwidth := coremetrics.NewBandwidthCounter()
+	nodehost, kaddht, err := setupHost(ctx, prvKey, cfg, gater, bandwidth)
 	if err != nil {
 		return nil, err
 	}
 	logrus.Debugln("Created the PeerNetwork Host and Kademlia DHT")
 
+	// Close the host and DHT again if any later step fails
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			kaddht.Close()
+			nodehost.Close()
+		}
+	}()
+
 	// Bootstrap the KadDHT
-	if err := bootstrapDHT(ctx, nodehost, kaddht); err != nil {
+	bootstrapPeers, err := cfg.bootstrapPeers()
+	if err != nil {
+		return nil, err
+	}
+	if len(bootstrapPeers) > 0 {
+		minBootstrap := cfg.MinBootstrapPeers
+		if minBootstrap <= 0 {
+			minBootstrap = DefaultMinBootstrapPeers
+		}
+		err := bootstrapDHT(ctx, nodehost, kaddht, bootstrapPeers, minBootstrap)
+		switch {
+		case err == nil:
+			logrus.Debugln("Bootstrapped the Kademlia DHT")
+		case cfg.BootstrapOptional && errors.Is(err, ErrBootstrapFailed):
+			logrus.Warnf("%v; continuing, DHT discovery will not work", err)
+		default:
+			return nil, err
+		}
+	} else {
+		logrus.Debugln("No bootstrap peers configured, skipped bootstrapping the Kademlia DHT")
+	}
+
+	relays, err := cfg.staticRelays()
+	if err != nil {
 		return nil, err
 	}
-	logrus.Debugln("Bootstrapped the Kademlia DHT")
 
 	// Create peer discovery service
 	routingDiscovery := discovery.NewRoutingDiscovery(kaddht)
 	logrus.Debugln("Created the Peer Discovery Service")
 
 	// Create a PubSub handler
-	pubsubHandler, err := setupPubSub(ctx, nodehost, routingDiscovery)
+	pubsubHandler, err := setupPubSub(ctx, nodehost, routingDiscovery, cfg)
 	if err != nil {
 		return nil, err
 	}
 	logrus.Debugln("Created the PubSub Handler")
 
-	return &PeerNetwork{
-		Ctx:       ctx,
-		Host:      nodehost,
-		KadDHT:    kaddht,
-		Discovery: routingDiscovery,
-		PubSub:    pubsubHandler,
-	}, nil
+	discoveryInterval := cfg.DiscoveryInterval
+	if discoveryInterval <= 0 {
+		discoveryInterval = DefaultDiscoveryInterval
+	}
+
+	readyPeers := cfg.ReadyPeers
+	if readyPeers <= 0 {
+		readyPeers = DefaultReadyPeers
+	}
+
+	readyTimeout := cfg.ReadyTimeout
+	if readyTimeout <= 0 {
+		readyTimeout = DefaultReadyTimeout
+	}
+
+	advertiseTTL := cfg.AdvertiseTTL
+	if advertiseTTL <= 0 {
+		advertiseTTL = DefaultAdvertiseTTL
+	}
+
+	minAdvertiseInterval := cfg.MinAdvertiseInterval
+	if minAdvertiseInterval <= 0 {
+		minAdvertiseInterval = DefaultMinAdvertiseInterval
+	}
+
+	downloadDir := cfg.DownloadDir
+	if downloadDir == "" {
+		downloadDir = DefaultDownloadDir()
+	}
+
+	p2pHost := &PeerNetwork{
+		Ctx:                  ctx,
+		Host:                 nodehost,
+		KadDHT:               kaddht,
+		providers:            kaddht,
+		Discovery:            routingDiscovery,
+		PubSub:               pubsubHandler,
+		DirectInbound:        make(chan ChatMessage, 1),
+		FileInbound:          make(chan ReceivedFile, 1),
+		DirectReceipts:       make(chan DirectReceipt, 16),
+		dialer:               newPeerDialer(nodehost, cfg.DialAttempts, cfg.DialBackoff, cfg.Metrics),
+		gater:                gater,
+		localOnly:            cfg.LocalOnly,
+		namespace:            cfg.Namespace,
+		knownPeers:           known,
+		outbox:               outbox,
+		metrics:              cfg.Metrics,
+		started:              time.Now(),
+		discovered:           make(map[peer.ID]struct{}),
+		bandwidth:            bandwidth,
+		discoveryInterval:    discoveryInterval,
+		readyPeers:           readyPeers,
+		readyTimeout:         readyTimeout,
+		advertiseTTL:         advertiseTTL,
+		minAdvertiseInterval: minAdvertiseInterval,
+		bootstrapPeers:       bootstrapPeers,
+		downloadDir:          downloadDir,
+		fileQuota:            newFileQuota(),
+		rooms:                make(map[*ChatRoom]struct{}),
+	}
+
+	// Register the direct message and file transfer stream handlers, then the configured ones
+	handlers := map[protocol.ID]network.StreamHandler{
+		DirectMessageProtocol: p2pHost.handleDirectStream,
+	}
+	if !cfg.Ephemeral {
+		// Received files are saved to disk, so ephemeral nodes do not accept them
+		handlers[FileTransferProtocol] = p2pHost.handleFileStream
+	}
+	for id, handler := range cfg.StreamHandlers {
+		handlers[id] = handler
+	}
+	for id, handler := range handlers {
+		if err := p2pHost.setStreamHandler(id, handler); err != nil {
+			return nil, err
+		}
+	}
+
+	// Deliver queued direct messages once their peers reconnect
+	go p2pHost.runOutbox()
+
+	// Reconnect to known peers alongside discovery
+	go p2pHost.reconnectKnownPeers()
+
+	// Re-bootstrap the DHT whenever its routing table decays
+	if cfg.RebootstrapInterval > 0 {
+		rebootstrapPeers := cfg.RebootstrapPeers
+		if rebootstrapPeers <= 0 {
+			rebootstrapPeers = DefaultRebootstrapPeers
+		}
+		go p2pHost.maintainRoutingTable(cfg.RebootstrapInterval, rebootstrapPeers)
+	}
+
+	// Report the transfer rates in debug mode
+	go p2pHost.logBandwidth()
+
+	// Connect to the static relays and report the relay addresses auto-relay uses
+	go connectStaticRelays(ctx, nodehost, relays)
+	go logRelayAddrs(ctx, nodehost)
+
+	// Report the reachability AutoNAT determines
+	if sub, err := nodehost.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged)); err != nil {
+		logrus.Debugf("Failed to watch the NAT status: %v", err)
+	} else {
+		go p2pHost.watchReachability(sub)
+	}
+
+	// Tear everything down once the context is cancelled
+	go func() {
+		<-ctx.Done()
+		if err := p2pHost.Close(); err != nil {
+			logrus.Debugf("Error closing the PeerNetwork host: %v", err)
+		}
+	}()
+
+	succeeded = true
+	return p2pHost, nil
+}
+
+// Validate checks the configuration without touching the network, reporting every
+// invalid setting in the returned error. NewP2P validates its configuration first.
+func (cfg HostConfig) Validate() error {
+	var errs []error
+	if err := validateNamespace(cfg.Namespace); err != nil {
+		errs = append(errs, err)
+	}
+	switch cfg.KeyType {
+	case "", KeyTypeRSA, KeyTypeEd25519:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported key type %q (expected %q or %q)", cfg.KeyType, KeyTypeRSA, KeyTypeEd25519))
+	}
+	switch cfg.Security {
+	case "", SecurityTLS, SecurityNoise, SecurityBoth:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported security mode %q (expected %q, %q or %q)", cfg.Security, SecurityTLS, SecurityNoise, SecurityBoth))
+	}
+	if _, err := muxerOptions(cfg.Muxers); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := cfg.listenAddrs(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := cfg.bootstrapPeers(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := cfg.staticRelays(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := cfg.validateRebootstrap(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := cfg.validateStreamHandlers(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, _, _, err := cfg.connManagerLimits(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newPeerGater(cfg.BlockedPeers, cfg.AllowedPeers); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// NewP2PWithIdentity initializes a new PeerNetwork instance using the identity stored at keyPath,
+// generating and persisting one there if it does not exist yet.
+func NewP2PWithIdentity(ctx context.Context, keyPath string) (*PeerNetwork, error) {
+	return NewP2P(ctx, HostConfig{IdentityPath: keyPath})
+}
+
+// addRoom records a room joined on the host.
+func (p *PeerNetwork) addRoom(cr *ChatRoom) {
+	p.roomsMu.Lock()
+	p.rooms[cr] = struct{}{}
+	p.roomsMu.Unlock()
+}
+
+// removeRoom forgets a room that was left.
+func (p *PeerNetwork) removeRoom(cr *ChatRoom) {
+	p.roomsMu.Lock()
+	delete(p.rooms, cr)
+	p.roomsMu.Unlock()
+}
+
+// inJoinedRoom reports whether a peer is currently in one of the joined rooms.
+func (p *PeerNetwork) inJoinedRoom(id peer.ID) bool {
+	p.roomsMu.Lock()
+	rooms := make([]*ChatRoom, 0, len(p.rooms))
+	for cr := range p.rooms {
+		rooms = append(rooms, cr)
+	}
+	p.roomsMu.Unlock()
+
+	for _, cr := range rooms {
+		for _, member := range cr.PeerList() {
+			if member == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Close shuts down local discovery, the Kademlia DHT and the libp2p host. It is also
+// called when the PeerNetwork context is cancelled, and only the first call has an effect.
+func (p *PeerNetwork) Close() error {
+	p.closeOnce.Do(func() {
+		var errs []error
+		if err := p.knownPeers.Save(p.Host.Peerstore()); err != nil {
+			errs = append(errs, fmt.Errorf("error saving known peers: %w", err))
+		}
+		if p.mdns != nil {
+			errs = append(errs, p.mdns.Close())
+		}
+		errs = append(errs, p.KadDHT.Close(), p.Host.Close())
+		p.closeErr = errors.Join(errs...)
+	})
+	return p.closeErr
+}
+
+// ProtectPeer shields the connection to a peer from being trimmed by the connection manager
+// until it is unprotected under the same tag.
+func (p *PeerNetwork) ProtectPeer(id peer.ID, tag string) {
+	p.Host.ConnManager().Protect(id, tag)
+}
+
+// UnprotectPeer removes a protection tag from a peer and reports whether the peer
+// is still protected under any other tag.
+func (p *PeerNetwork) UnprotectPeer(id peer.ID, tag string) bool {
+	return p.Host.ConnManager().Unprotect(id, tag)
+}
+
+// protectTransfer protects the connection to a peer while a direct message or file is
+// exchanged with it, and returns the function removing the protection. Each transfer
+// has its own tag, so one finishing leaves the others protected.
+func (p *PeerNetwork) protectTransfer(id peer.ID) func() {
+	tag := fmt.Sprintf("%s-%d", TransferTag, p.transfers.Add(1))
+	p.ProtectPeer(id, tag)
+	return func() {
+		p.UnprotectPeer(id, tag)
+	}
+}
+
+// ProtectedPeers returns the known peers currently protected under any tag.
+func (p *PeerNetwork) ProtectedPeers() []peer.ID {
+	var protected []peer.ID
+	for _, id := range p.Host.Peerstore().Peers() {
+		if p.Host.ConnManager().IsProtected(id, "") {
+			protected = append(protected, id)
+		}
+	}
+	return protected
+}
+
+// Addrs returns the host's dialable multiaddrs, each ending in its /p2p/ peer ID
+// component. Addresses observed by peers and relay addresses are included once NAT
+// traversal has discovered them, so the result may grow after startup.
+func (p *PeerNetwork) Addrs() []string {
+	p2pAddrs, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: p.Host.ID(), Addrs: p.Host.Addrs()})
+	if err != nil {
+		return nil
+	}
+
+	addrs := make([]string, len(p2pAddrs))
+	for i, addr := range p2pAddrs {
+		addrs[i] = addr.String()
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// NetworkStatus is a snapshot of the host's connectivity.
+type NetworkStatus struct {
+	Peers     int                  // Number of connected peers
+	DHTReady  bool                 // Whether the DHT routing table holds enough peers for discovery
+	Reachable bool                 // Whether the host has observed a public address
+	NAT       network.Reachability // Reachability determined by AutoNAT
+}
+
+// Status returns a snapshot of the host's connectivity.
+func (p *PeerNetwork) Status() NetworkStatus {
+	status := NetworkStatus{
+		Peers:    len(p.Host.Network().Peers()),
+		DHTReady: p.KadDHT.RoutingTable().Size() >= p.readyPeers,
+		NAT:      p.Reachability(),
+	}
+	for _, addr := range p.Host.Addrs() {
+		if manet.IsPublicAddr(addr) {
+			status.Reachable = true
+			break
+		}
+	}
+	return status
+}
+
+// BlockPeer refuses all further connections with a peer and closes any open ones.
+func (p *PeerNetwork) BlockPeer(id peer.ID) error {
+	p.gater.Block(id)
+	return p.Host.Network().ClosePeer(id)
+}
+
+// UnblockPeer allows connections with a previously blocked peer again, reporting
+// whether the peer was blocked.
+func (p *PeerNetwork) UnblockPeer(id peer.ID) bool {
+	return p.gater.Unblock(id)
 }
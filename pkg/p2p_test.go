@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestProtectedPeerSurvivesTrim(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hub := newTestPeerNetworkWith(t, HostConfig{ConnLow: 1, ConnHigh: 2, ConnGrace: time.Nanosecond})
+
+	// Plain hosts do not run the DHT, which protects the peers in its routing table
+
+	var peers []host.Host
+	for i := 0; i < 3; i++ {
+		h, err := libp2p.New(ctx, libp2p.ListenAddrStrings(LocalListenAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Close()
+		if err := h.Connect(ctx, peer.AddrInfo{ID: hub.Host.ID(), Addrs: hub.Host.Addrs()}); err != nil {
+			t.Fatal(err)
+		}
+		peers = append(peers, h)
+	}
+
+	protected := peers[0].ID()
+	hub.ProtectPeer(protected, "test")
+	if got := hub.ProtectedPeers(); len(got) != 1 || got[0] != protected {
+		t.Fatalf("ProtectedPeers = %v, want [%s]", got, protected)
+	}
+
+	hub.Host.ConnManager().TrimOpenConns(ctx)
+	if hub.Host.Network().Connectedness(protected) != network.Connected {
+		t.Error("the protected peer was trimmed")
+	}
+	trimmed := 0
+	for _, h := range peers[1:] {
+		if hub.Host.Network().Connectedness(h.ID()) != network.Connected {
+			trimmed++
+		}
+	}
+	if trimmed == 0 {
+		t.Error("no unprotected peer was trimmed")
+	}
+
+	if hub.UnprotectPeer(protected, "test") {
+		t.Error("UnprotectPeer reports the peer is still protected")
+	}
+	if got := hub.ProtectedPeers(); len(got) != 0 {
+		t.Errorf("ProtectedPeers = %v after unprotecting, want none", got)
+	}
+}
+
+func TestTransferProtection(t *testing.T) {
+	p := newTestPeerNetwork(t)
+	id := peer.ID("peer")
+	cm := p.Host.ConnManager()
+
+	first, second := p.protectTransfer(id), p.protectTransfer(id)
+	first()
+	if !cm.IsProtected(id, "") {
+		t.Error("the peer was unprotected while a second transfer was running")
+	}
+	second()
+	if cm.IsProtected(id, "") {
+		t.Error("the peer is still protected after its transfers finished")
+	}
+}
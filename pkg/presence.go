@@ -0,0 +1,150 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// peerWatchInterval is how often the topic's peer list is checked for joins and leaves.
+const peerWatchInterval = time.Second
+
+// presenceInterval is how often a room member announces its username to the room.
+const presenceInterval = 30 * time.Second
+
+// presenceLoop periodically announces the local user's presence until the room is left.
+func (cr *ChatRoom) presenceLoop() {
+	ticker := time.NewTicker(presenceInterval)
+	defer ticker.Stop()
+
+	cr.announcePresence()
+	for {
+		select {
+		case <-cr.psCtx.Done():
+			return
+		case <-ticker.C:
+			cr.announcePresence()
+		}
+	}
+}
+
+// announcePresence publishes a presence message carrying the local username and
+// capabilities. Presence messages bypass the middleware chain as they carry no text.
+func (cr *ChatRoom) announcePresence() {
+	presence := cr.newChatMessage("")
+	presence.Type = MessageTypePresence
+	presence.Caps = localCapabilities
+	if err := cr.publishFrame(presence); err != nil {
+		cr.handler.OnLog(ChatLog{Prefix: "puberr", Msg: "failed to announce presence"})
+	}
+}
+
+// peerWatchLoop diffs the topic's peer list between ticks and reports peers that
+// joined or left the room. The first tick only records the initial peers.
+func (cr *ChatRoom) peerWatchLoop() {
+	ticker := time.NewTicker(peerWatchInterval)
+	defer ticker.Stop()
+
+	var known map[peer.ID]struct{}
+	for {
+		select {
+		case <-cr.psCtx.Done():
+			return
+		case <-ticker.C:
+			current := make(map[peer.ID]struct{})
+			for _, id := range cr.PeerList() {
+				current[id] = struct{}{}
+			}
+
+			if known != nil {
+				for id := range current {
+					if _, ok := known[id]; !ok {
+						cr.handler.OnPeerJoin(id)
+					}
+				}
+				for id := range known {
+					if _, ok := current[id]; !ok {
+						cr.handler.OnPeerLeave(id)
+					}
+				}
+			}
+			known = current
+		}
+	}
+}
+
+// displayName returns the username last seen for a peer, falling back to its short ID.
+func (cr *ChatRoom) displayName(id peer.ID) string {
+	if name, ok := cr.PeerName(id); ok {
+		return name
+	}
+	return shortID(id)
+}
+
+// setPeerName records the most recent username seen for a peer.
+func (cr *ChatRoom) setPeerName(id peer.ID, name string) {
+	name = sanitizeText(name)
+	if name == "" {
+		return
+	}
+
+	cr.namesMu.Lock()
+	previous := cr.peerNames[id]
+	cr.peerNames[id] = name
+	cr.namesMu.Unlock()
+
+	// Warn when a peer starts using our name
+	if name != previous && strings.EqualFold(name, cr.UserName()) {
+		cr.handler.OnLog(cr.nameCollisionLog(name, id))
+	}
+}
+
+// nameOwner returns another peer currently using the given name, if any.
+func (cr *ChatRoom) nameOwner(name string) (peer.ID, bool) {
+	cr.namesMu.RLock()
+	defer cr.namesMu.RUnlock()
+	for id, peerName := range cr.peerNames {
+		if strings.EqualFold(peerName, name) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// nameCollisionLog builds a warning about a username shared with another peer,
+// suggesting a suffix derived from the local peer ID.
+func (cr *ChatRoom) nameCollisionLog(name string, owner peer.ID) ChatLog {
+	suffix := shortID(cr.selfID)
+	suffix = suffix[len(suffix)-4:]
+	return ChatLog{
+		Prefix: "warn",
+		Msg:    fmt.Sprintf("the name '%s' is also used by %s; consider '%s-%s' to stay distinguishable", name, shortID(owner), name, suffix),
+	}
+}
+
+// PeerName returns the most recent username seen for a peer, if any.
+func (cr *ChatRoom) PeerName(id peer.ID) (string, bool) {
+	cr.namesMu.RLock()
+	defer cr.namesMu.RUnlock()
+	name, ok := cr.peerNames[id]
+	return name, ok
+}
+
+// setActive records that a peer sent a message or reaction just now.
+func (cr *ChatRoom) setActive(id peer.ID) {
+	cr.namesMu.Lock()
+	cr.lastActive[id] = time.Now()
+	cr.namesMu.Unlock()
+}
+
+// LastActive returns the time a peer last sent a message or reaction to the room,
+// and whether it has sent any since the room was joined. Presence announcements do
+// not count as activity.
+func (cr *ChatRoom) LastActive(id peer.ID) (time.Time, bool) {
+	cr.namesMu.RLock()
+	defer cr.namesMu.RUnlock()
+	last, ok := cr.lastActive[id]
+	return last, ok
+}
@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// ParseUserColor validates a name color, given as a color name such as "orange" or as
+// "#rrggbb", and returns it in canonical form. An empty color means none.
+func ParseUserColor(color string) (string, error) {
+	if color == "" {
+		return "", nil
+	}
+	c := tcell.GetColor(strings.ToLower(color))
+	if c == tcell.ColorDefault {
+		return "", fmt.Errorf("unknown color %q, use a color name or #rrggbb", color)
+	}
+	return c.String(), nil
+}
+
+// WithUserColor sets the color peers render the user's name in. The color must be
+// valid for ParseUserColor, and an empty color leaves the choice to each peer.
+func WithUserColor(color string) RoomOption {
+	return func(cr *ChatRoom) {
+		cr.UserColor = color
+	}
+}
+
+// UpdateProfile switches the user's display name and name color, announcing both to
+// the room like UpdateUser.
+func (cr *ChatRoom) UpdateProfile(newUsername, color string) {
+	cr.userMu.Lock()
+	cr.UserColor = color
+	cr.userMu.Unlock()
+	cr.UpdateUser(newUsername)
+}
+
+// senderColor returns the name color a message's sender chose, or the fallback when
+// none was chosen or it is not a valid color.
+func senderColor(msg ChatMessage, fallback tcell.Color) tcell.Color {
+	if msg.Color == "" {
+		return fallback
+	}
+	if c := tcell.GetColor(strings.ToLower(msg.Color)); c != tcell.ColorDefault {
+		return c
+	}
+	return fallback
+}
+
+// cmdProfile switches to a named profile with its own display name and name color.
+// The color of a profile used earlier in the session is remembered, so switching back
+// only takes its name.
+func (ui *UI) cmdProfile(arg string) {
+	fields := strings.Fields(arg)
+	switch len(fields) {
+	case 0:
+		color := ui.UserColor
+		if color == "" {
+			color = "no color"
+		}
+		ui.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("profile: %s (%s)", ui.UserName(), color)})
+		return
+	case 1, 2:
+	default:
+		ui.OnLog(ChatLog{Prefix: "error", Msg: "usage: /profile <name> [color]"})
+		return
+	}
+
+	name := fields[0]
+	color, remembered := ui.profiles[name]
+	if len(fields) == 2 {
+		var err error
+		if color, err = ParseUserColor(fields[1]); err != nil {
+			ui.OnLog(ChatLog{Prefix: "error", Msg: err.Error()})
+			return
+		}
+	} else if !remembered {
+		color = ""
+	}
+
+	ui.profiles[name] = color
+	ui.UpdateProfile(name, color)
+	ui.InputBox.SetLabel(ui.UserName() + " > ")
+}
@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultQueueSize is the default buffer size of a room's Inbound, Outbound and Logs
+// channels and of its queue of posted messages.
+const DefaultQueueSize = 64
+
+// ErrQueueFull is returned when a message is posted to a room whose outbound queue is
+// full under OverflowError.
+var ErrQueueFull = errors.New("outbound queue is full, message not sent")
+
+// OverflowPolicy decides what happens to a message queued in a room when the queue is
+// full, e.g. while a burst of pasted lines is being published.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits until the queue has room again.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest queued message to make room.
+	OverflowDropOldest
+	// OverflowError rejects posted messages with ErrQueueFull. Received messages and
+	// logs are dropped instead, as there is nobody to report the error to.
+	OverflowError
+)
+
+// Overflow policy names accepted by ParseOverflowPolicy.
+const (
+	OverflowBlockName      = "block"
+	OverflowDropOldestName = "drop-oldest"
+	OverflowErrorName      = "error"
+)
+
+// ParseOverflowPolicy returns the overflow policy with the given name.
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch name {
+	case "", OverflowBlockName:
+		return OverflowBlock, nil
+	case OverflowDropOldestName:
+		return OverflowDropOldest, nil
+	case OverflowErrorName:
+		return OverflowError, nil
+	default:
+		return 0, fmt.Errorf("unsupported overflow policy %q (expected %q, %q or %q)", name, OverflowBlockName, OverflowDropOldestName, OverflowErrorName)
+	}
+}
+
+// WithQueue sets the buffer size of the room's Inbound, Outbound and Logs channels and
+// of its queue of posted messages, and the policy applied when they are full. The
+// policy covers messages posted with Post and PostAction, and events delivered to the
+// Inbound and Logs channels by the default handler. Sending on Outbound directly
+// always blocks when it is full. Sizes below 1 fall back to DefaultQueueSize.
+func WithQueue(size int, policy OverflowPolicy) RoomOption {
+	return func(cr *ChatRoom) {
+		if size < 1 {
+			size = DefaultQueueSize
+		}
+		cr.queueSize = size
+		cr.overflow = policy
+	}
+}
+
+// offer sends a value on a queue channel according to the overflow policy. It returns
+// ErrQueueFull if the value was rejected, and reports whether an older value was
+// dropped to make room. It gives up once done is closed.
+func offer[T any](queue chan T, value T, policy OverflowPolicy, done <-chan struct{}) (dropped bool, err error) {
+	switch policy {
+	case OverflowDropOldest:
+		for {
+			select {
+			case queue <- value:
+				return dropped, nil
+			default:
+			}
+			select {
+			case <-queue:
+				dropped = true
+			default:
+			}
+		}
+	case OverflowError:
+		select {
+		case queue <- value:
+			return false, nil
+		default:
+			return false, ErrQueueFull
+		}
+	default:
+		select {
+		case queue <- value:
+			return false, nil
+		case <-done:
+			return false, errors.New("left the room")
+		}
+	}
+}
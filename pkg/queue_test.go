@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOfferDropOldest(t *testing.T) {
+	queue := make(chan int, 2)
+	for _, v := range []int{1, 2} {
+		if dropped, err := offer(queue, v, OverflowDropOldest, nil); dropped || err != nil {
+			t.Fatalf("offer(%d) = %t, %v; want no drop", v, dropped, err)
+		}
+	}
+	if dropped, err := offer(queue, 3, OverflowDropOldest, nil); !dropped || err != nil {
+		t.Fatalf("offer to a full queue = %t, %v; want the oldest dropped", dropped, err)
+	}
+	if a, b := <-queue, <-queue; a != 2 || b != 3 {
+		t.Errorf("queue holds %d, %d; want 2, 3", a, b)
+	}
+}
+
+func TestOfferError(t *testing.T) {
+	queue := make(chan int, 1)
+	if _, err := offer(queue, 1, OverflowError, nil); err != nil {
+		t.Fatalf("offer: %v", err)
+	}
+	if _, err := offer(queue, 2, OverflowError, nil); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("offer to a full queue = %v, want ErrQueueFull", err)
+	}
+	if v := <-queue; v != 1 {
+		t.Errorf("queue holds %d, want 1", v)
+	}
+}
+
+func TestOfferBlock(t *testing.T) {
+	queue := make(chan int, 1)
+	queue <- 1
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := offer(queue, 2, OverflowBlock, nil)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		t.Fatalf("offer to a full queue returned %v instead of blocking", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-queue
+	if err := <-result; err != nil {
+		t.Errorf("offer: %v", err)
+	}
+
+	// Leaving the room releases a blocked offer
+	done := make(chan struct{})
+	close(done)
+	if _, err := offer(queue, 3, OverflowBlock, done); err == nil {
+		t.Error("offer to a full queue of a left room succeeded")
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for name, want := range map[string]OverflowPolicy{
+		"":                     OverflowBlock,
+		OverflowBlockName:      OverflowBlock,
+		OverflowDropOldestName: OverflowDropOldest,
+		OverflowErrorName:      OverflowError,
+	} {
+		if got, err := ParseOverflowPolicy(name); err != nil || got != want {
+			t.Errorf("ParseOverflowPolicy(%q) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseOverflowPolicy("drop-newest"); err == nil {
+		t.Error("ParseOverflowPolicy accepted an unknown policy")
+	}
+}
@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Default inbound rate limits applied to each peer.
+const (
+	DefaultRateLimit = 10 // Sustained messages per second
+	DefaultRateBurst = 20 // Messages accepted in a burst
+)
+
+// maxRateBuckets is the number of tracked peers above which idle buckets are pruned.
+const maxRateBuckets = 1024
+
+// WithRateLimit limits inbound messages from each peer to perSecond messages, allowing
+// bursts of up to burst messages. A zero rate disables the limit.
+func WithRateLimit(perSecond float64, burst int) RoomOption {
+	return func(cr *ChatRoom) {
+		cr.rateLimit = newRateLimiter(perSecond, burst)
+	}
+}
+
+// tokenBucket tracks the remaining message allowance of a single peer.
+type tokenBucket struct {
+	tokens   float64   // Messages that may still be accepted
+	last     time.Time // Time the bucket was last refilled
+	limiting bool      // Whether messages are currently being dropped
+}
+
+// rateLimiter is a per-peer token bucket rate limiter. It is only accessed from
+// the subscribeLoop goroutine.
+type rateLimiter struct {
+	rate    float64 // Tokens added per second
+	burst   float64 // Bucket capacity
+	buckets map[peer.ID]*tokenBucket
+}
+
+// newRateLimiter creates a rateLimiter, returning nil when the rate is not positive.
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:    perSecond,
+		burst:   float64(burst),
+		buckets: make(map[peer.ID]*tokenBucket),
+	}
+}
+
+// Allow reports whether a message from the peer is accepted. Buckets refill over time,
+// so a peer is never penalized beyond its current burst. The second result is true for
+// the first message dropped since the peer was last within its limit, so drops can be
+// reported once rather than per message.
+func (l *rateLimiter) Allow(id peer.ID) (bool, bool) {
+	if l == nil {
+		return true, false
+	}
+
+	now := time.Now()
+	bucket, ok := l.buckets[id]
+	if !ok {
+		if len(l.buckets) >= maxRateBuckets {
+			l.prune(now)
+		}
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[id] = bucket
+	}
+
+	// Refill the bucket for the time elapsed since the last message
+	bucket.tokens += now.Sub(bucket.last).Seconds() * l.rate
+	if bucket.tokens > l.burst {
+		bucket.tokens = l.burst
+	}
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		bucket.limiting = false
+		return true, false
+	}
+
+	first := !bucket.limiting
+	bucket.limiting = true
+	return false, first
+}
+
+// prune forgets peers whose buckets would have refilled completely.
+func (l *rateLimiter) prune(now time.Time) {
+	for id, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, id)
+		}
+	}
+}
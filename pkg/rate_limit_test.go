@@ -0,0 +1,43 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestRateLimiterBurstAndRefill(t *testing.T) {
+	l := newRateLimiter(20, 3)
+	alice, bob := peer.ID("alice"), peer.ID("bob")
+
+	for i := 0; i < 3; i++ {
+		if allowed, _ := l.Allow(alice); !allowed {
+			t.Fatalf("message %d of the burst was dropped", i+1)
+		}
+	}
+	if allowed, first := l.Allow(alice); allowed || !first {
+		t.Errorf("message beyond the burst: allowed %t, first drop %t; want dropped and reported", allowed, first)
+	}
+	if allowed, first := l.Allow(alice); allowed || first {
+		t.Errorf("second message beyond the burst: allowed %t, first drop %t; want dropped silently", allowed, first)
+	}
+	if allowed, _ := l.Allow(bob); !allowed {
+		t.Error("another peer was limited")
+	}
+
+	// One token refills every 50ms
+	time.Sleep(60 * time.Millisecond)
+	if allowed, _ := l.Allow(alice); !allowed {
+		t.Error("message after the bucket refilled was dropped")
+	}
+}
+
+func TestDisabledRateLimiter(t *testing.T) {
+	l := newRateLimiter(0, 1)
+	for i := 0; i < 100; i++ {
+		if allowed, _ := l.Allow("alice"); !allowed {
+			t.Fatal("disabled limiter dropped a message")
+		}
+	}
+}
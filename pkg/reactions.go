@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxReactionLength bounds the number of characters in a single reaction.
+const maxReactionLength = 8
+
+// maxTrackedReactions bounds the number of messages whose reactions are remembered.
+const maxTrackedReactions = 1000
+
+// newMessageID returns a random message ID.
+func newMessageID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("failed to generate message ID: %v", err))
+	}
+	return hex.EncodeToString(id)
+}
+
+// React publishes a reaction to the message with the given ID and returns it for local echo.
+func (cr *ChatRoom) React(target, reaction string) (ChatMessage, error) {
+	reaction = strings.TrimSpace(reaction)
+	if reaction == "" || utf8.RuneCountInString(reaction) > maxReactionLength {
+		return ChatMessage{}, fmt.Errorf("a reaction must be between 1 and %d characters", maxReactionLength)
+	}
+
+	chatMsg, err := cr.prepareOutbound(reaction)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	chatMsg.Type = MessageTypeReaction
+	chatMsg.Target = target
+	return chatMsg, cr.enqueue(chatMsg)
+}
+
+// reactionTracker counts the reactions to messages, each sender counting once per
+// reaction. Reactions may arrive before the message they refer to. It is only
+// accessed from the UI goroutine.
+type reactionTracker struct {
+	reactions map[string]map[string]map[string]struct{} // Message ID -> reaction -> sender IDs
+	order     []string                                  // Message IDs in the order first reacted to
+}
+
+// Add records a reaction and reports whether it changed the counts.
+func (t *reactionTracker) Add(reaction ChatMessage) bool {
+	if t.reactions == nil {
+		t.reactions = make(map[string]map[string]map[string]struct{})
+	}
+
+	byReaction, ok := t.reactions[reaction.Target]
+	if !ok {
+		// Forget the oldest message once the limit is reached
+		if len(t.order) >= maxTrackedReactions {
+			delete(t.reactions, t.order[0])
+			t.order = t.order[1:]
+		}
+		byReaction = make(map[string]map[string]struct{})
+		t.reactions[reaction.Target] = byReaction
+		t.order = append(t.order, reaction.Target)
+	}
+
+	senders, ok := byReaction[reaction.Message]
+	if !ok {
+		senders = make(map[string]struct{})
+		byReaction[reaction.Message] = senders
+	}
+	if _, ok := senders[reaction.SenderID]; ok {
+		return false
+	}
+	senders[reaction.SenderID] = struct{}{}
+	return true
+}
+
+// Summary returns the reaction counts for a message, e.g. "👍 2  🎉 1", or an empty
+// string when it has none.
+func (t *reactionTracker) Summary(id string) string {
+	byReaction := t.reactions[id]
+	if len(byReaction) == 0 {
+		return ""
+	}
+
+	reactions := make([]string, 0, len(byReaction))
+	for reaction := range byReaction {
+		reactions = append(reactions, reaction)
+	}
+	sort.Strings(reactions)
+
+	parts := make([]string, len(reactions))
+	for i, reaction := range reactions {
+		parts[i] = fmt.Sprintf("%s %d", reaction, len(byReaction[reaction]))
+	}
+	return strings.Join(parts, "  ")
+}
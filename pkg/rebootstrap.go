@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Default routing table maintenance settings.
+const (
+	DefaultRebootstrapInterval = time.Minute // Interval between routing table health checks
+	DefaultRebootstrapPeers    = 4           // Routing table size below which the DHT is re-bootstrapped
+)
+
+// validateRebootstrap checks the routing table maintenance settings.
+func (cfg HostConfig) validateRebootstrap() error {
+	if cfg.RebootstrapInterval < 0 {
+		return fmt.Errorf("re-bootstrap interval must not be negative, got %s", cfg.RebootstrapInterval)
+	}
+	if cfg.RebootstrapPeers < 0 {
+		return fmt.Errorf("re-bootstrap threshold must not be negative, got %d", cfg.RebootstrapPeers)
+	}
+	return nil
+}
+
+// maintainRoutingTable checks the size of the DHT routing table every interval and
+// re-bootstraps the DHT when it has shrunk below threshold, e.g. after the bootstrap
+// connections dropped, so discovery keeps working. It stops once the PeerNetwork
+// context is cancelled.
+func (p *PeerNetwork) maintainRoutingTable(interval time.Duration, threshold int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.Ctx.Done():
+			return
+		case <-ticker.C:
+			size := p.KadDHT.RoutingTable().Size()
+			if size >= threshold {
+				continue
+			}
+			logrus.Debugf("DHT routing table holds %d peers, below %d; re-bootstrapping", size, threshold)
+			p.rebootstrap()
+		}
+	}
+}
+
+// rebootstrap dials the bootstrap peers that are no longer connected and refreshes the
+// DHT routing table.
+func (p *PeerNetwork) rebootstrap() {
+	connected := 0
+	if len(p.bootstrapPeers) > 0 {
+		connected = connectBootstrapPeers(p.Ctx, p.Host, p.bootstrapPeers)
+	}
+	if err := p.KadDHT.Bootstrap(p.Ctx); err != nil {
+		logrus.Debugf("Failed to re-bootstrap the Kademlia DHT: %v", err)
+		return
+	}
+	logrus.Debugf("Re-bootstrapped the Kademlia DHT, connected to %d of %d bootstrap peers", connected, len(p.bootstrapPeers))
+}
@@ -0,0 +1,103 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+// RelayTag is the connection manager protection tag applied to static relays.
+const RelayTag = "peernet-relay"
+
+// staticRelays parses the configured static relay multiaddrs. Each address must
+// include a /p2p/ component, and every malformed address is reported in the returned
+// error. No relays are returned when none are configured, leaving auto-relay to
+// discover relays through the DHT.
+func (cfg HostConfig) staticRelays() ([]peer.AddrInfo, error) {
+	var relays []peer.AddrInfo
+	var errs []error
+	for _, addr := range cfg.StaticRelays {
+		multiAddr, err := multiaddr.NewMultiaddr(addr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: %w", addr, err))
+			continue
+		}
+		relayInfo, err := peer.AddrInfoFromP2pAddr(multiAddr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: %w", addr, err))
+			continue
+		}
+		relays = append(relays, *relayInfo)
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid relays: %w", errors.Join(errs...))
+	}
+	return relays, nil
+}
+
+// connectStaticRelays concurrently dials the static relays and protects the
+// connections to them, so auto-relay can use them as soon as the host turns out to
+// be behind a NAT. Relays that cannot be reached are logged and retried by auto-relay.
+func connectStaticRelays(ctx context.Context, nodeHost host.Host, relays []peer.AddrInfo) {
+	var wg sync.WaitGroup
+	for _, relayInfo := range relays {
+		wg.Add(1)
+		go func(relayInfo peer.AddrInfo) {
+			defer wg.Done()
+			if err := nodeHost.Connect(ctx, relayInfo); err != nil {
+				logrus.Warnf("Failed to connect to relay %s: %v", relayInfo.ID, err)
+				return
+			}
+			nodeHost.ConnManager().Protect(relayInfo.ID, RelayTag)
+			logrus.Infof("Connected to relay %s", relayInfo.ID)
+		}(relayInfo)
+	}
+	wg.Wait()
+}
+
+// logRelayAddrs logs the relay addresses the host is reachable through as auto-relay
+// adds and removes them, until the context is cancelled.
+func logRelayAddrs(ctx context.Context, nodeHost host.Host) {
+	sub, err := nodeHost.EventBus().Subscribe(new(event.EvtLocalAddressesUpdated))
+	if err != nil {
+		logrus.Debugf("Failed to watch the host addresses: %v", err)
+		return
+	}
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			update := evt.(event.EvtLocalAddressesUpdated)
+			for _, addr := range update.Current {
+				if addr.Action == event.Added && isRelayAddr(addr.Address) {
+					logrus.Infof("Reachable through relay address %s", addr.Address)
+				}
+			}
+			for _, addr := range update.Removed {
+				if isRelayAddr(addr.Address) {
+					logrus.Infof("No longer reachable through relay address %s", addr.Address)
+				}
+			}
+		}
+	}
+}
+
+// isRelayAddr reports whether an address goes through a circuit relay.
+func isRelayAddr(addr multiaddr.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT)
+	return err == nil
+}
@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// errNotEncrypted is returned when decrypting a frame that was published in plaintext.
+var errNotEncrypted = errors.New("message is not encrypted")
+
+// encryptedFrame wraps a serialized PubSub frame sealed with the room key.
+type encryptedFrame struct {
+	Encrypted  bool   `json:"encrypted"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// WithRoomKey encrypts the room's messages end-to-end with a key derived from the
+// passphrase. Only peers that joined with the same passphrase can read them.
+// An empty passphrase leaves the room unencrypted. It replaces a key given earlier
+// with WithRawRoomKey.
+func WithRoomKey(passphrase string) RoomOption {
+	return func(cr *ChatRoom) {
+		cr.roomPassphrase = passphrase
+		cr.roomKey = nil
+	}
+}
+
+// WithRawRoomKey encrypts the room's messages end-to-end with the given key, e.g. one
+// imported with /importkey, instead of deriving it from a passphrase. It replaces a
+// passphrase given earlier with WithRoomKey.
+func WithRawRoomKey(key []byte) RoomOption {
+	return func(cr *ChatRoom) {
+		cr.roomKey = key
+		cr.roomPassphrase = ""
+	}
+}
+
+// deriveRoomKey derives the room key from a passphrase using Argon2id. The salt is
+// derived from the room name so every peer in the room arrives at the same key.
+func deriveRoomKey(passphrase, roomName string) []byte {
+	salt := sha256.Sum256([]byte(SERVICE + "-room:" + roomName))
+	return argon2.IDKey([]byte(passphrase), salt[:], roomKeyTime, roomKeyMemory, roomKeyThreads, roomKeySize)
+}
+
+// newRoomCipher returns the AES-GCM cipher for a room key.
+func newRoomCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != roomKeySize {
+		return nil, fmt.Errorf("room key must be %d bytes, got %d", roomKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptFrame seals a serialized frame with the room cipher.
+func encryptFrame(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return json.Marshal(encryptedFrame{
+		Encrypted:  true,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, nil),
+	})
+}
+
+// isEncryptedFrame reports whether the data is an encrypted frame envelope.
+func isEncryptedFrame(data []byte) bool {
+	var frame encryptedFrame
+	return json.Unmarshal(data, &frame) == nil && frame.Encrypted
+}
+
+// decryptFrame opens an encrypted frame with the room cipher. It returns errNotEncrypted
+// for plaintext frames.
+func decryptFrame(aead cipher.AEAD, data []byte) ([]byte, error) {
+	var frame encryptedFrame
+	if err := json.Unmarshal(data, &frame); err != nil || !frame.Encrypted {
+		return nil, errNotEncrypted
+	}
+	if len(frame.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+
+	plaintext, err := aead.Open(nil, frame.Nonce, frame.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("wrong room key or corrupted message")
+	}
+	return plaintext, nil
+}
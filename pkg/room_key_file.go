@@ -0,0 +1,188 @@
+package pkg
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// roomKeySize is the size in bytes of a room key.
+const roomKeySize = 32
+
+// Argon2id parameters used to derive keys from passphrases.
+const (
+	roomKeyTime    = 1
+	roomKeyMemory  = 64 * 1024
+	roomKeyThreads = 4
+)
+
+// roomKeyFileFormat identifies the format of files written by /exportkey.
+const roomKeyFileFormat = "peernet-room-key/1"
+
+// roomKeyFileSaltSize is the size in bytes of the random salt of a room key file.
+const roomKeyFileSaltSize = 16
+
+// ErrWrongKeyPassphrase is returned when a room key file cannot be opened with the
+// given passphrase.
+var ErrWrongKeyPassphrase = errors.New("wrong passphrase or corrupted key file")
+
+// roomKeyFile is a room key wrapped with a passphrase, as written by /exportkey. The
+// room name is authenticated along with the key, so it cannot be changed unnoticed.
+type roomKeyFile struct {
+	Format     string `json:"format"`
+	Room       string `json:"room"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// wrapCipher derives the cipher wrapping a room key from a passphrase and salt.
+func wrapCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key := argon2.IDKey([]byte(passphrase), salt, roomKeyTime, roomKeyMemory, roomKeyThreads, roomKeySize)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrapRoomKey seals the key of a room with a passphrase and returns the key file.
+func wrapRoomKey(room string, key []byte, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	salt := make([]byte, roomKeyFileSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := wrapCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(roomKeyFile{
+		Format:     roomKeyFileFormat,
+		Room:       room,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, key, []byte(room)),
+	}, "", "  ")
+}
+
+// unwrapRoomKey opens a key file written by wrapRoomKey and returns the room name and
+// key. It returns ErrWrongKeyPassphrase if the passphrase does not open the file.
+func unwrapRoomKey(data []byte, passphrase string) (string, []byte, error) {
+	var file roomKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return "", nil, errors.New("not a room key file")
+	}
+	if file.Format != roomKeyFileFormat {
+		return "", nil, fmt.Errorf("unsupported key file format %q", file.Format)
+	}
+	if _, err := NormalizeRoomName(file.Room); err != nil {
+		return "", nil, fmt.Errorf("invalid room in key file: %w", err)
+	}
+	if len(file.Salt) != roomKeyFileSaltSize {
+		return "", nil, errors.New("invalid salt in key file")
+	}
+
+	aead, err := wrapCipher(passphrase, file.Salt)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(file.Nonce) != aead.NonceSize() {
+		return "", nil, errors.New("invalid nonce in key file")
+	}
+	key, err := aead.Open(nil, file.Nonce, file.Ciphertext, []byte(file.Room))
+	if err != nil {
+		return "", nil, ErrWrongKeyPassphrase
+	}
+	if len(key) != roomKeySize {
+		return "", nil, errors.New("invalid room key in key file")
+	}
+	return file.Room, key, nil
+}
+
+// cmdExportKey writes the key of the current room to a new file, wrapped with a
+// passphrase. Existing files are never overwritten.
+func (ui *UI) cmdExportKey(arg string) {
+	path, passphrase, _ := strings.Cut(arg, " ")
+	if path == "" || passphrase == "" {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: "usage: /exportkey <path> <passphrase>"})
+		return
+	}
+	cr := ui.CurrentRoom()
+	if cr.roomKey == nil {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("room '%s' is not encrypted", cr.RoomName)})
+		return
+	}
+
+	go func() {
+		data, err := wrapRoomKey(cr.RoomName, cr.roomKey, passphrase)
+		if err == nil {
+			err = writeNewFile(path, data)
+		}
+		if err != nil {
+			ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("could not export the room key: %s", err)})
+			return
+		}
+		ui.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("exported the key of room '%s' to %s", cr.RoomName, path)})
+	}()
+}
+
+// cmdImportKey opens a room key file with its passphrase and joins the room with the
+// key in a new tab.
+func (ui *UI) cmdImportKey(arg string) {
+	path, passphrase, _ := strings.Cut(arg, " ")
+	if path == "" || passphrase == "" {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: "usage: /importkey <path> <passphrase>"})
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("could not import the room key: %s", err)})
+		return
+	}
+	room, key, err := unwrapRoomKey(data, passphrase)
+	if err != nil {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("could not import the room key: %s", err)})
+		return
+	}
+	if _, ok := ui.findRoom(room); ok {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("room '%s' is already open, /leave it before importing its key", room)})
+		return
+	}
+	ui.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("imported the key of room '%s'", room)})
+	ui.joinRoom(room, WithRawRoomKey(key))
+}
+
+// writeNewFile writes data to a new file readable only by the user, refusing to
+// overwrite an existing file.
+func writeNewFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%s already exists", path)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
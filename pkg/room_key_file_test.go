@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// newTestRoomKey returns a random room key.
+func newTestRoomKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, roomKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestWrapRoomKeyRoundTrip(t *testing.T) {
+	key := newTestRoomKey(t)
+	data, err := wrapRoomKey("dev", key, "transfer secret")
+	if err != nil {
+		t.Fatalf("wrapRoomKey: %v", err)
+	}
+	if bytes.Contains(data, key) {
+		t.Fatal("key file contains the raw room key")
+	}
+
+	room, got, err := unwrapRoomKey(data, "transfer secret")
+	if err != nil {
+		t.Fatalf("unwrapRoomKey: %v", err)
+	}
+	if room != "dev" || !bytes.Equal(got, key) {
+		t.Fatalf("unwrapRoomKey = %q, %x, want %q, %x", room, got, "dev", key)
+	}
+}
+
+func TestUnwrapRoomKeyWrongPassphrase(t *testing.T) {
+	data, err := wrapRoomKey("dev", newTestRoomKey(t), "transfer secret")
+	if err != nil {
+		t.Fatalf("wrapRoomKey: %v", err)
+	}
+	if _, _, err := unwrapRoomKey(data, "guess"); !errors.Is(err, ErrWrongKeyPassphrase) {
+		t.Fatalf("unwrapRoomKey with a wrong passphrase = %v, want ErrWrongKeyPassphrase", err)
+	}
+}
+
+func TestUnwrapRoomKeyInvalidFile(t *testing.T) {
+	data, err := wrapRoomKey("dev", newTestRoomKey(t), "transfer secret")
+	if err != nil {
+		t.Fatalf("wrapRoomKey: %v", err)
+	}
+	var file roomKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*roomKeyFile)
+	}{
+		{"format", func(f *roomKeyFile) { f.Format = "peernet-room-key/0" }},
+		{"room", func(f *roomKeyFile) { f.Room = "ops" }},
+		{"invalid room", func(f *roomKeyFile) { f.Room = "a/b" }},
+		{"salt", func(f *roomKeyFile) { f.Salt = f.Salt[:4] }},
+		{"nonce", func(f *roomKeyFile) { f.Nonce = nil }},
+		{"ciphertext", func(f *roomKeyFile) { f.Ciphertext[0] ^= 1 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modified := file
+			modified.Salt = append([]byte(nil), file.Salt...)
+			modified.Ciphertext = append([]byte(nil), file.Ciphertext...)
+			tt.modify(&modified)
+			data, err := json.Marshal(modified)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := unwrapRoomKey(data, "transfer secret"); err == nil {
+				t.Fatal("unwrapRoomKey accepted a modified key file")
+			}
+		})
+	}
+
+	if _, _, err := unwrapRoomKey([]byte("not json"), "transfer secret"); err == nil {
+		t.Fatal("unwrapRoomKey accepted a file that is not JSON")
+	}
+}
+
+func TestWrapRoomKeyEmptyPassphrase(t *testing.T) {
+	if _, err := wrapRoomKey("dev", newTestRoomKey(t), ""); err == nil {
+		t.Fatal("wrapRoomKey accepted an empty passphrase")
+	}
+}
@@ -0,0 +1,388 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rivo/tview"
+)
+
+// maxTranscriptLines bounds the number of lines kept for each room.
+const maxTranscriptLines = 1000
+
+// transcriptLine is a line of a room's message box, tied to the chat message it shows, if any.
+type transcriptLine struct {
+	text     string
+	msgID    string
+	directID string        // ID of the sent direct message the line shows, if any
+	delivery deliveryState // Delivery state of the direct message
+	entry    *exportEntry  // Message the line shows, written by /export; nil for other lines
+}
+
+// deliveryState is the delivery state of a sent direct message.
+type deliveryState int
+
+const (
+	deliveryPending deliveryState = iota
+	deliveryAcked
+	deliveryFailed
+	deliveryQueued
+)
+
+// marker returns the mark shown after a direct message in the given delivery state.
+func (s deliveryState) marker() string {
+	switch s {
+	case deliveryAcked:
+		return "[green]✓[-]"
+	case deliveryFailed:
+		return "[red]✗ not delivered[-]"
+	case deliveryQueued:
+		return "[yellow]⧗ queued until the peer is back[-]"
+	default:
+		return "[gray]…[-]"
+	}
+}
+
+// roomView holds the UI state of a joined room and receives the room's events.
+// Apart from room, its fields are only accessed from the UI goroutine.
+type roomView struct {
+	ui   *UI
+	room atomic.Pointer[ChatRoom] // Room currently shown in this view
+
+	name       string           // Room name shown in the tab bar
+	transcript []transcriptLine // Lines of the message box, oldest first
+	reactions  reactionTracker  // Reactions to the room's messages
+	unread     int              // Messages received while the view was inactive
+}
+
+// newRoomView creates a view for a room.
+func newRoomView(ui *UI, cr *ChatRoom) *roomView {
+	v := &roomView{ui: ui, name: cr.RoomName}
+	v.room.Store(cr)
+	return v
+}
+
+// forward delivers events from a room's channels to the view until the room is left.
+// It is used for rooms joined without a handler.
+func (v *roomView) forward(cr *ChatRoom) {
+	for {
+		select {
+		case msg, ok := <-cr.Inbound:
+			if !ok {
+				return
+			}
+			v.OnMessage(msg)
+		case log := <-cr.Logs:
+			v.OnLog(log)
+		case <-cr.psCtx.Done():
+			return
+		}
+	}
+}
+
+// OnMessage implements Handler by rendering a room message or reaction.
+func (v *roomView) OnMessage(msg ChatMessage) {
+	v.ui.App.QueueUpdateDraw(func() {
+		v.showMessage(msg)
+	})
+}
+
+// OnLog implements Handler by rendering a log message.
+func (v *roomView) OnLog(log ChatLog) {
+	v.ui.App.QueueUpdateDraw(func() {
+		v.writeLine(logLine(log), "")
+	})
+}
+
+// OnPeerJoin implements Handler by announcing the peer and refreshing the peer list.
+func (v *roomView) OnPeerJoin(id peer.ID) {
+	v.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("%s joined", v.room.Load().displayName(id))})
+	v.ui.updatePeerBox()
+}
+
+// OnPeerLeave implements Handler by announcing the peer and refreshing the peer list.
+func (v *roomView) OnPeerLeave(id peer.ID) {
+	v.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("%s left", v.room.Load().displayName(id))})
+	v.ui.updatePeerBox()
+}
+
+// showMessage renders a message from another peer, or updates the reaction counts of
+// the message a reaction refers to. Messages arriving while the view is inactive are
+// counted as unread, and messages from ignored peers are not shown.
+func (v *roomView) showMessage(msg ChatMessage) {
+	if v.ui.ignored.Contains(msg.SenderID) {
+		return
+	}
+	if msg.Type == MessageTypeReaction {
+		if v.reactions.Add(msg) && v.active() && v.showsMessage(msg.Target) {
+			v.ui.redraw()
+		}
+		return
+	}
+
+	v.writeChatMessage(msg, senderColor(msg, v.ui.currentTheme().peerColor(msg.SenderID)))
+	if msg.Impersonates != "" {
+		v.writeLine(fmt.Sprintf("[yellow](impersonation?)[-] the name '%s' is already used by %s", tview.Escape(msg.SenderName), shortID(msg.Impersonates)), "")
+	}
+	v.ui.notifyMention(v.room.Load(), msg)
+	if !v.active() {
+		v.unread++
+		v.ui.renderTabs()
+	}
+}
+
+// active reports whether the view is shown in the message box.
+func (v *roomView) active() bool {
+	return v.ui.active == v
+}
+
+// writeMessage appends a single message line to the transcript. The sender and message
+// text are escaped so they cannot inject color tags or regions.
+func (v *roomView) writeMessage(sender, message string, timestamp time.Time, color tcell.Color, msgID string) {
+	v.appendLine(transcriptLine{
+		text:  messageLine(sender, message, timestamp, color),
+		msgID: msgID,
+		entry: &exportEntry{Time: timestamp, Sender: sender, Message: message},
+	})
+}
+
+// messageLine formats a message as a transcript line.
+func messageLine(sender, message string, timestamp time.Time, color tcell.Color) string {
+	return fmt.Sprintf("[gray]%s[-] [%s]<%s>[-] %s", timestamp.Local().Format("15:04:05"), color, tview.Escape(sender), tview.Escape(message))
+}
+
+// writeAction appends a /me action line to the transcript, e.g. "* alice waves". The
+// sender and action text are escaped like those of a message.
+func (v *roomView) writeAction(sender, action string, timestamp time.Time, color tcell.Color, msgID string) {
+	v.appendLine(transcriptLine{
+		text:  fmt.Sprintf("[gray]%s[-] [%s]* %s[-] [::i]%s[::-]", timestamp.Local().Format("15:04:05"), color, tview.Escape(sender), tview.Escape(action)),
+		msgID: msgID,
+		entry: &exportEntry{Time: timestamp, Sender: sender, Message: action, Action: true},
+	})
+}
+
+// writeChatMessage appends a room message or action to the transcript.
+func (v *roomView) writeChatMessage(msg ChatMessage, color tcell.Color) {
+	if msg.Type == MessageTypeAction {
+		v.writeAction(msg.SenderName, msg.Message, msg.Timestamp, color, msg.ID)
+		return
+	}
+	v.writeMessage(msg.SenderName, msg.Message, msg.Timestamp, color, msg.ID)
+}
+
+// logLine formats a log message as a transcript line. Log messages quote usernames,
+// file names and errors coming from peers, so they are escaped.
+func logLine(log ChatLog) string {
+	return fmt.Sprintf("[red](%s)[-] %s", tview.Escape(log.Prefix), tview.Escape(log.Msg))
+}
+
+// writeLine appends a line to the transcript, writing it to the message box when the
+// view is active. The message box only follows the new line if it was scrolled to the bottom.
+func (v *roomView) writeLine(text, msgID string) {
+	v.appendLine(transcriptLine{text: text, msgID: msgID})
+}
+
+// writeDirect appends a sent direct message to the transcript, followed by its
+// delivery state.
+func (v *roomView) writeDirect(sender, message string, timestamp time.Time, color tcell.Color, directID string) {
+	v.appendLine(transcriptLine{
+		text:     messageLine(sender, message, timestamp, color),
+		directID: directID,
+		entry:    &exportEntry{Time: timestamp, Sender: sender, Message: message},
+	})
+}
+
+// appendLine appends a line to the transcript like writeLine.
+func (v *roomView) appendLine(line transcriptLine) {
+	v.transcript = append(v.transcript, line)
+	if len(v.transcript) > maxTranscriptLines {
+		// Drop a tenth of the lines at once so trimming rarely forces a redraw
+		v.transcript = append([]transcriptLine(nil), v.transcript[maxTranscriptLines/10:]...)
+		if v.active() {
+			v.ui.redraw()
+		}
+		return
+	}
+
+	if v.active() {
+		v.ui.renderLine(v, v.transcript[len(v.transcript)-1])
+	}
+}
+
+// renderBacklog appends a room's replayed history to the transcript.
+func (v *roomView) renderBacklog(cr *ChatRoom) {
+	for _, msg := range cr.Backlog() {
+		color := v.ui.currentTheme().peerColor(msg.SenderID)
+		if msg.SenderID == cr.selfID.Pretty() {
+			color = v.ui.currentTheme().Self
+		}
+		v.writeChatMessage(msg, senderColor(msg, color))
+	}
+}
+
+// reset empties the view for a newly joined room.
+func (v *roomView) reset(name string) {
+	v.name = name
+	v.transcript = nil
+	v.reactions = reactionTracker{}
+	v.unread = 0
+}
+
+// setDelivery updates the delivery state shown for a sent direct message, reporting
+// whether the message is in the transcript.
+func (v *roomView) setDelivery(directID string, state deliveryState) bool {
+	for i := len(v.transcript) - 1; i >= 0; i-- {
+		if v.transcript[i].directID == directID {
+			v.transcript[i].delivery = state
+			return true
+		}
+	}
+	return false
+}
+
+// showsMessage reports whether a message is in the transcript.
+func (v *roomView) showsMessage(msgID string) bool {
+	for _, line := range v.transcript {
+		if line.msgID == msgID {
+			return true
+		}
+	}
+	return false
+}
+
+// recentMessageID returns the ID of the n-th most recent room message in the
+// transcript, counting from 1.
+func (v *roomView) recentMessageID(n int) (string, bool) {
+	for i := len(v.transcript) - 1; i >= 0; i-- {
+		if v.transcript[i].msgID == "" {
+			continue
+		}
+		if n--; n == 0 {
+			return v.transcript[i].msgID, true
+		}
+	}
+	return "", false
+}
+
+// findRoom returns the view of a joined room by name. It must be called from the event loop.
+func (ui *UI) findRoom(name string) (*roomView, bool) {
+	for _, v := range ui.rooms {
+		if v.room.Load().RoomName == name {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// activate shows a joined room in the message box and makes it the target of sent
+// messages and commands. It must be called from the event loop.
+func (ui *UI) activate(v *roomView) {
+	ui.current = v
+	ui.ChatRoom = v.room.Load()
+	ui.currentRoom.Store(ui.ChatRoom)
+	tabs := append([]*roomView(nil), ui.rooms...)
+
+	ui.App.QueueUpdateDraw(func() {
+		ui.active = v
+		ui.tabs = tabs
+		v.unread = 0
+		ui.MessageBox.SetTitle(fmt.Sprintf("ChatRoom-%s", tview.Escape(v.name)))
+		ui.redraw()
+		ui.MessageBox.ScrollToEnd()
+		ui.renderTabs()
+	})
+	ui.updatePeerBox()
+}
+
+// joinRoom joins another room alongside the current ones and switches to it, encrypted
+// with the room key option. Joining a room that is already open switches to it instead.
+func (ui *UI) joinRoom(roomName string, key RoomOption) {
+	roomName, err := NormalizeRoomName(roomName)
+	if err != nil {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("could not join room: %s", err)})
+		return
+	}
+	if v, ok := ui.findRoom(roomName); ok {
+		ui.activate(v)
+		return
+	}
+
+	v := &roomView{ui: ui, name: roomName}
+	opts := append(append([]RoomOption(nil), ui.ChatRoom.opts...), key, WithUserColor(ui.UserColor), WithHandler(v))
+	cr, err := JoinChatRoom(ui.Host, ui.UserName(), roomName, opts...)
+	if err != nil {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("could not join room: %s", err)})
+		return
+	}
+	v.room.Store(cr)
+	ui.rooms = append(ui.rooms, v)
+
+	ui.App.QueueUpdateDraw(func() {
+		v.renderBacklog(cr)
+	})
+	ui.activate(v)
+}
+
+// leaveRoom leaves the current room and switches to the previous tab. The last
+// open room cannot be left.
+func (ui *UI) leaveRoom() {
+	if len(ui.rooms) == 1 {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: "cannot leave the last room, use /exit instead"})
+		return
+	}
+
+	index := ui.roomIndex(ui.current)
+	left := ui.current
+	ui.rooms = append(ui.rooms[:index:index], ui.rooms[index+1:]...)
+	if index > 0 {
+		index--
+	}
+	ui.activate(ui.rooms[index])
+	// Leaving flushes the room's queue, which must not block the event loop
+	go left.room.Load().Exit()
+}
+
+// cycleRoom switches to the next tab, wrapping around.
+func (ui *UI) cycleRoom() {
+	ui.activate(ui.rooms[(ui.roomIndex(ui.current)+1)%len(ui.rooms)])
+}
+
+// roomIndex returns the tab position of a view.
+func (ui *UI) roomIndex(v *roomView) int {
+	for i, room := range ui.rooms {
+		if room == v {
+			return i
+		}
+	}
+	return 0
+}
+
+// Rooms returns every room joined through the UI.
+func (ui *UI) Rooms() []*ChatRoom {
+	rooms := make([]*ChatRoom, len(ui.rooms))
+	for i, v := range ui.rooms {
+		rooms[i] = v.room.Load()
+	}
+	return rooms
+}
+
+// renderTabs redraws the tab bar, highlighting the active room and showing unread
+// counts for the others. It must be called from the UI goroutine.
+func (ui *UI) renderTabs() {
+	var tabs []string
+	for _, v := range ui.tabs {
+		switch {
+		case v == ui.active:
+			tabs = append(tabs, fmt.Sprintf("[black:green] %s [-:-]", tview.Escape(v.name)))
+		case v.unread > 0:
+			tabs = append(tabs, fmt.Sprintf(" %s [yellow](%d)[-] ", tview.Escape(v.name), v.unread))
+		default:
+			tabs = append(tabs, fmt.Sprintf(" %s ", tview.Escape(v.name)))
+		}
+	}
+	ui.TabBar.SetText(strings.Join(tabs, "|"))
+}
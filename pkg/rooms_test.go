@@ -0,0 +1,39 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// renderedText returns the text a message box shows for a transcript line, with the
+// color tags it interprets removed.
+func renderedText(line string) string {
+	box := tview.NewTextView().SetDynamicColors(true).SetRegions(true)
+	box.SetText(line)
+	return box.GetText(true)
+}
+
+func TestMessageLineEscapesPeerContent(t *testing.T) {
+	sender := "[red]mallory[-]"
+	message := `[:-:]hello ["region"] [yellow::b]bold[-::-]`
+	timestamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+
+	v := &roomView{ui: &UI{}}
+	v.writeMessage(sender, message, timestamp, tcell.ColorGreen, "")
+	got := renderedText(v.transcript[0].text)
+	want := "03:04:05 <" + sender + "> " + message
+	if got != want {
+		t.Errorf("message renders as %q, want %q", got, want)
+	}
+}
+
+func TestLogLineEscapesPeerContent(t *testing.T) {
+	log := ChatLog{Prefix: "info", Msg: "[red]mallory[-] joined"}
+	if got := renderedText(logLine(log)); !strings.HasSuffix(got, log.Msg) {
+		t.Errorf("log renders as %q, want it to end with %q", got, log.Msg)
+	}
+}
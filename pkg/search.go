@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rivo/tview"
+)
+
+// parseSearch compiles a /search argument. A term is matched literally and ignoring
+// case, while /pattern/ is a regular expression matched as given.
+func parseSearch(arg string) (*regexp.Regexp, error) {
+	if len(arg) > 2 && strings.HasPrefix(arg, "/") && strings.HasSuffix(arg, "/") {
+		re, err := regexp.Compile(arg[1 : len(arg)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %w", err)
+		}
+		return re, nil
+	}
+	return regexp.Compile("(?i)" + regexp.QuoteMeta(arg))
+}
+
+// highlightMatches escapes a message for the message box and highlights every match.
+func highlightMatches(re *regexp.Regexp, text string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringIndex(text, -1) {
+		if loc[0] == loc[1] {
+			continue
+		}
+		b.WriteString(tview.Escape(text[last:loc[0]]))
+		b.WriteString("[black:yellow]" + tview.Escape(text[loc[0]:loc[1]]) + "[-:-]")
+		last = loc[1]
+	}
+	b.WriteString(tview.Escape(text[last:]))
+	return b.String()
+}
+
+// cmdSearch lists the messages of the current room that match a term, with the
+// matches highlighted. Only the messages kept in the message box are searched.
+func (ui *UI) cmdSearch(arg string) {
+	if arg == "" {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: "usage: /search <term> or /search /regex/"})
+		return
+	}
+	re, err := parseSearch(arg)
+	if err != nil {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: err.Error()})
+		return
+	}
+
+	ui.App.QueueUpdateDraw(func() {
+		var matches []exportEntry
+		for _, line := range ui.active.transcript {
+			if line.entry != nil && re.MatchString(line.entry.Message) {
+				matches = append(matches, *line.entry)
+			}
+		}
+
+		if len(matches) == 0 {
+			ui.writeLine(fmt.Sprintf("[red](search)[-] no messages match %s", tview.Escape(arg)), "")
+			ui.MessageBox.ScrollToEnd()
+			return
+		}
+		ui.writeLine(fmt.Sprintf("[red](search)[-] %d messages match %s", len(matches), tview.Escape(arg)), "")
+		for _, match := range matches {
+			ui.writeLine(fmt.Sprintf("  [gray]%s[-] <%s> %s", match.Time.Local().Format("2006-01-02 15:04:05"), tview.Escape(match.Sender), highlightMatches(re, match.Message)), "")
+		}
+		ui.writeLine("[red](search)[-] end of results", "")
+		ui.MessageBox.ScrollToEnd()
+	})
+}
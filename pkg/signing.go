@@ -0,0 +1,66 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// signedFrameVersion is the current version of the signed frame envelope.
+const signedFrameVersion = 1
+
+// signedFrame wraps a serialized PubSub frame with its author's public key and signature.
+// Newer versions may add fields; any version carrying a valid signature is accepted.
+type signedFrame struct {
+	Version   int    `json:"version"`
+	Payload   []byte `json:"payload"`
+	PublicKey []byte `json:"publickey"`
+	Signature []byte `json:"signature"`
+}
+
+// signFrame signs a serialized frame with the given private key and returns the envelope bytes.
+func signFrame(prvKey crypto.PrivKey, payload []byte) ([]byte, error) {
+	signature, err := prvKey.Sign(payload)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := crypto.MarshalPublicKey(prvKey.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(signedFrame{
+		Version:   signedFrameVersion,
+		Payload:   payload,
+		PublicKey: publicKey,
+		Signature: signature,
+	})
+}
+
+// verifyFrame checks that the envelope was signed by the given author and returns its payload.
+func verifyFrame(data []byte, author peer.ID) ([]byte, error) {
+	var frame signedFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return nil, fmt.Errorf("malformed envelope: %w", err)
+	}
+	if frame.Version < 1 || len(frame.Signature) == 0 {
+		return nil, errors.New("message is not signed")
+	}
+
+	publicKey, err := crypto.UnmarshalPublicKey(frame.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if !author.MatchesPublicKey(publicKey) {
+		return nil, errors.New("public key does not belong to the sender")
+	}
+
+	valid, err := publicKey.Verify(frame.Payload, frame.Signature)
+	if err != nil || !valid {
+		return nil, errors.New("invalid signature")
+	}
+	return frame.Payload, nil
+}
@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rivo/tview"
+)
+
+// SessionStats is a snapshot of the node's activity since it started.
+type SessionStats struct {
+	Uptime           time.Duration // Time since the host started
+	MessagesSent     uint64        // Chat messages published to any room
+	MessagesReceived uint64        // Chat messages received in any room
+	Peers            int           // Connected peers
+	RoutingTableSize int           // Peers in the Kademlia DHT routing table
+	BytesIn          int64         // Bytes received by the host
+	BytesOut         int64         // Bytes sent by the host
+}
+
+// SessionStats returns a snapshot of the node's activity since it started.
+func (p *PeerNetwork) SessionStats() SessionStats {
+	totals := p.bandwidth.GetBandwidthTotals()
+	return SessionStats{
+		Uptime:           time.Since(p.started),
+		MessagesSent:     p.messagesSent.Load(),
+		MessagesReceived: p.messagesReceived.Load(),
+		Peers:            len(p.Host.Network().Peers()),
+		RoutingTableSize: p.KadDHT.RoutingTable().Size(),
+		BytesIn:          totals.TotalIn,
+		BytesOut:         totals.TotalOut,
+	}
+}
+
+// showStats writes the session and network statistics to the message box.
+func (ui *UI) showStats(string) {
+	stats := ui.Host.SessionStats()
+	room, roomPeers := ui.RoomName, len(ui.PeerList())
+
+	ui.App.QueueUpdateDraw(func() {
+		ui.writeLine("[red](stats)[-] this session", "")
+		for _, row := range [][2]string{
+			{"uptime", stats.Uptime.Round(time.Second).String()},
+			{"room", fmt.Sprintf("%s (%d peers)", tview.Escape(room), roomPeers)},
+			{"peers", fmt.Sprintf("%d connected", stats.Peers)},
+			{"messages", fmt.Sprintf("%d sent, %d received", stats.MessagesSent, stats.MessagesReceived)},
+			{"traffic", fmt.Sprintf("%s in, %s out", formatBytes(stats.BytesIn), formatBytes(stats.BytesOut))},
+			{"DHT", fmt.Sprintf("%d peers in the routing table", stats.RoutingTableSize)},
+		} {
+			ui.writeLine(fmt.Sprintf("  [yellow]%-9s[-] %s", row[0], row[1]), "")
+		}
+		ui.MessageBox.ScrollToEnd()
+	})
+}
+
+// formatBytes formats a byte count with a binary unit, e.g. "1.5 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// builtinProtocols are the stream protocols PeerNet handles itself.
+var builtinProtocols = []protocol.ID{DirectMessageProtocol, FileTransferProtocol}
+
+// SetStreamHandler registers the handler for inbound streams of a protocol, replacing
+// any handler registered for it before. Features attach their protocols through it
+// rather than through the host directly. The protocols PeerNet handles itself cannot
+// be taken over.
+func (p *PeerNetwork) SetStreamHandler(id protocol.ID, handler network.StreamHandler) error {
+	if err := checkBuiltinProtocol(id); err != nil {
+		return err
+	}
+	return p.setStreamHandler(id, handler)
+}
+
+// setStreamHandler registers the handler for inbound streams of a protocol, builtin
+// protocols included.
+func (p *PeerNetwork) setStreamHandler(id protocol.ID, handler network.StreamHandler) error {
+	if err := checkStreamHandler(id, handler); err != nil {
+		return err
+	}
+	p.Host.SetStreamHandler(id, handler)
+	logrus.Debugf("Registered the stream handler for %s", id)
+	return nil
+}
+
+// RemoveStreamHandler unregisters the handler for inbound streams of a protocol.
+func (p *PeerNetwork) RemoveStreamHandler(id protocol.ID) {
+	p.Host.RemoveStreamHandler(id)
+}
+
+// checkStreamHandler checks a stream handler before it is registered.
+func checkStreamHandler(id protocol.ID, handler network.StreamHandler) error {
+	if id == "" {
+		return errors.New("stream protocol ID must not be empty")
+	}
+	if handler == nil {
+		return fmt.Errorf("stream handler for %s must not be nil", id)
+	}
+	return nil
+}
+
+// checkBuiltinProtocol rejects the protocols PeerNet handles itself.
+func checkBuiltinProtocol(id protocol.ID) error {
+	for _, builtin := range builtinProtocols {
+		if id == builtin {
+			return fmt.Errorf("stream protocol %s is handled by PeerNet itself", id)
+		}
+	}
+	return nil
+}
+
+// validateStreamHandlers checks the stream handlers to register at construction time.
+// The protocols PeerNet handles itself cannot be taken over.
+func (cfg HostConfig) validateStreamHandlers() error {
+	var errs []error
+	for id, handler := range cfg.StreamHandlers {
+		if err := checkStreamHandler(id, handler); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := checkBuiltinProtocol(id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
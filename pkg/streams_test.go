@@ -0,0 +1,27 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+func TestSetStreamHandlerRejectsBuiltinProtocols(t *testing.T) {
+	p := &PeerNetwork{}
+	handler := func(network.Stream) {}
+	for _, id := range builtinProtocols {
+		if err := p.SetStreamHandler(id, handler); err == nil {
+			t.Errorf("SetStreamHandler(%s) succeeded, want an error", id)
+		}
+	}
+}
+
+func TestValidateStreamHandlersRejectsBuiltinProtocols(t *testing.T) {
+	cfg := HostConfig{StreamHandlers: map[protocol.ID]network.StreamHandler{
+		DirectMessageProtocol: func(network.Stream) {},
+	}}
+	if err := cfg.validateStreamHandlers(); err == nil {
+		t.Error("validateStreamHandlers accepted a builtin protocol")
+	}
+}
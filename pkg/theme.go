@@ -0,0 +1,157 @@
+package pkg
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Theme holds the colors of the chat UI.
+type Theme struct {
+	Background tcell.Color // Background of every widget
+	Text       tcell.Color // Plain text
+	Border     tcell.Color // Widget borders
+	Title      tcell.Color // Widget titles
+	Label      tcell.Color // Input field label
+	Self       tcell.Color // Sender of our own messages
+	Peer       tcell.Color // Sender of messages from peers, when Palette is empty
+	Direct     tcell.Color // Sender of direct messages
+	Active     tcell.Color // Peers that chatted recently
+	Idle       tcell.Color // Peers that have been silent
+
+	Palette []tcell.Color // Sender colors of peers, picked by hashing the peer ID
+}
+
+// DefaultTheme is the name of the theme used unless another is selected.
+const DefaultTheme = "dark"
+
+// Themes are the selectable themes by name.
+var Themes = map[string]Theme{
+	"dark": {
+		Background: tcell.ColorBlack,
+		Text:       tcell.ColorWhite,
+		Border:     tcell.ColorGreen,
+		Title:      tcell.ColorWhite,
+		Label:      tcell.ColorGreen,
+		Self:       tcell.ColorGreen,
+		Peer:       tcell.ColorBlue,
+		Direct:     tcell.ColorFuchsia,
+		Active:     tcell.ColorYellow,
+		Idle:       tcell.ColorGray,
+		Palette: []tcell.Color{
+			tcell.ColorDodgerBlue, tcell.ColorAqua, tcell.ColorGold, tcell.ColorOrange,
+			tcell.ColorViolet, tcell.ColorSalmon, tcell.ColorSkyblue, tcell.ColorPink,
+		},
+	},
+	"light": {
+		Background: tcell.ColorWhite,
+		Text:       tcell.ColorBlack,
+		Border:     tcell.ColorNavy,
+		Title:      tcell.ColorBlack,
+		Label:      tcell.ColorDarkGreen,
+		Self:       tcell.ColorDarkGreen,
+		Peer:       tcell.ColorNavy,
+		Direct:     tcell.ColorPurple,
+		Active:     tcell.ColorMaroon,
+		Idle:       tcell.ColorGray,
+		Palette: []tcell.Color{
+			tcell.ColorNavy, tcell.ColorTeal, tcell.ColorMaroon, tcell.ColorOlive,
+			tcell.ColorIndigo, tcell.ColorSaddleBrown, tcell.ColorCrimson, tcell.ColorDarkCyan,
+		},
+	},
+	"mono": {
+		Background: tcell.ColorDefault,
+		Text:       tcell.ColorDefault,
+		Border:     tcell.ColorDefault,
+		Title:      tcell.ColorDefault,
+		Label:      tcell.ColorDefault,
+		Self:       tcell.ColorDefault,
+		Peer:       tcell.ColorDefault,
+		Direct:     tcell.ColorDefault,
+		Active:     tcell.ColorDefault,
+		Idle:       tcell.ColorDefault,
+	},
+}
+
+// LookupTheme returns the theme with the given name.
+func LookupTheme(name string) (Theme, error) {
+	theme, ok := Themes[strings.ToLower(name)]
+	if !ok {
+		return Theme{}, fmt.Errorf("unknown theme %q, choose one of %s", name, strings.Join(themeNames(), ", "))
+	}
+	return theme, nil
+}
+
+// themeNames returns the names of the selectable themes, sorted.
+func themeNames() []string {
+	names := make([]string, 0, len(Themes))
+	for name := range Themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// styleBox applies the theme to the border, title and background of a widget.
+func (t Theme) styleBox(box *tview.Box) {
+	box.SetBackgroundColor(t.Background).
+		SetBorderColor(t.Border).
+		SetTitleColor(t.Title)
+}
+
+// peerColor returns the sender color of a peer. The color is derived from a hash of the
+// peer ID, so each peer keeps the same color across sessions and rooms.
+func (t Theme) peerColor(senderID string) tcell.Color {
+	if len(t.Palette) == 0 {
+		return t.Peer
+	}
+	h := fnv.New32a()
+	h.Write([]byte(senderID))
+	return t.Palette[h.Sum32()%uint32(len(t.Palette))]
+}
+
+// SetTheme restyles the chat UI with a theme. Lines already in the message box keep
+// their colors.
+func (ui *UI) SetTheme(theme Theme) {
+	ui.theme.Store(&theme)
+	ui.App.QueueUpdateDraw(ui.applyTheme)
+}
+
+// currentTheme returns the theme the UI is styled with.
+func (ui *UI) currentTheme() Theme {
+	return *ui.theme.Load()
+}
+
+// applyTheme restyles every widget with the current theme. It must be called from
+// the UI goroutine.
+func (ui *UI) applyTheme() {
+	theme := ui.currentTheme()
+	for _, view := range []*tview.TextView{ui.titleBox, ui.TabBar, ui.MessageBox, ui.PeerBox, ui.usageBox} {
+		theme.styleBox(view.Box)
+		view.SetTextColor(theme.Text)
+	}
+	ui.typingBox.SetTextColor(theme.Idle).
+		SetBackgroundColor(theme.Background)
+	theme.styleBox(ui.InputBox.Box)
+	ui.InputBox.SetLabelColor(theme.Label).
+		SetFieldBackgroundColor(theme.Background).
+		SetFieldTextColor(theme.Text)
+}
+
+// cmdTheme switches the UI to another theme.
+func (ui *UI) cmdTheme(arg string) {
+	if arg == "" {
+		ui.OnLog(ChatLog{Prefix: "info", Msg: "available themes: " + strings.Join(themeNames(), ", ")})
+		return
+	}
+	theme, err := LookupTheme(arg)
+	if err != nil {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: err.Error()})
+		return
+	}
+	ui.SetTheme(theme)
+}
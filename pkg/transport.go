@@ -0,0 +1,101 @@
+package pkg
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// RoomTransport carries the frames of a single chat room between peers. The default
+// transport is a PubSub topic; another one can be given with WithTransport.
+type RoomTransport interface {
+	// Publish sends a frame to the peers in the room.
+	Publish(ctx context.Context, data []byte) error
+	// Subscribe starts receiving the frames published in the room. It is called again
+	// after a subscription fails.
+	Subscribe() (RoomSubscription, error)
+	// Peers returns the peers currently in the room.
+	Peers() []peer.ID
+	// Close leaves the room. Subscriptions are cancelled before it is called.
+	Close() error
+}
+
+// RoomSubscription receives the frames published in a room.
+type RoomSubscription interface {
+	// Next blocks until the next frame arrives, the context is done or the
+	// subscription fails.
+	Next(ctx context.Context) (RoomFrame, error)
+	// Cancel stops the subscription.
+	Cancel()
+}
+
+// RoomFrame is a frame received by a RoomSubscription.
+type RoomFrame struct {
+	Data         []byte  // Frame as published
+	Author       peer.ID // Peer that published the frame; empty or invalid if it is unknown
+	ReceivedFrom peer.ID // Peer the frame arrived from, the host itself for frames it published
+}
+
+// TransportFunc opens the transport of a room, given its name.
+type TransportFunc func(roomName string) (RoomTransport, error)
+
+// WithTransport carries the room over the transports opened by open instead of
+// PubSub. A function rather than a transport is taken, as the options of a room are
+// reused when joining the next one.
+func WithTransport(open TransportFunc) RoomOption {
+	return func(cr *ChatRoom) {
+		cr.openTransport = open
+	}
+}
+
+// pubsubTransport is the default RoomTransport, a PubSub topic.
+type pubsubTransport struct {
+	topic *pubsub.Topic
+}
+
+// pubsubTransport joins the PubSub topic of a room.
+func (p *PeerNetwork) pubsubTransport(roomName string) (RoomTransport, error) {
+	topic, err := p.PubSub.Join(p.topicName(roomName))
+	if err != nil {
+		return nil, err
+	}
+	return pubsubTransport{topic: topic}, nil
+}
+
+func (t pubsubTransport) Publish(ctx context.Context, data []byte) error {
+	return t.topic.Publish(ctx, data)
+}
+
+func (t pubsubTransport) Subscribe() (RoomSubscription, error) {
+	sub, err := t.topic.Subscribe()
+	if err != nil {
+		return nil, err
+	}
+	return pubsubSubscription{sub: sub}, nil
+}
+
+func (t pubsubTransport) Peers() []peer.ID {
+	return t.topic.ListPeers()
+}
+
+func (t pubsubTransport) Close() error {
+	return t.topic.Close()
+}
+
+// pubsubSubscription is the RoomSubscription of a pubsubTransport.
+type pubsubSubscription struct {
+	sub *pubsub.Subscription
+}
+
+func (s pubsubSubscription) Next(ctx context.Context) (RoomFrame, error) {
+	msg, err := s.sub.Next(ctx)
+	if err != nil {
+		return RoomFrame{}, err
+	}
+	return RoomFrame{Data: msg.Data, Author: msg.GetFrom(), ReceivedFrom: msg.ReceivedFrom}, nil
+}
+
+func (s pubsubSubscription) Cancel() {
+	s.sub.Cancel()
+}
@@ -0,0 +1,172 @@
+package pkg
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// fakeTransport is a RoomTransport for tests, recording published frames and
+// delivering the frames and subscription errors a test hands it.
+type fakeTransport struct {
+	published chan []byte    // Frames published to the room
+	frames    chan RoomFrame // Frames delivered to the subscription
+	failures  chan error     // Errors returned by the subscription instead of a frame
+	openErr   error          // Error returned when the transport is opened again, e.g. for another room
+
+	mu         sync.Mutex
+	subscribes int // Subscriptions opened so far
+}
+
+func newFakeTransport() *fakeTransport {
+	return &fakeTransport{
+		published: make(chan []byte, 64),
+		frames:    make(chan RoomFrame, 64),
+		failures:  make(chan error, 1),
+	}
+}
+
+// open is a TransportFunc returning the fake transport, or openErr if it is set.
+func (t *fakeTransport) open(string) (RoomTransport, error) {
+	if t.openErr != nil {
+		return nil, t.openErr
+	}
+	return t, nil
+}
+
+func (t *fakeTransport) Publish(ctx context.Context, data []byte) error {
+	select {
+	case t.published <- data:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *fakeTransport) Subscribe() (RoomSubscription, error) {
+	t.mu.Lock()
+	t.subscribes++
+	t.mu.Unlock()
+	return fakeSubscription{t}, nil
+}
+
+func (t *fakeTransport) Peers() []peer.ID { return nil }
+func (t *fakeTransport) Close() error     { return nil }
+
+// subscriptions returns the number of subscriptions opened so far.
+func (t *fakeTransport) subscriptions() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.subscribes
+}
+
+type fakeSubscription struct {
+	t *fakeTransport
+}
+
+func (s fakeSubscription) Next(ctx context.Context) (RoomFrame, error) {
+	select {
+	case frame := <-s.t.frames:
+		return frame, nil
+	case err := <-s.t.failures:
+		return RoomFrame{}, err
+	case <-ctx.Done():
+		return RoomFrame{}, ctx.Err()
+	}
+}
+
+func (s fakeSubscription) Cancel() {}
+
+// joinFakeRoom joins a room on a test host that exchanges its frames through a fake
+// transport.
+func joinFakeRoom(t *testing.T, opts ...RoomOption) (*ChatRoom, *fakeTransport) {
+	t.Helper()
+	transport := newFakeTransport()
+	opts = append(opts, WithTransport(transport.open))
+	cr, err := JoinChatRoom(newTestPeerNetwork(t), "me", "fake", opts...)
+	if err != nil {
+		t.Fatalf("JoinChatRoom: %v", err)
+	}
+	t.Cleanup(cr.Exit)
+	return cr, transport
+}
+
+// testAuthor is a remote peer signing the frames tests deliver to a room.
+type testAuthor struct {
+	id  peer.ID
+	key crypto.PrivKey
+}
+
+func newTestAuthor(t *testing.T) testAuthor {
+	t.Helper()
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testAuthor{id: id, key: key}
+}
+
+// frame encodes and signs messages into a frame as the author's room would publish it.
+func (a testAuthor) frame(t *testing.T, msgs ...ChatMessage) RoomFrame {
+	t.Helper()
+	payload, err := encodeFrame(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a.rawFrame(t, payload)
+}
+
+// rawFrame signs a serialized payload into a frame, e.g. one sent by an older client.
+func (a testAuthor) rawFrame(t *testing.T, payload []byte) RoomFrame {
+	t.Helper()
+	data, err := signFrame(a.key, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return RoomFrame{Data: data, Author: a.id, ReceivedFrom: a.id}
+}
+
+// message returns a chat message sent by the author.
+func (a testAuthor) message(text string) ChatMessage {
+	return ChatMessage{
+		Message:    text,
+		SenderID:   a.id.Pretty(),
+		SenderName: "peer",
+		ID:         newMessageID(),
+		Version:    MessageVersion,
+	}
+}
+
+// nextPublished waits for the next frame a room publishes other than its presence
+// announcements and returns the messages it carries.
+func nextPublished(t *testing.T, cr *ChatRoom, transport *fakeTransport) []ChatMessage {
+	t.Helper()
+	for {
+		var data []byte
+		select {
+		case data = <-transport.published:
+		case <-time.After(testTimeout):
+			t.Fatal("timed out waiting for a published frame")
+		}
+		payload, err := verifyFrame(data, cr.selfID)
+		if err != nil {
+			t.Fatalf("verifyFrame: %v", err)
+		}
+		msgs, err := decodeFrame(payload)
+		if err != nil {
+			t.Fatalf("decodeFrame: %v", err)
+		}
+		if len(msgs) == 1 && msgs[0].Type == MessageTypePresence {
+			continue
+		}
+		return msgs
+	}
+}
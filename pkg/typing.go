@@ -0,0 +1,113 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rivo/tview"
+	"github.com/sirupsen/logrus"
+)
+
+// typingInterval is the minimum time between two typing notifications, so a user
+// typing continuously sends one every few seconds rather than one per keystroke.
+const typingInterval = 3 * time.Second
+
+// typingTTL is how long a peer is shown as typing after its last notification. It
+// outlasts typingInterval so the indicator stays up while the peer keeps typing.
+const typingTTL = 5 * time.Second
+
+// NotifyTyping tells the room that the user is composing a message. It is cheap enough
+// to call on every keystroke: notifications are throttled to one per typingInterval,
+// and only sent once every peer in the room announced CapabilityTyping, so older
+// clients never receive them. Typing notifications are never recorded in the history.
+func (cr *ChatRoom) NotifyTyping() {
+	cr.typingMu.Lock()
+	if time.Since(cr.typingSent) < typingInterval {
+		cr.typingMu.Unlock()
+		return
+	}
+	cr.typingSent = time.Now()
+	cr.typingMu.Unlock()
+
+	peers := cr.PeerList()
+	if len(peers) == 0 {
+		return
+	}
+	for _, id := range peers {
+		if !cr.PeerSupports(id, CapabilityTyping) {
+			return
+		}
+	}
+
+	go func() {
+		typing := cr.newChatMessage("")
+		typing.Type = MessageTypeTyping
+		if err := cr.publishFrame(typing); err != nil {
+			logrus.Debugf("Failed to send typing notification to room '%s': %v", cr.RoomName, err)
+		}
+	}()
+}
+
+// setTyping records that a peer is composing a message.
+func (cr *ChatRoom) setTyping(id peer.ID) {
+	cr.namesMu.Lock()
+	cr.typing[id] = time.Now()
+	cr.namesMu.Unlock()
+}
+
+// clearTyping records that a peer is no longer composing, as its message arrived.
+func (cr *ChatRoom) clearTyping(id peer.ID) {
+	cr.namesMu.Lock()
+	delete(cr.typing, id)
+	cr.namesMu.Unlock()
+}
+
+// TypingPeers returns the peers that sent a typing notification within typingTTL and
+// no message since. Expired notifications are forgotten.
+func (cr *ChatRoom) TypingPeers() []peer.ID {
+	cr.namesMu.Lock()
+	defer cr.namesMu.Unlock()
+
+	var peers []peer.ID
+	for id, since := range cr.typing {
+		if time.Since(since) >= typingTTL {
+			delete(cr.typing, id)
+			continue
+		}
+		peers = append(peers, id)
+	}
+	return peers
+}
+
+// updateTyping refreshes the typing indicator below the message box for the room
+// shown in it, leaving out ignored peers.
+func (ui *UI) updateTyping() {
+	ui.App.QueueUpdateDraw(func() {
+		cr := ui.active.room.Load()
+		var names []string
+		for _, id := range cr.TypingPeers() {
+			if !ui.ignored.Contains(id.Pretty()) {
+				names = append(names, cr.displayName(id))
+			}
+		}
+		sort.Strings(names)
+		ui.typingBox.SetText(tview.Escape(typingText(names)))
+	})
+}
+
+// typingText describes who is typing, e.g. "alice and bob are typing…".
+func typingText(names []string) string {
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("%s is typing…", names[0])
+	case 2, 3:
+		return fmt.Sprintf("%s and %s are typing…", strings.Join(names[:len(names)-1], ", "), names[len(names)-1])
+	default:
+		return fmt.Sprintf("%d people are typing…", len(names))
+	}
+}
@@ -3,14 +3,18 @@ package pkg
 import (
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gdamore/tcell/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/rivo/tview"
 )
 
 // UI manages the chat room interface and user interactions.
 type UI struct {
+	// Room targeted by input, reassigned by the event loop; other goroutines use CurrentRoom
 	*ChatRoom
 	App        *tview.Application
 	MsgInputs  chan string
@@ -18,8 +22,36 @@ type UI struct {
 	PeerBox    *tview.TextView
 	MessageBox *tview.TextView
 	InputBox   *tview.InputField
+	TabBar     *tview.TextView
+	Pages      *tview.Pages
+
+	titleBox  *tview.TextView // Title bar at the top of the layout
+	usageBox  *tview.TextView // Command summary at the bottom of the layout
+	typingBox *tview.TextView // Typing indicator below the message box
+
+	inputHistory *inputHistory         // Recently submitted input lines
+	theme        atomic.Pointer[Theme] // Colors the UI is styled with
+	notifier     Notifier              // Reports mentions while unfocused, nil when disabled
+	focused      atomic.Bool           // Whether the terminal window has focus
+	confirmExit  bool                  // Whether /exit asks for confirmation
+	idleAfter    time.Duration         // Silence after which a peer is shown as idle
+	ignored      ignoreList            // Peers whose messages are hidden for the session
+	macros       map[string]string     // Text macros by name, including the leading slash
+	profiles     map[string]string     // Name colors of the profiles used this session, by name
+
+	rooms       []*roomView              // Joined rooms in tab order, owned by the event loop
+	currentRoom atomic.Pointer[ChatRoom] // Room targeted by input, readable from any goroutine
+	current     *roomView                // Room targeted by input, owned by the event loop
+	active      *roomView                // Room shown in the message box, owned by the UI goroutine
+	tabs        []*roomView              // Rooms shown in the tab bar, owned by the UI goroutine
+
+	done      chan struct{} // Closed when the UI is stopped
+	closeOnce sync.Once     // Guards closing done
 }
 
+// DefaultIdleThreshold is the default silence after which a peer is shown as idle.
+const DefaultIdleThreshold = 5 * time.Minute
+
 // UICommand represents a user input command.
 type UICommand struct {
 	CommandType string
@@ -33,42 +65,178 @@ func NewUI(cr *ChatRoom) *UI {
 	cmdChan := make(chan UICommand, 1)
 	msgChan := make(chan string, 1)
 
-	titleBox := createTitleBox()
-	messageBox := createMessageBox(cr.RoomName)
-	usageBox := createUsageBox()
-	peerBox := createPeerBox()
-	inputField := createInputField(cr.UserName, cmdChan, msgChan)
+	theme := Themes[DefaultTheme]
+	titleBox := createTitleBox(theme)
+	tabBar := createTabBar(theme)
+	messageBox := createMessageBox(cr.RoomName, theme)
+	usageBox := createUsageBox(theme)
+	typingBox := createTypingBox(theme)
+	peerBox := createPeerBox(theme)
+	history := &inputHistory{}
+	completer := &tabCompleter{}
+	inputField := createInputField(cr.UserName(), theme, history, completer, cmdChan, msgChan)
 
 	layout := tview.NewFlex().SetDirection(tview.FlexRow).
 		AddItem(titleBox, 3, 1, false).
+		AddItem(tabBar, 1, 1, false).
 		AddItem(tview.NewFlex().SetDirection(tview.FlexColumn).
-			AddItem(messageBox, 0, 1, false).
+			AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+				AddItem(messageBox, 0, 1, false).
+				AddItem(typingBox, 1, 1, false), 0, 1, false).
 			AddItem(peerBox, 20, 1, false), 0, 8, false).
 		AddItem(inputField, 3, 1, true).
 		AddItem(usageBox, 3, 1, false)
 
-	app.SetRoot(layout, true)
+	// Dialogs are shown as pages on top of the main layout
+	pages := tview.NewPages().AddPage("main", layout, true, true)
+	app.SetRoot(pages, true)
 
-	return &UI{
+	ui := &UI{
 		ChatRoom:   cr,
 		App:        app,
 		PeerBox:    peerBox,
 		MessageBox: messageBox,
 		InputBox:   inputField,
+		TabBar:     tabBar,
+		Pages:      pages,
+		titleBox:   titleBox,
+		usageBox:   usageBox,
+		typingBox:  typingBox,
 		MsgInputs:  msgChan,
 		CmdInputs:  cmdChan,
+
+		inputHistory: history,
+		confirmExit:  true,
+		idleAfter:    DefaultIdleThreshold,
+		macros:       make(map[string]string, len(DefaultMacros)),
+		profiles:     make(map[string]string),
+		done:         make(chan struct{}),
 	}
+	completer.peers = ui.completionPeers
+	inputField.SetChangedFunc(func(text string) {
+		// Commands are not messages, so typing one is not announced
+		if text != "" && !strings.HasPrefix(text, "/") {
+			ui.CurrentRoom().NotifyTyping()
+		}
+	})
+	ui.theme.Store(&theme)
+	ui.SetMacros(DefaultMacros)
+
+	// Show the initial room, replaying its history before live messages arrive
+	view := newRoomView(ui, cr)
+	ui.rooms, ui.tabs = []*roomView{view}, []*roomView{view}
+	ui.current, ui.active = view, view
+	ui.currentRoom.Store(cr)
+	view.renderBacklog(cr)
+	ui.renderTabs()
+	go view.forward(cr)
+
+	// Scroll back with the mouse wheel, ignoring clicks so the input field keeps focus
+	app.SetMouseCapture(func(event *tcell.EventMouse, action tview.MouseAction) (*tcell.EventMouse, tview.MouseAction) {
+		if action == tview.MouseScrollUp || action == tview.MouseScrollDown {
+			return event, action
+		}
+		return nil, action
+	})
+
+	// Cycle through the open rooms with Ctrl+N
+	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyCtrlN {
+			go func() { cmdChan <- UICommand{CommandType: "/switch"} }()
+			return nil
+		}
+		return event
+	})
+	return ui
 }
 
 // Run starts the application UI.
 func (ui *UI) Run() error {
+	// Track the terminal window focus so mentions are only notified while away
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return err
+	}
+	ui.App.SetScreen(focusScreen{Screen: screen, focused: &ui.focused})
+	ui.App.EnableMouse(true)
+
 	go ui.handleEvents()
 	return ui.App.Run()
 }
 
-// Close stops the UI and leaves the chat room.
+// SetConfirmExit sets whether /exit asks for confirmation before exiting. It is
+// enabled by default; /quit never asks.
+func (ui *UI) SetConfirmExit(confirm bool) {
+	ui.confirmExit = confirm
+}
+
+// SetIdleThreshold sets how long a peer must have been silent to be shown as idle in
+// the peer list. It defaults to DefaultIdleThreshold.
+func (ui *UI) SetIdleThreshold(threshold time.Duration) {
+	ui.idleAfter = threshold
+}
+
+// confirmAndExit asks whether to exit in a dialog, stopping the UI if confirmed.
+// Enter confirms the focused button and Escape cancels. It must be called from the
+// UI goroutine.
+func (ui *UI) confirmAndExit() {
+	modal := tview.NewModal().
+		SetText("Leave every room and exit PeerNet?").
+		AddButtons([]string{"Exit", "Cancel"}).
+		SetDoneFunc(func(_ int, label string) {
+			if label == "Exit" {
+				ui.Close()
+				return
+			}
+			ui.Pages.RemovePage("exit")
+			ui.App.SetFocus(ui.InputBox)
+		})
+	ui.Pages.AddPage("exit", modal, false, true)
+	ui.App.SetFocus(modal)
+}
+
+// confirmClearHistory asks whether to delete the stored history of the room shown in
+// the message box, and clears the room's messages and history if confirmed.
+func (ui *UI) confirmClearHistory() {
+	v := ui.active
+	cr := v.room.Load()
+	modal := tview.NewModal().
+		SetText(fmt.Sprintf("Delete the stored history of %s? This cannot be undone.", tview.Escape(cr.RoomName))).
+		AddButtons([]string{"Delete", "Cancel"}).
+		SetDoneFunc(func(_ int, label string) {
+			ui.Pages.RemovePage("clearhistory")
+			ui.App.SetFocus(ui.InputBox)
+			if label != "Delete" {
+				return
+			}
+
+			v.transcript = nil
+			if v.active() {
+				ui.MessageBox.Clear()
+			}
+			go func() {
+				purged, err := cr.ClearHistory()
+				if err != nil {
+					ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("could not clear the history of %s: %s", cr.RoomName, err)})
+					return
+				}
+				ui.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("deleted %d messages from the history of %s", purged, cr.RoomName)})
+			}()
+		})
+	ui.Pages.AddPage("clearhistory", modal, false, true)
+	ui.App.SetFocus(modal)
+}
+
+// CurrentRoom returns the room targeted by input. Unlike the embedded ChatRoom, it is
+// safe to call from any goroutine.
+func (ui *UI) CurrentRoom() *ChatRoom {
+	return ui.currentRoom.Load()
+}
+
+// Close stops the UI, causing Run to return. The caller is responsible for
+// leaving the chat room and closing the network afterwards.
 func (ui *UI) Close() {
-	ui.psCancel()
+	ui.closeOnce.Do(func() { close(ui.done) })
 	ui.App.Stop()
 }
 
@@ -80,166 +248,400 @@ func (ui *UI) handleEvents() {
 	for {
 		select {
 		case msg := <-ui.MsgInputs:
-			ui.Outbound <- msg
-			ui.displayMessage(ui.UserName, msg, tcell.ColorGreen)
+			ui.postMessage(msg)
 		case cmd := <-ui.CmdInputs:
 			ui.processCommand(cmd)
-		case msg := <-ui.Inbound:
-			ui.displayMessage(msg.SenderName, msg.Message, tcell.ColorBlue)
-		case log := <-ui.Logs:
-			ui.displayLog(log)
+		case msg := <-ui.Host.DirectInbound:
+			if ui.ignored.Contains(msg.SenderID) {
+				continue
+			}
+			ui.displayMessage(msg.SenderName+" (dm)", msg.Message, msg.Timestamp, ui.currentTheme().Direct, "")
+		case file := <-ui.Host.FileInbound:
+			ui.OnLog(ChatLog{Prefix: "file", Msg: fmt.Sprintf("received %s (%d bytes) from %s, saved to %s", file.Name, file.Size, ui.displayName(file.From), file.Path)})
+		case receipt := <-ui.Host.DirectReceipts:
+			ui.showReceipt(receipt)
 		case <-ticker.C:
 			ui.updatePeerBox()
-		case <-ui.psCtx.Done():
+			ui.updateStatus()
+			ui.updateTyping()
+		case <-ui.done:
 			return
 		}
 	}
 }
 
+// OnMessage implements Handler by rendering a message or reaction in the active room.
+func (ui *UI) OnMessage(msg ChatMessage) {
+	ui.App.QueueUpdateDraw(func() {
+		ui.active.showMessage(msg)
+	})
+}
+
+// OnLog implements Handler by rendering a log message.
+func (ui *UI) OnLog(log ChatLog) {
+	ui.displayLog(log)
+}
+
+// OnPeerJoin implements Handler by announcing the peer and refreshing the peer list.
+func (ui *UI) OnPeerJoin(id peer.ID) {
+	ui.displayLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("%s joined", ui.displayName(id))})
+	ui.updatePeerBox()
+}
+
+// OnPeerLeave implements Handler by announcing the peer and refreshing the peer list.
+func (ui *UI) OnPeerLeave(id peer.ID) {
+	ui.displayLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("%s left", ui.displayName(id))})
+	ui.updatePeerBox()
+}
+
 // processCommand interprets and executes user commands.
 func (ui *UI) processCommand(cmd UICommand) {
-	switch cmd.CommandType {
-	case "/exit":
-		ui.Close()
-	case "/clear":
-		ui.App.QueueUpdateDraw(func() {
-			ui.MessageBox.Clear()
-		})
-	case "/room":
-		if cmd.Argument == "" {
-			ui.Logs <- chatLog{Prefix: "error", Msg: "missing room name"}
-		} else {
-			ui.switchRoom(cmd.Argument)
+	spec, ok := lookupCommand(cmd.CommandType)
+	if !ok {
+		if message, ok := ui.expandMacro(cmd.CommandType, cmd.Argument); ok {
+			ui.postMessage(message)
+			return
 		}
-	case "/user":
-		if cmd.Argument == "" {
-			ui.Logs <- chatLog{Prefix: "error", Msg: "missing username"}
-		} else {
-			ui.UpdateUser(cmd.Argument)
-			ui.InputBox.SetLabel(ui.UserName + " > ")
+		ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("unsupported command: %s (try /help)", cmd.CommandType)})
+		return
+	}
+	spec.Handler(ui, cmd.Argument)
+}
+
+// postMessage sends a message to the current room and echoes it in the message box.
+func (ui *UI) postMessage(message string) {
+	chatMsg, err := ui.Post(message)
+	if err != nil {
+		if err != ErrMessageDropped {
+			ui.OnLog(ChatLog{Prefix: "puberr", Msg: err.Error()})
 		}
-	default:
-		ui.Logs <- chatLog{Prefix: "error", Msg: fmt.Sprintf("unsupported command: %s", cmd.CommandType)}
+		return
 	}
+	ui.displayChatMessage(chatMsg, senderColor(chatMsg, ui.currentTheme().Self))
 }
 
-// switchRoom switches the chat room.
-func (ui *UI) switchRoom(roomName string) {
-	ui.Logs <- chatLog{Prefix: "info", Msg: fmt.Sprintf("switching to room '%s'", roomName)}
+// switchRoom replaces the current room with another, encrypting the new room with the
+// passphrase if given. The current room is left only once the new one is joined, so
+// a failed switch stays in the current room. Switching to a room open in another tab
+// switches to that tab instead.
+func (ui *UI) switchRoom(roomName, passphrase string) {
+	roomName, err := NormalizeRoomName(roomName)
+	if err != nil {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("could not switch rooms: %s", err)})
+		return
+	}
+	v := ui.current
+	oldChatRoom := v.room.Load()
+	if roomName == oldChatRoom.RoomName {
+		ui.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("already in room '%s'", roomName)})
+		return
+	}
+	if open, ok := ui.findRoom(roomName); ok {
+		ui.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("room '%s' is already open, switching to its tab", roomName)})
+		ui.activate(open)
+		return
+	}
+	ui.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("switching to room '%s'", roomName)})
 
-	newChatRoom, err := JoinChatRoom(ui.Host, ui.UserName, roomName)
+	// The room key never carries over from the previous room
+	opts := append(append([]RoomOption(nil), oldChatRoom.opts...), WithRoomKey(passphrase), WithUserColor(ui.UserColor), WithHandler(v))
+	newChatRoom, err := JoinChatRoom(ui.Host, ui.UserName(), roomName, opts...)
 	if err != nil {
-		ui.Logs <- chatLog{Prefix: "error", Msg: fmt.Sprintf("could not switch rooms: %s", err)}
+		ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("could not switch to room '%s': %s; staying in room '%s'", roomName, err, oldChatRoom.RoomName)})
 		return
 	}
 
-	ui.ChatRoom.Exit()
+	// Send to the new room before leaving the old one, so input always has a room.
+	// Leaving flushes the old room's queue, which must not block the event loop.
+	v.room.Store(newChatRoom)
 	ui.ChatRoom = newChatRoom
-	time.Sleep(time.Second)
+	ui.currentRoom.Store(newChatRoom)
+	go oldChatRoom.Exit()
 
 	ui.App.QueueUpdateDraw(func() {
-		ui.MessageBox.Clear()
-		ui.MessageBox.SetTitle(fmt.Sprintf("ChatRoom-%s", ui.ChatRoom.RoomName))
+		v.reset(newChatRoom.RoomName)
+		v.renderBacklog(newChatRoom)
+		if v.active() {
+			ui.MessageBox.SetTitle(fmt.Sprintf("ChatRoom-%s", tview.Escape(newChatRoom.RoomName)))
+			ui.redraw()
+			ui.MessageBox.ScrollToEnd()
+		}
+		ui.renderTabs()
 	})
 }
 
-// displayMessage renders messages in the message box.
-func (ui *UI) displayMessage(sender, message string, color tcell.Color) {
+// sendDirect sends a private message to a peer known to a room and echoes it in the
+// message box, marked as delivered once the peer acknowledges it or as failed if it
+// does not. A message to an offline peer is marked as queued until it is delivered or
+// given up.
+func (ui *UI) sendDirect(cr *ChatRoom, short, message string) {
+	to, chatMsg, err := cr.prepareDirect(short, message)
+	if err != nil {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("could not send direct message: %s", err)})
+		return
+	}
+
+	views := make(chan *roomView, 1)
+	sender := cr.UserName()
 	ui.App.QueueUpdateDraw(func() {
-		fmt.Fprintf(ui.MessageBox, "[%s]<%s>[-] %s\n", color, sender, message)
-		ui.MessageBox.ScrollToEnd()
+		v := ui.active
+		v.writeDirect(fmt.Sprintf("%s -> %s", sender, short), message, chatMsg.Timestamp, ui.currentTheme().Direct, chatMsg.ID)
+		views <- v
 	})
+
+	go func() {
+		queued, err := cr.Host.SendOrQueueDirect(to, chatMsg)
+		state := deliveryAcked
+		switch {
+		case err != nil:
+			state = deliveryFailed
+			ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("direct message to %s was not delivered: %s", short, err)})
+		case queued:
+			state = deliveryQueued
+		}
+
+		var v *roomView
+		select {
+		case v = <-views:
+		case <-ui.done:
+			return
+		}
+		ui.App.QueueUpdateDraw(func() {
+			if v.setDelivery(chatMsg.ID, state) && v.active() {
+				ui.redraw()
+			}
+		})
+	}()
+}
+
+// showReceipt updates the delivery mark of a direct message that was queued for an
+// offline peer, in whichever room view shows it.
+func (ui *UI) showReceipt(receipt DirectReceipt) {
+	state := deliveryAcked
+	if !receipt.Delivered {
+		state = deliveryFailed
+		ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("gave up direct message to %s, the peer did not come back", ui.displayName(receipt.To))})
+	}
+
+	views := append([]*roomView(nil), ui.rooms...)
+	ui.App.QueueUpdateDraw(func() {
+		for _, v := range views {
+			if v.setDelivery(receipt.ID, state) && v.active() {
+				ui.redraw()
+			}
+		}
+	})
+}
+
+// sendFile sends a file to a room and reports the outcome in the message box.
+func (ui *UI) sendFile(cr *ChatRoom, path string) {
+	ui.OnLog(ChatLog{Prefix: "file", Msg: fmt.Sprintf("sending %s", path)})
+	sent, err := cr.SendFile(path)
+	if err != nil {
+		ui.OnLog(ChatLog{Prefix: "error", Msg: fmt.Sprintf("could not send file to every peer: %s", err)})
+	}
+	if sent > 0 {
+		ui.OnLog(ChatLog{Prefix: "file", Msg: fmt.Sprintf("sent %s to %d peers", path, sent)})
+	}
+}
+
+// displayMessage renders messages in the active room's message box, prefixed with the
+// time they were sent. The message ID ties the line to its reactions and may be empty.
+func (ui *UI) displayMessage(sender, message string, timestamp time.Time, color tcell.Color, msgID string) {
+	ui.App.QueueUpdateDraw(func() {
+		ui.active.writeMessage(sender, message, timestamp, color, msgID)
+	})
+}
+
+// displayChatMessage renders a room message or /me action in the active room's message box.
+func (ui *UI) displayChatMessage(msg ChatMessage, color tcell.Color) {
+	ui.App.QueueUpdateDraw(func() {
+		ui.active.writeChatMessage(msg, color)
+	})
+}
+
+// writeLine appends a line to the active room. It must be called from the UI goroutine.
+func (ui *UI) writeLine(text, msgID string) {
+	ui.active.writeLine(text, msgID)
+}
+
+// renderLine writes a transcript line of a room and its reactions to the message box.
+func (ui *UI) renderLine(v *roomView, line transcriptLine) {
+	if line.directID != "" {
+		fmt.Fprintln(ui.MessageBox, line.text+" "+line.delivery.marker())
+	} else {
+		fmt.Fprintln(ui.MessageBox, line.text)
+	}
+	if line.msgID == "" {
+		return
+	}
+	if summary := v.reactions.Summary(line.msgID); summary != "" {
+		fmt.Fprintf(ui.MessageBox, "         [gray]%s[-]\n", tview.Escape(summary))
+	}
+}
+
+// redraw re-renders the message box from the active room's transcript, picking up
+// reaction changes. The scroll position is kept so a reader scrolled back is not
+// moved. It must be called from the UI goroutine.
+func (ui *UI) redraw() {
+	ui.MessageBox.Clear()
+	for _, line := range ui.active.transcript {
+		ui.renderLine(ui.active, line)
+	}
+}
+
+// clearMessages empties the message box and the active room's transcript. It must be
+// called from the UI goroutine.
+func (ui *UI) clearMessages() {
+	ui.active.transcript = nil
+	ui.MessageBox.Clear()
+}
+
+// recentMessageID returns the ID of the n-th most recent message in the active room,
+// counting from 1. It must be called from the UI goroutine.
+func (ui *UI) recentMessageID(n int) (string, bool) {
+	return ui.active.recentMessageID(n)
 }
 
-// displayLog renders logs in the message box.
-func (ui *UI) displayLog(log chatLog) {
+// displayLog renders logs in the active room's message box.
+func (ui *UI) displayLog(log ChatLog) {
 	ui.App.QueueUpdateDraw(func() {
-		fmt.Fprintf(ui.MessageBox, "[red](%s)[-] %s\n", log.Prefix, log.Msg)
-		ui.MessageBox.ScrollToEnd()
+		ui.active.writeLine(logLine(log), "")
 	})
 }
 
-// updatePeerBox refreshes the list of peers.
+// updatePeerBox refreshes the list of peers. Peers that sent a message within the
+// idle threshold are highlighted, while silent ones are dimmed.
 func (ui *UI) updatePeerBox() {
 	ui.App.QueueUpdateDraw(func() {
 		ui.PeerBox.Clear()
 
-		for _, peer := range ui.ChatRoom.PeerList() {
-			shortID := peer.Pretty()[len(peer.Pretty())-8:]
-			fmt.Fprintf(ui.PeerBox, "[yellow]%s[-]\n", shortID)
+		cr := ui.active.room.Load()
+		for _, peer := range cr.PeerList() {
+			color := ui.currentTheme().Idle
+			if last, ok := cr.LastActive(peer); ok && time.Since(last) < ui.idleAfter {
+				color = ui.currentTheme().Active
+			}
+			fmt.Fprintf(ui.PeerBox, "[%s]%s[-]\n", color, tview.Escape(cr.displayName(peer)))
+		}
+	})
+}
+
+// updateStatus refreshes the connection status in the title bar, shown in red while
+// no peers are connected.
+func (ui *UI) updateStatus() {
+	status := ui.Host.Status()
+	ui.App.QueueUpdateDraw(func() {
+		color := ui.currentTheme().Text
+		if status.Peers == 0 {
+			color = tcell.ColorRed
 		}
+		ui.titleBox.SetText(fmt.Sprintf("[%s]peers: %d | DHT: %s | NAT: %s[-]",
+			color, status.Peers, yesNo(status.DHTReady, "ready", "not ready"), natStatus(status.NAT)))
 	})
 }
 
+// yesNo returns yes if ok is set and no otherwise.
+func yesNo(ok bool, yes, no string) string {
+	if ok {
+		return yes
+	}
+	return no
+}
+
 // UI Helper Functions
 
-func createTitleBox() *tview.TextView {
+func createTitleBox(theme Theme) *tview.TextView {
 	titleBox := tview.NewTextView().
+		SetDynamicColors(true).
 		SetText("Welcome to PeerNet.").
-		SetTextColor(tcell.ColorWhite).
+		SetTextColor(theme.Text).
 		SetTextAlign(tview.AlignCenter)
 	titleBox.
 		SetBorder(true).
-		SetBorderColor(tcell.ColorGreen).
 		SetTitle("PeerNet").
-		SetTitleColor(tcell.ColorWhite).
 		SetTitleAlign(tview.AlignCenter)
+	theme.styleBox(titleBox.Box)
 	return titleBox
 }
 
-func createMessageBox(roomName string) *tview.TextView {
+func createTabBar(theme Theme) *tview.TextView {
+	tabBar := tview.NewTextView().
+		SetDynamicColors(true).
+		SetWrap(false).
+		SetTextColor(theme.Text)
+	theme.styleBox(tabBar.Box)
+	return tabBar
+}
+
+func createMessageBox(roomName string, theme Theme) *tview.TextView {
+	// The message box follows new lines until scrolled up, and again once scrolled
+	// back down to the bottom. Long lines wrap at word boundaries, so scrolling moves
+	// through wrapped lines rather than transcript lines.
 	messageBox := tview.NewTextView().
-		SetDynamicColors(true)
-	messageBox.SetBorder(true).SetBorderColor(tcell.ColorGreen).
-		SetTitle(fmt.Sprintf("ChatRoom-%s", roomName)).
-		SetTitleAlign(tview.AlignLeft).
-		SetTitleColor(tcell.ColorWhite)
+		SetDynamicColors(true).
+		SetWrap(true).
+		SetWordWrap(true).
+		SetTextColor(theme.Text).
+		ScrollToEnd()
+	messageBox.SetBorder(true).
+		SetTitle(fmt.Sprintf("ChatRoom-%s", tview.Escape(roomName))).
+		SetTitleAlign(tview.AlignLeft)
+	theme.styleBox(messageBox.Box)
 	return messageBox
 }
-func createUsageBox() *tview.TextView {
+func createTypingBox(theme Theme) *tview.TextView {
+	typingBox := tview.NewTextView().
+		SetTextColor(theme.Idle)
+	typingBox.SetBackgroundColor(theme.Background)
+	return typingBox
+}
+
+func createUsageBox(theme Theme) *tview.TextView {
 	usageBox := tview.NewTextView().
 		SetDynamicColors(true).
-		SetText(`[red]/exit[green] - exit | [red]/room <roomname>[green] - switch rooms | [red]/user <username>[green] - change name | [red]/clear[green] - clear chat`)
+		SetTextColor(theme.Text).
+		SetText(usageText())
 	usageBox.
 		SetBorder(true).
-		SetBorderColor(tcell.ColorGreen).
 		SetTitle("Usage").
 		SetTitleAlign(tview.AlignLeft).
-		SetTitleColor(tcell.ColorWhite).
 		SetBorderPadding(0, 0, 1, 0)
+	theme.styleBox(usageBox.Box)
 	return usageBox
 }
 
-func createPeerBox() *tview.TextView {
+func createPeerBox(theme Theme) *tview.TextView {
 	peerBox := tview.NewTextView().
-		SetDynamicColors(true)
+		SetDynamicColors(true).
+		SetTextColor(theme.Text)
 	peerBox.
 		SetBorder(true).
-		SetBorderColor(tcell.ColorGreen).
 		SetTitle("Peers").
-		SetTitleAlign(tview.AlignLeft).
-		SetTitleColor(tcell.ColorWhite)
+		SetTitleAlign(tview.AlignLeft)
+	theme.styleBox(peerBox.Box)
 	return peerBox
 }
 
-func createInputField(username string, cmdChan chan UICommand, msgChan chan string) *tview.InputField {
+func createInputField(username string, theme Theme, history *inputHistory, completer *tabCompleter, cmdChan chan UICommand, msgChan chan string) *tview.InputField {
 	input := tview.NewInputField().
 		SetLabel(username + " > ").
-		SetLabelColor(tcell.ColorGreen).
+		SetLabelColor(theme.Label).
 		SetFieldWidth(0).
-		SetFieldBackgroundColor(tcell.ColorBlack)
+		SetFieldBackgroundColor(theme.Background).
+		SetFieldTextColor(theme.Text)
 	input.
 		SetBorder(true).
-		SetBorderColor(tcell.ColorGreen).
 		SetTitle("Input").
 		SetTitleAlign(tview.AlignLeft).
-		SetTitleColor(tcell.ColorWhite).
 		SetBorderPadding(0, 0, 1, 0)
+	theme.styleBox(input.Box)
 
 	input.SetDoneFunc(func(key tcell.Key) {
 		if key == tcell.KeyEnter {
 			line := input.GetText()
 			if len(line) > 0 {
+				history.Add(historyEntry(line))
 				if strings.HasPrefix(line, "/") {
 					cmdParts := strings.SplitN(line, " ", 2)
 					arg := ""
@@ -255,5 +657,27 @@ func createInputField(username string, cmdChan chan UICommand, msgChan chan stri
 		}
 	})
 
+	// Recall previous inputs with the up and down arrows and complete with Tab
+	input.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyTab:
+			if line, ok := completer.Complete(input.GetText()); ok {
+				input.SetText(line)
+			}
+			return nil
+		case tcell.KeyUp:
+			if line, ok := history.Prev(input.GetText()); ok {
+				input.SetText(line)
+			}
+			return nil
+		case tcell.KeyDown:
+			if line, ok := history.Next(); ok {
+				input.SetText(line)
+			}
+			return nil
+		}
+		return event
+	})
+
 	return input
 }
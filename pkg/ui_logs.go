@@ -0,0 +1,43 @@
+package pkg
+
+import (
+	"io"
+
+	"github.com/sirupsen/logrus"
+)
+
+// uiLogHook renders logrus entries in the message box while the UI owns the terminal.
+type uiLogHook struct {
+	ui *UI
+}
+
+func (h uiLogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h uiLogHook) Fire(entry *logrus.Entry) error {
+	// Render asynchronously so logging never waits on the UI event queue
+	go h.ui.displayLog(ChatLog{Prefix: entry.Level.String(), Msg: entry.Message})
+	return nil
+}
+
+// CaptureLogs redirects the standard logrus logger into the message box so log lines
+// do not clobber the terminal screen. The returned function restores the previous
+// output and hooks, and must be called once the UI has stopped.
+func (ui *UI) CaptureLogs() func() {
+	logger := logrus.StandardLogger()
+	previousOut := logger.Out
+
+	hooks := make(logrus.LevelHooks)
+	previousHooks := logger.ReplaceHooks(hooks)
+	for level, levelHooks := range previousHooks {
+		hooks[level] = append(hooks[level], levelHooks...)
+	}
+	hooks.Add(uiLogHook{ui: ui})
+	logger.SetOutput(io.Discard)
+
+	return func() {
+		logger.ReplaceHooks(previousHooks)
+		logger.SetOutput(previousOut)
+	}
+}
@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// newTestUI creates the UI for a room, running it on a simulated screen.
+func newTestUI(t *testing.T, cr *ChatRoom) *UI {
+	t.Helper()
+	ui := NewUI(cr)
+	screen := tcell.NewSimulationScreen("")
+	screen.SetSize(120, 40)
+	ui.App.SetScreen(screen)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if err := ui.App.Run(); err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		ui.App.Stop()
+		<-stopped
+	})
+	return ui
+}
+
+func TestSwitchRoomJoinFailureKeepsRoom(t *testing.T) {
+	cr, transport := joinFakeRoom(t)
+	ui := newTestUI(t, cr)
+
+	transport.openErr = errors.New("subscribe failed")
+	ui.switchRoom("elsewhere", "")
+	if ui.CurrentRoom() != cr || ui.ChatRoom != cr || ui.current.room.Load() != cr {
+		t.Fatal("the current room changed although joining the new one failed")
+	}
+
+	// The room was not left and still publishes
+	ui.CurrentRoom().Outbound <- "still here"
+	if msgs := nextPublished(t, cr, transport); len(msgs) != 1 || msgs[0].Message != "still here" {
+		t.Errorf("published %+v, want the message sent after the failed switch", msgs)
+	}
+}
+
+func TestSwitchRoomLeavesPreviousRoom(t *testing.T) {
+	cr, _ := joinFakeRoom(t)
+	ui := newTestUI(t, cr)
+
+	ui.switchRoom("elsewhere", "")
+	next := ui.CurrentRoom()
+	if next == cr || next.RoomName != "elsewhere" {
+		t.Fatalf("current room is '%s', want 'elsewhere'", next.RoomName)
+	}
+	t.Cleanup(next.Exit)
+
+	// The previous room is left in the background
+	select {
+	case <-cr.publishDone:
+	case <-time.After(testTimeout):
+		t.Error("the previous room was not left")
+	}
+}
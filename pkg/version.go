@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Message format versions carried in ChatMessage.Version. Messages from clients that
+// predate versioning carry no version and are treated as MessageVersionLegacy.
+//
+// Optional fields and message types are added without bumping the version: receivers
+// ignore fields they do not know, default missing ones and drop messages of types they
+// do not know. The version is only bumped for changes older clients cannot degrade
+// from. Features that need the receiving peer to understand them announce a capability
+// in presence messages instead, so senders can check PeerSupports before using them.
+const (
+	MessageVersionLegacy = 0 // Messages without a version field
+	MessageVersion       = 1 // Version of the messages sent by this client
+)
+
+// Capabilities announced in presence messages.
+const (
+	CapabilityActions   = "actions"   // Understands MessageTypeAction
+	CapabilityReactions = "reactions" // Understands MessageTypeReaction
+	CapabilityTyping    = "typing"    // Understands MessageTypeTyping
+)
+
+// localCapabilities are the capabilities announced by this client.
+var localCapabilities = []string{CapabilityActions, CapabilityReactions, CapabilityTyping}
+
+// checkMessageFormat checks that a received message is in a format this client can
+// handle. Messages of unknown types, e.g. from newer clients, are rejected so they are
+// not shown as chat messages. Fields missing from legacy messages, such as the
+// timestamp, are defaulted by the receiver.
+func checkMessageFormat(msg ChatMessage) error {
+	switch msg.Type {
+	case MessageTypeChat, MessageTypeAction, MessageTypePresence, MessageTypeTyping:
+		return nil
+	case MessageTypeReaction:
+		if msg.Target == "" {
+			return errors.New("reaction without a target")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported message type %q (version %d)", msg.Type, msg.Version)
+	}
+}
+
+// setPeerCapabilities records the capabilities a peer announced in its presence.
+func (cr *ChatRoom) setPeerCapabilities(id peer.ID, caps []string) {
+	set := make(map[string]struct{}, len(caps))
+	for _, capability := range caps {
+		set[capability] = struct{}{}
+	}
+
+	cr.namesMu.Lock()
+	cr.peerCaps[id] = set
+	cr.namesMu.Unlock()
+}
+
+// PeerSupports reports whether a peer announced the given capability. Peers that have
+// not announced their presence yet, and legacy clients, support none.
+func (cr *ChatRoom) PeerSupports(id peer.ID, capability string) bool {
+	cr.namesMu.RLock()
+	defer cr.namesMu.RUnlock()
+	_, ok := cr.peerCaps[id][capability]
+	return ok
+}
@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeMessageFormats(t *testing.T) {
+	for name, tc := range map[string]struct {
+		payload string
+		want    ChatMessage
+		valid   bool
+	}{
+		"legacy": {
+			payload: `{"message":"hi","senderid":"id","sendername":"bob"}`,
+			want:    ChatMessage{Message: "hi", SenderID: "id", SenderName: "bob"},
+			valid:   true,
+		},
+		"current": {
+			payload: `{"message":"hi","senderid":"id","sendername":"bob","timestamp":"2024-01-02T03:04:05Z","id":"m1","v":1}`,
+			want:    ChatMessage{Message: "hi", SenderID: "id", SenderName: "bob", Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), ID: "m1", Version: MessageVersion},
+			valid:   true,
+		},
+		"newer with unknown fields": {
+			payload: `{"message":"hi","senderid":"id","sendername":"bob","v":2,"thread":"t1"}`,
+			want:    ChatMessage{Message: "hi", SenderID: "id", SenderName: "bob", Version: 2},
+			valid:   true,
+		},
+		"unknown type": {
+			payload: `{"message":"","senderid":"id","sendername":"bob","type":"poll","v":2}`,
+			want:    ChatMessage{SenderID: "id", SenderName: "bob", Type: "poll", Version: 2},
+		},
+		"reaction without target": {
+			payload: `{"message":"+1","senderid":"id","sendername":"bob","type":"reaction","v":1}`,
+			want:    ChatMessage{Message: "+1", SenderID: "id", SenderName: "bob", Type: MessageTypeReaction, Version: MessageVersion},
+		},
+	} {
+		msgs, err := decodeFrame([]byte(tc.payload))
+		if err != nil {
+			t.Errorf("%s: decodeFrame: %v", name, err)
+			continue
+		}
+		if len(msgs) != 1 || !reflect.DeepEqual(msgs[0], tc.want) {
+			t.Errorf("%s: decoded %+v, want %+v", name, msgs, tc.want)
+			continue
+		}
+		if err := checkMessageFormat(msgs[0]); (err == nil) != tc.valid {
+			t.Errorf("%s: checkMessageFormat = %v, want valid %t", name, err, tc.valid)
+		}
+	}
+}
+
+func TestLegacyMessageDefaults(t *testing.T) {
+	cr, transport := joinFakeRoom(t)
+	author := newTestAuthor(t)
+
+	before := time.Now()
+	transport.frames <- author.rawFrame(t, []byte(`{"message":"from an old client","senderid":"","sendername":"bob"}`))
+	msg := receive(t, cr)
+	if msg.Message != "from an old client" || msg.Version != MessageVersionLegacy {
+		t.Errorf("received %+v, want the legacy message", msg)
+	}
+	if msg.Timestamp.Before(before) {
+		t.Errorf("legacy message timestamp %s was not defaulted to the receive time", msg.Timestamp)
+	}
+	if msg.SenderID != author.id.Pretty() {
+		t.Errorf("legacy message sender %q, want the frame author %s", msg.SenderID, author.id.Pretty())
+	}
+}
@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultIsolationTimeout is the default time a room may have no peers before it is
+// reported as isolated.
+const DefaultIsolationTimeout = 30 * time.Second
+
+// maxRediscoverInterval caps the delay between discovery rounds run for an isolated room.
+const maxRediscoverInterval = 10 * time.Minute
+
+// WithIsolationWatchdog reports the room as isolated once it has had no peers for the
+// given timeout and, if rediscover is set, runs discovery rounds until peers return.
+// A zero timeout disables the watchdog.
+func WithIsolationWatchdog(timeout time.Duration, rediscover bool) RoomOption {
+	return func(cr *ChatRoom) {
+		cr.isolationTimeout = timeout
+		cr.rediscover = rediscover
+	}
+}
+
+// isolationLoop watches the room's peer list, warning when the room has been empty for
+// the isolation timeout and reporting when peers return. While the room is isolated,
+// discovery is rerun with a doubling interval, starting at the timeout.
+func (cr *ChatRoom) isolationLoop() {
+	if cr.isolationTimeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(peerWatchInterval)
+	defer ticker.Stop()
+
+	var emptySince, nextRound time.Time
+	isolated := false
+	interval := cr.isolationTimeout
+	for {
+		select {
+		case <-cr.psCtx.Done():
+			return
+		case now := <-ticker.C:
+			if peers := len(cr.PeerList()); peers > 0 {
+				if isolated {
+					cr.handler.OnLog(ChatLog{Prefix: "info", Msg: fmt.Sprintf("room is reachable again, %d peers connected", peers)})
+				}
+				emptySince, isolated = time.Time{}, false
+				continue
+			}
+
+			if emptySince.IsZero() {
+				emptySince = now
+			}
+			if !isolated && now.Sub(emptySince) >= cr.isolationTimeout {
+				isolated = true
+				interval = cr.isolationTimeout
+				nextRound = now
+				msg := fmt.Sprintf("no peers in the room for %s", cr.isolationTimeout.Round(time.Second))
+				if cr.rediscover {
+					msg += ", looking for peers"
+				}
+				cr.handler.OnLog(ChatLog{Prefix: "warn", Msg: msg})
+			}
+			if isolated && cr.rediscover && !now.Before(nextRound) {
+				go cr.runRediscover()
+				nextRound = now.Add(interval)
+				if interval *= 2; interval > maxRediscoverInterval {
+					interval = maxRediscoverInterval
+				}
+			}
+		}
+	}
+}
+
+// runRediscover runs a discovery round for an isolated room, logging failures.
+func (cr *ChatRoom) runRediscover() {
+	if err := cr.Host.Rediscover(); err != nil {
+		cr.handler.OnLog(ChatLog{Prefix: "warn", Msg: fmt.Sprintf("failed to look for peers: %s", err)})
+	}
+}
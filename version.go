@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, injected at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=...".
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// libp2pModule is the module whose version is reported alongside the build information.
+const libp2pModule = "github.com/libp2p/go-libp2p"
+
+// printVersion prints the build information and the versions of Go and libp2p.
+func printVersion() {
+	fmt.Printf("peernet %s\n", version)
+	fmt.Printf("  commit:     %s\n", commit)
+	fmt.Printf("  built:      %s\n", buildDate)
+	fmt.Printf("  go:         %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Printf("  go-libp2p:  %s\n", dependencyVersion(libp2pModule))
+}
+
+// dependencyVersion returns the version of a module compiled into the binary.
+func dependencyVersion(path string) string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == path {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return "unknown"
+}